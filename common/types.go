@@ -1,6 +1,19 @@
 package common
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrMissingPrefix = errors.New("hex string without 0x prefix")
+	ErrInvalidLength = errors.New("hex string of wrong length")
+	ErrSyntax        = errors.New("invalid hex string")
+)
 
 const (
 	HashLength    = 32
@@ -20,6 +33,39 @@ func (h *Hash) SetBytes(b []byte) {
 	copy(h[HashLength-len(b):], b)
 }
 
+// Hex returns the 0x prefixed hex encoding of the hash.
+func (h Hash) Hex() string {
+	return encodeHex(h[:])
+}
+
+// String implements fmt.Stringer.
+func (h Hash) String() string {
+	return h.Hex()
+}
+
+// MarshalText encodes the hash as 0x prefixed hex.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.Hex()), nil
+}
+
+// UnmarshalText parses a hash from 0x prefixed hex of exactly HashLength bytes.
+func (h *Hash) UnmarshalText(input []byte) error {
+	return decodeFixedHex(h[:], input, "Hash")
+}
+
+// UnmarshalJSON parses a hash from a JSON string of 0x prefixed hex, or from the
+// array of bytes JSON encoded hashes were written as before, e.g. by journals.
+func (h *Hash) UnmarshalJSON(input []byte) error {
+	return decodeFixedJSON(h[:], input, "Hash")
+}
+
+// HexToHash parses a hash from 0x prefixed hex of exactly HashLength bytes.
+func HexToHash(s string) (Hash, error) {
+	var h Hash
+	err := h.UnmarshalText([]byte(s))
+	return h, err
+}
+
 // SetBytes sets the address to the value of b.
 // If b is larger than len(a), b will be cropped from the left.
 func (a *Address) SetBytes(b []byte) {
@@ -33,6 +79,41 @@ func (a *Address) Bytes() []byte {
 	return a[:]
 }
 
+// Hex returns the 0x prefixed hex encoding of the address.
+func (a Address) Hex() string {
+	return encodeHex(a[:])
+}
+
+// String implements fmt.Stringer.
+func (a Address) String() string {
+	return a.Hex()
+}
+
+// MarshalText encodes the address as 0x prefixed hex.
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(a.Hex()), nil
+}
+
+// UnmarshalText parses an address from 0x prefixed hex of exactly AddressLength
+// bytes.
+func (a *Address) UnmarshalText(input []byte) error {
+	return decodeFixedHex(a[:], input, "Address")
+}
+
+// UnmarshalJSON parses an address from a JSON string of 0x prefixed hex, or from
+// the array of bytes JSON encoded addresses were written as before.
+func (a *Address) UnmarshalJSON(input []byte) error {
+	return decodeFixedJSON(a[:], input, "Address")
+}
+
+// HexToAddress parses an address from 0x prefixed hex of exactly AddressLength
+// bytes.
+func HexToAddress(s string) (Address, error) {
+	var a Address
+	err := a.UnmarshalText([]byte(s))
+	return a, err
+}
+
 func BytesToAddress(b []byte) Address {
 	var a Address
 	a.SetBytes(b)
@@ -43,3 +124,56 @@ func GenerateHash(input []byte) Hash {
 	hash := sha256.Sum256(input)
 	return hash
 }
+
+func encodeHex(b []byte) string {
+	enc := make([]byte, 2+2*len(b))
+	copy(enc, "0x")
+	hex.Encode(enc[2:], b)
+	return string(enc)
+}
+
+// decodeFixedHex decodes 0x prefixed hex into dst, requiring the input to fill
+// it exactly. dst is left untouched on failure.
+func decodeFixedHex(dst []byte, input []byte, typname string) error {
+	if len(input) < 2 || input[0] != '0' || (input[1] != 'x' && input[1] != 'X') {
+		return fmt.Errorf("%w: %s", ErrMissingPrefix, typname)
+	}
+	input = input[2:]
+	if len(input) != 2*len(dst) {
+		return fmt.Errorf("%w: %s needs %d hex digits, got %d", ErrInvalidLength, typname, 2*len(dst), len(input))
+	}
+	dec := make([]byte, len(dst))
+	if _, err := hex.Decode(dec, input); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrSyntax, typname, err)
+	}
+	copy(dst, dec)
+	return nil
+}
+
+// decodeFixedJSON decodes a JSON string of 0x prefixed hex into dst. A JSON array
+// of exactly len(dst) bytes, the encoding used before hex, is accepted too so
+// previously written JSON keeps loading. dst is left untouched on failure, and on
+// null, as is customary for JSON.
+func decodeFixedJSON(dst []byte, input []byte, typname string) error {
+	input = bytes.TrimSpace(input)
+	switch {
+	case bytes.Equal(input, []byte("null")):
+		return nil
+
+	case len(input) > 0 && input[0] == '[':
+		var legacy []uint8
+		if err := json.Unmarshal(input, &legacy); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrSyntax, typname, err)
+		}
+		if len(legacy) != len(dst) {
+			return fmt.Errorf("%w: %s needs %d bytes, got %d", ErrInvalidLength, typname, len(dst), len(legacy))
+		}
+		copy(dst, legacy)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(input, &text); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrSyntax, typname, err)
+	}
+	return decodeFixedHex(dst, []byte(text), typname)
+}
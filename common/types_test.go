@@ -0,0 +1,108 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestHexRoundTrip(t *testing.T) {
+	addr := BytesToAddress([]byte{0xde, 0xad, 0xbe, 0xef})
+	if have, want := addr.Hex(), "0x00000000000000000000000000000000deadbeef"; have != want {
+		t.Fatalf("address hex mismatch: have %s, want %s", have, want)
+	}
+	parsed, err := HexToAddress(addr.Hex())
+	if err != nil {
+		t.Fatalf("failed to parse address: %v", err)
+	}
+	if parsed != addr {
+		t.Fatalf("address round trip mismatch: have %v, want %v", parsed, addr)
+	}
+	hash := GenerateHash([]byte("execution"))
+	if parsed, err := HexToHash(hash.Hex()); err != nil || parsed != hash {
+		t.Fatalf("hash round trip mismatch: have %v (%v), want %v", parsed, err, hash)
+	}
+}
+
+func TestHexJSON(t *testing.T) {
+	type object struct {
+		Hash Hash    `json:"hash"`
+		Addr Address `json:"addr"`
+	}
+	in := object{Hash: Hash{0x01}, Addr: Address{0x02}}
+	enc, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	want := `{"hash":"0x0100000000000000000000000000000000000000000000000000000000000000","addr":"0x0200000000000000000000000000000000000000"}`
+	if string(enc) != want {
+		t.Fatalf("json mismatch: have %s, want %s", enc, want)
+	}
+	var out object
+	if err := json.Unmarshal(enc, &out); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if out != in {
+		t.Fatalf("json round trip mismatch: have %v, want %v", out, in)
+	}
+}
+
+func TestHexMalformed(t *testing.T) {
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{"", ErrMissingPrefix},
+		{"00000000000000000000000000000000deadbeef", ErrMissingPrefix},
+		{"0x", ErrInvalidLength},
+		{"0xdeadbeef", ErrInvalidLength},
+		{"0x00000000000000000000000000000000deadbeef00", ErrInvalidLength},
+		{"0x00000000000000000000000000000000deadbeeg", ErrSyntax},
+	}
+	for _, tt := range tests {
+		if _, err := HexToAddress(tt.input); !errors.Is(err, tt.err) {
+			t.Errorf("input %q: error mismatch: have %v, want %v", tt.input, err, tt.err)
+		}
+	}
+	if _, err := HexToHash("0x00000000000000000000000000000000deadbeef"); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("short hash error mismatch: have %v, want %v", err, ErrInvalidLength)
+	}
+}
+
+// Tests that hashes and addresses written as byte arrays, the JSON encoding used
+// before hex, still decode, while malformed arrays are rejected.
+func TestLegacyJSON(t *testing.T) {
+	type legacy struct {
+		Hash [HashLength]byte    `json:"hash"`
+		Addr [AddressLength]byte `json:"addr"`
+	}
+	type object struct {
+		Hash Hash    `json:"hash"`
+		Addr Address `json:"addr"`
+	}
+	enc, err := json.Marshal(legacy{Hash: [HashLength]byte{0x01, 0xff}, Addr: [AddressLength]byte{0x02}})
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	var out object
+	if err := json.Unmarshal(enc, &out); err != nil {
+		t.Fatalf("failed to decode legacy json %s: %v", enc, err)
+	}
+	if want := (object{Hash: Hash{0x01, 0xff}, Addr: Address{0x02}}); out != want {
+		t.Fatalf("legacy json mismatch: have %v, want %v", out, want)
+	}
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{`{"addr":[1,2,3]}`, ErrInvalidLength},
+		{`{"addr":[0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,256]}`, ErrSyntax},
+		{`{"addr":12}`, ErrSyntax},
+		{`{"addr":"deadbeef"}`, ErrMissingPrefix},
+	}
+	for _, tt := range tests {
+		if err := json.Unmarshal([]byte(tt.input), new(object)); !errors.Is(err, tt.err) {
+			t.Errorf("input %s: error mismatch: have %v, want %v", tt.input, err, tt.err)
+		}
+	}
+}
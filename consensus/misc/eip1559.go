@@ -0,0 +1,50 @@
+package misc
+
+import (
+	"execution/params"
+	"execution/types"
+	"math/big"
+)
+
+// CalcBaseFee calculates the base fee of the block following the given parent
+// header, as defined by EIP-1559. Blocks without a base fee are followed by
+// blocks without one, in which case nil is returned.
+func CalcBaseFee(parent types.Header) *big.Int {
+	if parent.BaseFee() == nil {
+		return nil
+	}
+	parentGasTarget := parent.GasLimit() / params.ElasticityMultiplier
+
+	// If the parent gasUsed is the same as the target, the baseFee remains unchanged.
+	if parentGasTarget == 0 || parent.GasUsed() == parentGasTarget {
+		return new(big.Int).Set(parent.BaseFee())
+	}
+	var (
+		num   = new(big.Int)
+		denom = new(big.Int)
+	)
+	if parent.GasUsed() > parentGasTarget {
+		// If the parent block used more gas than its target, the baseFee should increase.
+		// max(1, parentBaseFee * gasUsedDelta / parentGasTarget / baseFeeChangeDenominator)
+		num.SetUint64(parent.GasUsed() - parentGasTarget)
+		num.Mul(num, parent.BaseFee())
+		num.Div(num, denom.SetUint64(parentGasTarget))
+		num.Div(num, denom.SetUint64(params.BaseFeeChangeDenominator))
+		if num.Sign() == 0 {
+			num.SetUint64(1)
+		}
+		return num.Add(num, parent.BaseFee())
+	}
+	// Otherwise if the parent block used less gas than its target, the baseFee should decrease.
+	// max(0, parentBaseFee - parentBaseFee * gasUsedDelta / parentGasTarget / baseFeeChangeDenominator)
+	num.SetUint64(parentGasTarget - parent.GasUsed())
+	num.Mul(num, parent.BaseFee())
+	num.Div(num, denom.SetUint64(parentGasTarget))
+	num.Div(num, denom.SetUint64(params.BaseFeeChangeDenominator))
+
+	baseFee := num.Sub(parent.BaseFee(), num)
+	if baseFee.Sign() < 0 {
+		baseFee.SetUint64(0)
+	}
+	return baseFee
+}
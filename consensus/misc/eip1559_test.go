@@ -0,0 +1,35 @@
+package misc
+
+import (
+	"execution/common"
+	"execution/types"
+	"math/big"
+	"testing"
+)
+
+// Tests the base fee calculation against the EIP-1559 reference values.
+func TestCalcBaseFee(t *testing.T) {
+	tests := []struct {
+		parentBaseFee   int64
+		parentGasLimit  uint64
+		parentGasUsed   uint64
+		expectedBaseFee int64
+	}{
+		{1000000000, 20000000, 10000000, 1000000000}, // usage == target
+		{1000000000, 20000000, 9000000, 987500000},   // usage below target
+		{1000000000, 20000000, 11000000, 1012500000}, // usage above target
+		{1000000000, 20000000, 0, 875000000},         // empty block
+		{1000000000, 20000000, 20000000, 1125000000}, // full block
+		{1, 20000000, 10000001, 2},                   // increase by at least 1
+	}
+	for i, test := range tests {
+		parent := types.NewEasyHeader(common.Hash{}, common.Hash{}, big.NewInt(1), test.parentGasLimit, test.parentGasUsed, big.NewInt(test.parentBaseFee))
+		if have, want := CalcBaseFee(parent), big.NewInt(test.expectedBaseFee); have.Cmp(want) != 0 {
+			t.Errorf("test %d: have %d  want %d, ", i, have, want)
+		}
+	}
+	// Blocks without a base fee are followed by blocks without one
+	if fee := CalcBaseFee(types.NewEasyHeader(common.Hash{}, common.Hash{}, big.NewInt(1), 20000000, 0, nil)); fee != nil {
+		t.Errorf("base fee for legacy parent: have %v, want nil", fee)
+	}
+}
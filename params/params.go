@@ -1,6 +1,8 @@
 package params
 
-var (
+import "math/big"
+
+const (
 	TxGas                     uint64 = 21000 // Per transaction not creating a contract. NOTE: Not payable on data of calls between transactions.
 	TxGasContractCreation     uint64 = 53000 // Per transaction that creates a contract. NOTE: Not payable on data of calls between transactions.
 	TxDataZeroGas             uint64 = 4     // Per byte of data attached to a transaction that equals zero. NOTE: Not payable on data of calls between transactions.
@@ -8,6 +10,50 @@ var (
 	TxAccessListAddressGas    uint64 = 2400  // Per address specified in EIP 2930 access list
 	TxAccessListStorageKeyGas uint64 = 1900  // Per storage key specified in EIP 2930 access list
 	InitCodeWordGas           uint64 = 2     // Per word of initialisation code for a contract
+
+	RefundQuotientEIP3529 uint64 = 5 // Maximum refund quotient; max gas refund is gasUsed / RefundQuotientEIP3529
+
+	BaseFeeChangeDenominator uint64 = 8 // Bounds the amount the base fee can change between blocks.
+	ElasticityMultiplier     uint64 = 2 // Bounds the maximum gas limit an EIP-1559 block may have.
 )
 
-type ChainConfig interface{}
+// GasSchedule is the set of gas costs a transaction is charged before execution,
+// covering its inclusion, payload and access list.
+type GasSchedule struct {
+	TxGas                     uint64 // Per transaction not creating a contract
+	TxGasContractCreation     uint64 // Per transaction that creates a contract
+	TxDataZeroGas             uint64 // Per byte of data attached to a transaction that equals zero
+	TxDataNonZeroGas          uint64 // Per byte of non zero data attached to a transaction
+	TxAccessListAddressGas    uint64 // Per address specified in an access list
+	TxAccessListStorageKeyGas uint64 // Per storage key specified in an access list
+	InitCodeWordGas           uint64 // Per word of initialisation code for a contract
+}
+
+// DefaultGasSchedule returns the gas costs used by chains that don't configure
+// their own, matching Ethereum mainnet.
+func DefaultGasSchedule() GasSchedule {
+	return GasSchedule{
+		TxGas:                     TxGas,
+		TxGasContractCreation:     TxGasContractCreation,
+		TxDataZeroGas:             TxDataZeroGas,
+		TxDataNonZeroGas:          TxDataNonZeroGas,
+		TxAccessListAddressGas:    TxAccessListAddressGas,
+		TxAccessListStorageKeyGas: TxAccessListStorageKeyGas,
+		InitCodeWordGas:           InitCodeWordGas,
+	}
+}
+
+// ChainConfig is the core config which determines the blockchain settings.
+type ChainConfig struct {
+	ChainID *big.Int     // chainId identifies the current chain and is used for replay protection
+	Gas     *GasSchedule // Gas costs charged to transactions, nil for the default schedule
+}
+
+// GasSchedule returns the gas costs of the chain, falling back to the defaults if
+// the chain doesn't customise them. It is safe to call on a nil config.
+func (c *ChainConfig) GasSchedule() GasSchedule {
+	if c == nil || c.Gas == nil {
+		return DefaultGasSchedule()
+	}
+	return *c.Gas
+}
@@ -0,0 +1,79 @@
+package state
+
+import (
+	"execution/common"
+	"math/big"
+)
+
+// journalEntry is a modification entry in the state change journal that can be
+// reverted on demand.
+type journalEntry interface {
+	// revert undoes the changes introduced by this journal entry.
+	revert(*EasyStateDB)
+}
+
+// revision marks the journal length at the time a snapshot was taken.
+type revision struct {
+	id           int
+	journalIndex int
+}
+
+type (
+	balanceChange struct {
+		account common.Address
+		prev    *big.Int
+		existed bool
+	}
+	nonceChange struct {
+		account common.Address
+		prev    uint64
+		existed bool
+	}
+	storageChange struct {
+		account  common.Address
+		key      common.Hash
+		prevalue common.Hash
+		existed  bool
+	}
+	codeChange struct {
+		account  common.Address
+		prevcode []byte
+		existed  bool
+	}
+)
+
+func (ch balanceChange) revert(s *EasyStateDB) {
+	if !ch.existed {
+		delete(s.balances, ch.account)
+		return
+	}
+	s.balances[ch.account] = ch.prev
+}
+
+func (ch nonceChange) revert(s *EasyStateDB) {
+	if !ch.existed {
+		delete(s.nonces, ch.account)
+		return
+	}
+	s.nonces[ch.account] = ch.prev
+}
+
+func (ch storageChange) revert(s *EasyStateDB) {
+	storage := s.storages[ch.account]
+	if !ch.existed {
+		delete(storage, ch.key)
+		if len(storage) == 0 {
+			delete(s.storages, ch.account)
+		}
+		return
+	}
+	storage[ch.key] = ch.prevalue
+}
+
+func (ch codeChange) revert(s *EasyStateDB) {
+	if !ch.existed {
+		delete(s.codes, ch.account)
+		return
+	}
+	s.codes[ch.account] = ch.prevcode
+}
@@ -0,0 +1,57 @@
+package state
+
+import (
+	"execution/common"
+	"math/big"
+)
+
+// ReadOnlyStateDB wraps a StateDB, forwarding all reads to it and panicking on
+// any modification. It guards code which is only meant to inspect the state,
+// such as transaction validation, against corrupting a shared database.
+type ReadOnlyStateDB struct {
+	db StateDB
+}
+
+// NewReadOnlyStateDB creates a read-only view of the given state.
+func NewReadOnlyStateDB(db StateDB) *ReadOnlyStateDB {
+	return &ReadOnlyStateDB{db: db}
+}
+
+// readOnly aborts a modification attempted through the read-only view.
+func readOnly(method string) {
+	panic("state: " + method + " called on read-only state")
+}
+
+func (s *ReadOnlyStateDB) SubBalance(common.Address, *big.Int) { readOnly("SubBalance") }
+func (s *ReadOnlyStateDB) AddBalance(common.Address, *big.Int) { readOnly("AddBalance") }
+func (s *ReadOnlyStateDB) SetBalance(common.Address, *big.Int) { readOnly("SetBalance") }
+func (s *ReadOnlyStateDB) SetNonce(common.Address, uint64)     { readOnly("SetNonce") }
+
+func (s *ReadOnlyStateDB) SetState(common.Address, common.Hash, common.Hash) { readOnly("SetState") }
+func (s *ReadOnlyStateDB) SetCode(common.Address, []byte)                    { readOnly("SetCode") }
+
+// Snapshot panics, as taking a snapshot records a revision in the wrapped state.
+func (s *ReadOnlyStateDB) Snapshot() int {
+	readOnly("Snapshot")
+	return 0
+}
+
+func (s *ReadOnlyStateDB) RevertToSnapshot(int) { readOnly("RevertToSnapshot") }
+func (s *ReadOnlyStateDB) Finalise()            { readOnly("Finalise") }
+
+func (s *ReadOnlyStateDB) GetBalance(addr common.Address) *big.Int { return s.db.GetBalance(addr) }
+func (s *ReadOnlyStateDB) GetNonce(addr common.Address) uint64     { return s.db.GetNonce(addr) }
+
+func (s *ReadOnlyStateDB) GetState(addr common.Address, key common.Hash) common.Hash {
+	return s.db.GetState(addr, key)
+}
+
+func (s *ReadOnlyStateDB) GetCode(addr common.Address) []byte          { return s.db.GetCode(addr) }
+func (s *ReadOnlyStateDB) GetCodeHash(addr common.Address) common.Hash { return s.db.GetCodeHash(addr) }
+func (s *ReadOnlyStateDB) Exist(addr common.Address) bool              { return s.db.Exist(addr) }
+func (s *ReadOnlyStateDB) Empty(addr common.Address) bool              { return s.db.Empty(addr) }
+
+// Copy returns an independent, writable copy of the wrapped state.
+func (s *ReadOnlyStateDB) Copy() StateDB {
+	return s.db.Copy()
+}
@@ -1,10 +1,18 @@
 package state
 
 import (
+	"bytes"
 	"execution/common"
+	"execution/crypto"
+	"fmt"
 	"math/big"
+	"sort"
 )
 
+// emptyCodeHash is the hash of an empty code, reported for existing accounts
+// without any code deployed.
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
 type StateDB interface {
 	SubBalance(common.Address, *big.Int)
 	AddBalance(common.Address, *big.Int)
@@ -12,29 +20,72 @@ type StateDB interface {
 	SetBalance(common.Address, *big.Int)
 	GetNonce(common.Address) uint64
 	SetNonce(common.Address, uint64)
+
+	GetState(common.Address, common.Hash) common.Hash
+	SetState(common.Address, common.Hash, common.Hash)
+	GetCode(common.Address) []byte
+	SetCode(common.Address, []byte)
+	GetCodeHash(common.Address) common.Hash
+
+	// Exist reports whether the given account exists in state.
+	Exist(common.Address) bool
+	// Empty reports whether the given account is empty, i.e. it has no nonce,
+	// no balance and no code.
+	Empty(common.Address) bool
+
+	// Snapshot returns an identifier for the current revision of the state.
+	Snapshot() int
+	// RevertToSnapshot reverts all state changes made since the given revision.
+	RevertToSnapshot(int)
+	// Finalise commits all state changes made so far, invalidating every
+	// snapshot and discarding the journal backing them.
+	Finalise()
+
 	Copy() StateDB
 }
 
 type EasyStateDB struct {
 	balances map[common.Address]*big.Int
 	nonces   map[common.Address]uint64
+	storages map[common.Address]map[common.Hash]common.Hash
+	codes    map[common.Address][]byte
+
+	// Journal of state modifications. This is the backbone of
+	// Snapshot and RevertToSnapshot.
+	journal        []journalEntry
+	validRevisions []revision
+	nextRevisionId int
 }
 
 func NewEasyStateDB() *EasyStateDB {
 	return &EasyStateDB{
 		balances: make(map[common.Address]*big.Int),
 		nonces:   make(map[common.Address]uint64),
+		storages: make(map[common.Address]map[common.Hash]common.Hash),
+		codes:    make(map[common.Address][]byte),
 	}
 }
 
+// Copy creates a deep, independent copy of the state. Snapshots of the copied
+// state cannot be applied to the copy.
 func (stateDB *EasyStateDB) Copy() StateDB {
 	newStateDB := NewEasyStateDB()
 	for addr, balance := range stateDB.balances {
-		newStateDB.balances[addr] = balance
+		newStateDB.balances[addr] = new(big.Int).Set(balance)
 	}
 	for addr, nonce := range stateDB.nonces {
 		newStateDB.nonces[addr] = nonce
 	}
+	for addr, storage := range stateDB.storages {
+		newStorage := make(map[common.Hash]common.Hash, len(storage))
+		for key, value := range storage {
+			newStorage[key] = value
+		}
+		newStateDB.storages[addr] = newStorage
+	}
+	for addr, code := range stateDB.codes {
+		newStateDB.codes[addr] = bytes.Clone(code)
+	}
 	return newStateDB
 }
 
@@ -43,29 +94,136 @@ func (stateDB *EasyStateDB) GetNonce(addr common.Address) uint64 {
 }
 
 func (stateDB *EasyStateDB) SetNonce(addr common.Address, nonce uint64) {
+	prev, existed := stateDB.nonces[addr]
+	stateDB.appendJournal(nonceChange{account: addr, prev: prev, existed: existed})
 	stateDB.nonces[addr] = nonce
 }
 
+// GetBalance returns a copy of the account's balance, so callers can't mutate
+// the stored value in place.
 func (stateDB *EasyStateDB) GetBalance(addr common.Address) *big.Int {
-	_, ok := stateDB.balances[addr]
+	balance, ok := stateDB.balances[addr]
 	if !ok {
 		return big.NewInt(0)
 	}
-	return stateDB.balances[addr]
+	return new(big.Int).Set(balance)
 }
 
+// AddBalance credits the given amount to the account.
 func (stateDB *EasyStateDB) AddBalance(addr common.Address, amount *big.Int) {
 	balance := stateDB.GetBalance(addr)
-	balance.Add(balance, amount)
-	stateDB.SetBalance(addr, balance)
+	stateDB.SetBalance(addr, new(big.Int).Add(balance, amount))
 }
 
+// SubBalance debits the given amount from the account.
 func (stateDB *EasyStateDB) SubBalance(addr common.Address, amount *big.Int) {
 	balance := stateDB.GetBalance(addr)
-	balance.Sub(balance, amount)
-	stateDB.SetBalance(addr, balance)
+	stateDB.SetBalance(addr, new(big.Int).Sub(balance, amount))
 }
 
+// SetBalance stores a copy of the given amount as the account's balance.
 func (stateDB *EasyStateDB) SetBalance(addr common.Address, amount *big.Int) {
-	stateDB.balances[addr] = amount
+	prev, existed := stateDB.balances[addr]
+	stateDB.appendJournal(balanceChange{account: addr, prev: prev, existed: existed})
+	stateDB.balances[addr] = new(big.Int).Set(amount)
+}
+
+func (stateDB *EasyStateDB) GetState(addr common.Address, key common.Hash) common.Hash {
+	return stateDB.storages[addr][key]
+}
+
+func (stateDB *EasyStateDB) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	storage, ok := stateDB.storages[addr]
+	if !ok {
+		storage = make(map[common.Hash]common.Hash)
+		stateDB.storages[addr] = storage
+	}
+	prev, existed := storage[key]
+	stateDB.appendJournal(storageChange{account: addr, key: key, prevalue: prev, existed: existed})
+	storage[key] = value
+}
+
+func (stateDB *EasyStateDB) GetCode(addr common.Address) []byte {
+	return stateDB.codes[addr]
+}
+
+func (stateDB *EasyStateDB) SetCode(addr common.Address, code []byte) {
+	prev, existed := stateDB.codes[addr]
+	stateDB.appendJournal(codeChange{account: addr, prevcode: prev, existed: existed})
+	stateDB.codes[addr] = bytes.Clone(code)
+}
+
+// GetCodeHash returns the hash of the code deployed at the given account, the
+// empty code hash if it has none, or the zero hash if the account doesn't exist.
+func (stateDB *EasyStateDB) GetCodeHash(addr common.Address) common.Hash {
+	if !stateDB.Exist(addr) {
+		return common.Hash{}
+	}
+	code := stateDB.codes[addr]
+	if len(code) == 0 {
+		return emptyCodeHash
+	}
+	return crypto.Keccak256Hash(code)
+}
+
+func (stateDB *EasyStateDB) Exist(addr common.Address) bool {
+	if _, ok := stateDB.balances[addr]; ok {
+		return true
+	}
+	if _, ok := stateDB.nonces[addr]; ok {
+		return true
+	}
+	if _, ok := stateDB.storages[addr]; ok {
+		return true
+	}
+	_, ok := stateDB.codes[addr]
+	return ok
+}
+
+func (stateDB *EasyStateDB) Empty(addr common.Address) bool {
+	return stateDB.GetNonce(addr) == 0 && stateDB.GetBalance(addr).Sign() == 0 && len(stateDB.codes[addr]) == 0
+}
+
+// appendJournal records a state modification, as long as there is a snapshot
+// it could be reverted to. Without one, nothing is kept to avoid growing the
+// journal indefinitely.
+func (stateDB *EasyStateDB) appendJournal(entry journalEntry) {
+	if len(stateDB.validRevisions) > 0 {
+		stateDB.journal = append(stateDB.journal, entry)
+	}
+}
+
+// Snapshot returns an identifier for the current revision of the state.
+func (stateDB *EasyStateDB) Snapshot() int {
+	id := stateDB.nextRevisionId
+	stateDB.nextRevisionId++
+	stateDB.validRevisions = append(stateDB.validRevisions, revision{id, len(stateDB.journal)})
+	return id
+}
+
+// RevertToSnapshot reverts all state changes made since the given revision.
+func (stateDB *EasyStateDB) RevertToSnapshot(revid int) {
+	// Find the snapshot in the stack of valid snapshots.
+	idx := sort.Search(len(stateDB.validRevisions), func(i int) bool {
+		return stateDB.validRevisions[i].id >= revid
+	})
+	if idx == len(stateDB.validRevisions) || stateDB.validRevisions[idx].id != revid {
+		panic(fmt.Errorf("revision id %v cannot be reverted", revid))
+	}
+	snapshot := stateDB.validRevisions[idx].journalIndex
+
+	// Replay the journal to undo changes and remove invalidated snapshots
+	for i := len(stateDB.journal) - 1; i >= snapshot; i-- {
+		stateDB.journal[i].revert(stateDB)
+	}
+	stateDB.journal = stateDB.journal[:snapshot]
+	stateDB.validRevisions = stateDB.validRevisions[:idx]
+}
+
+// Finalise commits all state changes made so far, e.g. once a transaction has
+// been applied. Every snapshot is invalidated and the journal is dropped, so it
+// doesn't keep growing while snapshots are never reverted.
+func (stateDB *EasyStateDB) Finalise() {
+	stateDB.journal = nil
+	stateDB.validRevisions = stateDB.validRevisions[:0]
 }
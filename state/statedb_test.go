@@ -0,0 +1,267 @@
+package state
+
+import (
+	"bytes"
+	"execution/common"
+	"execution/crypto"
+	"math/big"
+	"testing"
+)
+
+// Tests the storage, code and existence accessors, and that copies of the state
+// own their storage and code.
+func TestStorageCodeExistence(t *testing.T) {
+	var (
+		statedb = NewEasyStateDB()
+		addr    = common.Address{0x01}
+		other   = common.Address{0x02}
+		key     = common.Hash{0x01}
+		code    = []byte{0x60, 0x00}
+	)
+	// Unknown accounts neither exist nor have any storage or code
+	if statedb.Exist(addr) || !statedb.Empty(addr) {
+		t.Fatalf("unknown account: exist %v empty %v, want false true", statedb.Exist(addr), statedb.Empty(addr))
+	}
+	if hash := statedb.GetCodeHash(addr); hash != (common.Hash{}) {
+		t.Errorf("unknown account code hash mismatch: have %x, want zero", hash)
+	}
+	if value := statedb.GetState(addr, key); value != (common.Hash{}) {
+		t.Errorf("unknown slot mismatch: have %x, want zero", value)
+	}
+	// Storage makes an account exist, but leaves it empty
+	statedb.SetState(addr, key, common.Hash{0x0a})
+	if value := statedb.GetState(addr, key); value != (common.Hash{0x0a}) {
+		t.Errorf("storage mismatch: have %x, want %x", value, common.Hash{0x0a})
+	}
+	if !statedb.Exist(addr) || !statedb.Empty(addr) {
+		t.Errorf("account with storage: exist %v empty %v, want true true", statedb.Exist(addr), statedb.Empty(addr))
+	}
+	if hash := statedb.GetCodeHash(addr); hash != emptyCodeHash {
+		t.Errorf("code hash without code mismatch: have %x, want %x", hash, emptyCodeHash)
+	}
+	// Code makes it non-empty, and is stored as a copy
+	statedb.SetCode(addr, code)
+	code[0] = 0xff
+	if have := statedb.GetCode(addr); !bytes.Equal(have, []byte{0x60, 0x00}) {
+		t.Errorf("code mismatch: have %x, want %x", have, []byte{0x60, 0x00})
+	}
+	if hash := statedb.GetCodeHash(addr); hash != crypto.Keccak256Hash([]byte{0x60, 0x00}) {
+		t.Errorf("code hash mismatch: have %x", hash)
+	}
+	if statedb.Empty(addr) {
+		t.Errorf("account with code reported empty")
+	}
+	// A nonce alone makes an account exist and non-empty
+	statedb.SetNonce(other, 1)
+	if !statedb.Exist(other) || statedb.Empty(other) {
+		t.Errorf("account with nonce: exist %v empty %v, want true false", statedb.Exist(other), statedb.Empty(other))
+	}
+	// Copies must not share storage or code with the original
+	cpy := statedb.Copy()
+	cpy.SetState(addr, key, common.Hash{0x0b})
+	cpy.GetCode(addr)[0] = 0xff
+	if value := statedb.GetState(addr, key); value != (common.Hash{0x0a}) {
+		t.Errorf("storage changed through copy: have %x, want %x", value, common.Hash{0x0a})
+	}
+	if have := statedb.GetCode(addr); !bytes.Equal(have, []byte{0x60, 0x00}) {
+		t.Errorf("code changed through copy: have %x, want %x", have, []byte{0x60, 0x00})
+	}
+}
+
+// Tests that reverting to a snapshot undoes all balance, nonce, storage and code
+// changes made since, including the creation of accounts.
+func TestSnapshotRevert(t *testing.T) {
+	var (
+		statedb = NewEasyStateDB()
+		addr    = common.Address{0x01}
+		fresh   = common.Address{0x02}
+		key     = common.Hash{0x01}
+	)
+	statedb.SetBalance(addr, big.NewInt(100))
+	statedb.SetNonce(addr, 1)
+	statedb.SetState(addr, key, common.Hash{0x0a})
+
+	outer := statedb.Snapshot()
+	statedb.AddBalance(addr, big.NewInt(50))
+	statedb.SetNonce(addr, 2)
+
+	inner := statedb.Snapshot()
+	statedb.SubBalance(addr, big.NewInt(20))
+	statedb.SetState(addr, key, common.Hash{0x0b})
+	statedb.SetCode(addr, []byte{0x60, 0x00})
+	statedb.SetBalance(fresh, big.NewInt(1))
+
+	statedb.RevertToSnapshot(inner)
+	if balance := statedb.GetBalance(addr); balance.Cmp(big.NewInt(150)) != 0 {
+		t.Errorf("balance mismatch after inner revert: have %v, want %v", balance, 150)
+	}
+	if nonce := statedb.GetNonce(addr); nonce != 2 {
+		t.Errorf("nonce mismatch after inner revert: have %d, want %d", nonce, 2)
+	}
+	if value := statedb.GetState(addr, key); value != (common.Hash{0x0a}) {
+		t.Errorf("storage mismatch after inner revert: have %x, want %x", value, common.Hash{0x0a})
+	}
+	if code := statedb.GetCode(addr); code != nil {
+		t.Errorf("code mismatch after inner revert: have %x, want none", code)
+	}
+	if statedb.Exist(fresh) {
+		t.Errorf("account created after snapshot still exists")
+	}
+
+	statedb.RevertToSnapshot(outer)
+	if balance := statedb.GetBalance(addr); balance.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("balance mismatch after outer revert: have %v, want %v", balance, 100)
+	}
+	if nonce := statedb.GetNonce(addr); nonce != 1 {
+		t.Errorf("nonce mismatch after outer revert: have %d, want %d", nonce, 1)
+	}
+}
+
+// Tests that reverting to an unknown or already reverted snapshot panics.
+func TestRevertInvalidSnapshot(t *testing.T) {
+	statedb := NewEasyStateDB()
+	id := statedb.Snapshot()
+	statedb.RevertToSnapshot(id)
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("reverting an invalidated snapshot didn't panic")
+		}
+	}()
+	statedb.RevertToSnapshot(id)
+}
+
+// Tests that finalising the state drops the journal and invalidates snapshots,
+// while keeping the changes made, and that journaling resumes afterwards.
+func TestFinalise(t *testing.T) {
+	var (
+		statedb = NewEasyStateDB()
+		addr    = common.Address{0x01}
+	)
+	stale := statedb.Snapshot()
+	for i := int64(1); i <= 100; i++ {
+		statedb.SetBalance(addr, big.NewInt(i))
+	}
+	statedb.Finalise()
+
+	if len(statedb.journal) != 0 || len(statedb.validRevisions) != 0 {
+		t.Fatalf("journal retained: have %d entries %d revisions", len(statedb.journal), len(statedb.validRevisions))
+	}
+	if balance := statedb.GetBalance(addr); balance.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("balance mismatch after finalising: have %v, want %v", balance, 100)
+	}
+	// Snapshots taken after finalising work as before
+	id := statedb.Snapshot()
+	statedb.SetBalance(addr, big.NewInt(1))
+	statedb.RevertToSnapshot(id)
+	if balance := statedb.GetBalance(addr); balance.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("balance mismatch after revert: have %v, want %v", balance, 100)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("reverting a finalised snapshot didn't panic")
+		}
+	}()
+	statedb.RevertToSnapshot(stale)
+}
+
+// Tests that balances are not shared between a state and its copy, nor with the
+// values handed out by GetBalance.
+func TestBalanceAliasing(t *testing.T) {
+	var (
+		statedb = NewEasyStateDB()
+		addr    = common.Address{0x01}
+	)
+	statedb.SetBalance(addr, big.NewInt(100))
+
+	cpy := statedb.Copy()
+	cpy.AddBalance(addr, big.NewInt(10))
+	cpy.SubBalance(addr, big.NewInt(30))
+	if balance := statedb.GetBalance(addr); balance.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("original balance changed by copy: have %v, want %v", balance, 100)
+	}
+	if balance := cpy.GetBalance(addr); balance.Cmp(big.NewInt(80)) != 0 {
+		t.Errorf("copy balance mismatch: have %v, want %v", balance, 80)
+	}
+	statedb.GetBalance(addr).SetInt64(0)
+	if balance := statedb.GetBalance(addr); balance.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("balance changed through getter: have %v, want %v", balance, 100)
+	}
+	// Neither the amount set nor the one added may be retained by the state
+	amount := big.NewInt(200)
+	statedb.SetBalance(addr, amount)
+	amount.SetInt64(0)
+	if balance := statedb.GetBalance(addr); balance.Cmp(big.NewInt(200)) != 0 {
+		t.Errorf("balance changed through set amount: have %v, want %v", balance, 200)
+	}
+	// Crediting and debiting allocate the new balance, leaving the journaled
+	// previous one intact for reverts
+	id := statedb.Snapshot()
+	delta := big.NewInt(5)
+	statedb.AddBalance(addr, delta)
+	statedb.SubBalance(addr, delta)
+	statedb.AddBalance(addr, delta)
+	if delta.Cmp(big.NewInt(5)) != 0 {
+		t.Errorf("added amount modified: have %v, want %v", delta, 5)
+	}
+	statedb.RevertToSnapshot(id)
+	if balance := statedb.GetBalance(addr); balance.Cmp(big.NewInt(200)) != 0 {
+		t.Errorf("balance mismatch after revert: have %v, want %v", balance, 200)
+	}
+}
+
+// Tests that the read-only view forwards reads and refuses every modification.
+func TestReadOnlyStateDB(t *testing.T) {
+	var (
+		statedb = NewEasyStateDB()
+		addr    = common.Address{0x01}
+		key     = common.Hash{0x01}
+	)
+	statedb.SetBalance(addr, big.NewInt(100))
+	statedb.SetNonce(addr, 3)
+	statedb.SetState(addr, key, common.Hash{0x0a})
+
+	view := NewReadOnlyStateDB(statedb)
+	if balance := view.GetBalance(addr); balance.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("balance mismatch: have %v, want %v", balance, 100)
+	}
+	if nonce := view.GetNonce(addr); nonce != 3 {
+		t.Errorf("nonce mismatch: have %d, want %d", nonce, 3)
+	}
+	if value := view.GetState(addr, key); value != (common.Hash{0x0a}) {
+		t.Errorf("storage mismatch: have %x, want %x", value, common.Hash{0x0a})
+	}
+	writes := map[string]func(){
+		"SubBalance":       func() { view.SubBalance(addr, big.NewInt(1)) },
+		"AddBalance":       func() { view.AddBalance(addr, big.NewInt(1)) },
+		"SetBalance":       func() { view.SetBalance(addr, big.NewInt(1)) },
+		"SetNonce":         func() { view.SetNonce(addr, 4) },
+		"SetState":         func() { view.SetState(addr, key, common.Hash{0x0b}) },
+		"SetCode":          func() { view.SetCode(addr, []byte{0x60}) },
+		"Snapshot":         func() { view.Snapshot() },
+		"RevertToSnapshot": func() { view.RevertToSnapshot(0) },
+		"Finalise":         func() { view.Finalise() },
+	}
+	for name, write := range writes {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: modification not refused", name)
+				}
+			}()
+			write()
+		}()
+	}
+	if balance := statedb.GetBalance(addr); balance.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("wrapped balance modified: have %v, want %v", balance, 100)
+	}
+	if nonce := statedb.GetNonce(addr); nonce != 3 {
+		t.Errorf("wrapped nonce modified: have %d, want %d", nonce, 3)
+	}
+	// Copies are detached from the view and writable
+	cpy := view.Copy()
+	cpy.SetNonce(addr, 5)
+	if nonce := statedb.GetNonce(addr); nonce != 3 {
+		t.Errorf("wrapped nonce modified through copy: have %d, want %d", nonce, 3)
+	}
+}
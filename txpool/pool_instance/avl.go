@@ -9,7 +9,7 @@ var (
 	ErrEmptyTree = errors.New("empty tree")
 )
 
-// AVLTree structure. Public methods are Add, Remove, Update, Search, Flatten.
+// AVLTree structure. Public methods are Add, Remove, Update, Search, Flatten, Range.
 type AVLTree struct {
 	root *AVLNode
 }
@@ -31,6 +31,14 @@ func (t *AVLTree) Search(key uint64) (node *AVLNode, sum *big.Int) {
 	return t.root.search(key)
 }
 
+// Sum returns the sum of the values of all nodes in the tree.
+func (t *AVLTree) Sum() *big.Int {
+	if t.root == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(t.root.sum)
+}
+
 func (t *AVLTree) Smallest() (uint64, error) {
 	if t.root == nil {
 		return 0, ErrEmptyTree
@@ -54,6 +62,16 @@ func (t *AVLTree) Flatten() []*AVLNode {
 	return nodes
 }
 
+// Range visits the keys within [lo, hi] in ascending order together with their
+// values, skipping subtrees outside of the range. Iteration stops early if f
+// returns false.
+func (t *AVLTree) Range(lo, hi uint64, f func(key uint64, cost *big.Int) bool) {
+	if lo > hi {
+		return
+	}
+	t.root.visitRange(lo, hi, f)
+}
+
 // AVLNode structure
 type AVLNode struct {
 	key   uint64   // nonce
@@ -175,6 +193,24 @@ func (n *AVLNode) displayNodesInOrder(nodes *[]*AVLNode) {
 	}
 }
 
+// visitRange walks the keys within [lo, hi] in order, returning false if the
+// callback aborted the iteration.
+func (n *AVLNode) visitRange(lo, hi uint64, f func(key uint64, cost *big.Int) bool) bool {
+	if n == nil {
+		return true
+	}
+	if lo < n.key && !n.left.visitRange(lo, hi, f) {
+		return false
+	}
+	if lo <= n.key && n.key <= hi && !f(n.key, n.value) {
+		return false
+	}
+	if n.key < hi {
+		return n.right.visitRange(lo, hi, f)
+	}
+	return true
+}
+
 func (n *AVLNode) getHeight() int {
 	if n == nil {
 		return 0
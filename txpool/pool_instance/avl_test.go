@@ -1,8 +1,14 @@
 package txpool_instance
 
 import (
+	"execution/common"
+	"execution/types"
+	"execution/types/gadget"
+	"fmt"
+	"math"
 	"math/big"
 	"math/rand"
+	"reflect"
 	"sort"
 	"testing"
 )
@@ -69,6 +75,9 @@ func TestTree(t *testing.T) {
 			}
 		}
 
+		if _, _, err := checkAVLNode(tree.root); err != nil {
+			t.Fatalf("tree invariant violated: %v", err)
+		}
 		nodes := tree.Flatten()
 		keys := make([]uint64, 0)
 		for key := range m {
@@ -93,3 +102,189 @@ func TestTree(t *testing.T) {
 	// tree.Add(8, big.NewInt(8))
 	// tree.Search(8)
 }
+
+// Tests that the tree stays balanced on monotonic inserts and removals, which
+// would degenerate an unbalanced binary search tree into a list.
+func TestTreeBalanceSequential(t *testing.T) {
+	const n = 1024
+
+	tree := &AVLTree{}
+	for i := uint64(0); i < n; i++ {
+		tree.Add(i, big.NewInt(int64(i)))
+	}
+	height, _, err := checkAVLNode(tree.root)
+	if err != nil {
+		t.Fatalf("tree invariant violated after inserts: %v", err)
+	}
+	// An AVL tree of n nodes is at most ~1.44*log2(n) high
+	if height > 15 {
+		t.Fatalf("tree too high after inserts: have %d, want <= %d", height, 15)
+	}
+	for i := uint64(0); i < n; i += 2 {
+		tree.Remove(i)
+	}
+	if _, _, err := checkAVLNode(tree.root); err != nil {
+		t.Fatalf("tree invariant violated after removals: %v", err)
+	}
+	if smallest, _ := tree.Smallest(); smallest != 1 {
+		t.Fatalf("smallest key mismatch: have %d, want %d", smallest, 1)
+	}
+	if largest, _ := tree.Largest(); largest != n-1 {
+		t.Fatalf("largest key mismatch: have %d, want %d", largest, n-1)
+	}
+}
+
+// Tests that the subtotal cost of a sorted map only sums the transactions below
+// the requested nonce, whether or not that nonce itself is present.
+func TestSortedMapSubTotalCost(t *testing.T) {
+	m := NewSortedMap()
+	for _, nonce := range []uint64{1, 2, 4} {
+		tx := &types.Transaction{}
+		tx.From = common.Address{0x01}
+		tx.Nonce = nonce
+		tx.GasPrice = gadget.NewGasPrice(big.NewInt(0))
+		tx.Value = big.NewInt(int64(nonce))
+		m.Put(tx)
+	}
+	for threshold, want := range []int64{0, 0, 1, 3, 3, 7, 7} {
+		if cost := m.SubTotalCost(uint64(threshold)); cost.Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("subtotal below %d mismatch: have %v, want %v", threshold, cost, want)
+		}
+	}
+}
+
+func TestSortedMapReadyWithGasCap(t *testing.T) {
+	fill := func() *SortedMap {
+		m := NewSortedMap()
+		for _, nonce := range []uint64{0, 1, 2, 3, 5} {
+			tx := &types.Transaction{}
+			tx.From = common.Address{0x01}
+			tx.Nonce = nonce
+			tx.GasLimit = 100
+			tx.GasPrice = gadget.NewGasPrice(big.NewInt(0))
+			tx.Value = big.NewInt(1)
+			m.Put(tx)
+		}
+		return m
+	}
+	tests := []struct {
+		cost  int64
+		gas   uint64
+		ready int
+	}{
+		{100, math.MaxUint64, 4}, // stops at the nonce gap
+		{100, 250, 2},            // stops at the gas cap
+		{100, 300, 3},            // gas cap fully used
+		{2, 1000, 2},             // stops at the cost threshold
+		{100, 99, 0},             // first transaction exceeds the gas cap
+	}
+	for i, tt := range tests {
+		m := fill()
+		ready := m.ReadyWithGasCap(0, big.NewInt(tt.cost), tt.gas)
+		if len(ready) != tt.ready {
+			t.Errorf("test %d: ready count mismatch: have %d, want %d", i, len(ready), tt.ready)
+		}
+		for j, tx := range ready {
+			if tx.Nonce != uint64(j) {
+				t.Errorf("test %d: ready transaction %d nonce mismatch: have %d", i, j, tx.Nonce)
+			}
+		}
+		if m.Len() != 5-tt.ready {
+			t.Errorf("test %d: remaining count mismatch: have %d, want %d", i, m.Len(), 5-tt.ready)
+		}
+	}
+}
+
+// Tests that range iteration visits exactly the keys within the bounds in order,
+// and stops as soon as the callback asks it to.
+func TestTreeRange(t *testing.T) {
+	tree := new(AVLTree)
+	for i := uint64(0); i < 64; i += 2 {
+		tree.Add(i, big.NewInt(int64(i)))
+	}
+	tests := []struct {
+		lo, hi uint64
+		want   []uint64
+	}{
+		{5, 10, []uint64{6, 8, 10}},
+		{6, 6, []uint64{6}},
+		{7, 7, nil},
+		{10, 5, nil},
+		{60, math.MaxUint64, []uint64{60, 62}},
+		{0, 3, []uint64{0, 2}},
+	}
+	for i, tt := range tests {
+		var keys []uint64
+		tree.Range(tt.lo, tt.hi, func(key uint64, cost *big.Int) bool {
+			if cost.Uint64() != key {
+				t.Errorf("test %d: key %d value mismatch: have %v", i, key, cost)
+			}
+			keys = append(keys, key)
+			return true
+		})
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("test %d: visited keys mismatch: have %v, want %v", i, keys, tt.want)
+		}
+	}
+	var visited int
+	tree.Range(0, math.MaxUint64, func(key uint64, _ *big.Int) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Errorf("early stop mismatch: visited %d, want %d", visited, 3)
+	}
+	m := NewSortedMap()
+	for _, nonce := range []uint64{3, 5, 6, 9, 10, 12} {
+		tx := &types.Transaction{}
+		tx.From = common.Address{0x01}
+		tx.Nonce = nonce
+		tx.GasPrice = gadget.NewGasPrice(big.NewInt(0))
+		tx.Value = big.NewInt(1)
+		m.Put(tx)
+	}
+	txs := m.Range(5, 10)
+	if len(txs) != 4 {
+		t.Fatalf("ranged transaction count mismatch: have %d, want %d", len(txs), 4)
+	}
+	for i, nonce := range []uint64{5, 6, 9, 10} {
+		if txs[i].Nonce != nonce {
+			t.Errorf("ranged transaction %d nonce mismatch: have %d, want %d", i, txs[i].Nonce, nonce)
+		}
+	}
+}
+
+// checkAVLNode verifies the structural invariants of the subtree rooted at n:
+// keys are ordered, heights are accurate, every node is balanced and subtree sums
+// are consistent. It returns the height and sum of the subtree.
+func checkAVLNode(n *AVLNode) (int, *big.Int, error) {
+	if n == nil {
+		return 0, new(big.Int), nil
+	}
+	if n.left != nil && n.left.key >= n.key {
+		return 0, nil, fmt.Errorf("left child %d not below node %d", n.left.key, n.key)
+	}
+	if n.right != nil && n.right.key <= n.key {
+		return 0, nil, fmt.Errorf("right child %d not above node %d", n.right.key, n.key)
+	}
+	lh, lsum, err := checkAVLNode(n.left)
+	if err != nil {
+		return 0, nil, err
+	}
+	rh, rsum, err := checkAVLNode(n.right)
+	if err != nil {
+		return 0, nil, err
+	}
+	if diff := lh - rh; diff > 1 || diff < -1 {
+		return 0, nil, fmt.Errorf("node %d unbalanced: left height %d, right height %d", n.key, lh, rh)
+	}
+	if height := 1 + max(lh, rh); n.height != height {
+		return 0, nil, fmt.Errorf("node %d height mismatch: have %d, want %d", n.key, n.height, height)
+	}
+	sum := new(big.Int).Add(n.value, lsum)
+	sum.Add(sum, rsum)
+	if n.sum.Cmp(sum) != 0 {
+		return 0, nil, fmt.Errorf("node %d sum mismatch: have %v, want %v", n.key, n.sum, sum)
+	}
+	return n.height, sum, nil
+}
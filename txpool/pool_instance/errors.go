@@ -1,6 +1,10 @@
 package txpool_instance
 
-import "errors"
+import (
+	"errors"
+	"execution/common"
+	"fmt"
+)
 
 var (
 	ErrAlreadyKnown         = errors.New("transaction already known")
@@ -11,11 +15,48 @@ var (
 	ErrInsufficientFunds    = errors.New("insufficient funds")
 	ErrFutureReplacePending = errors.New("future replace pending")
 	ErrReplaceUnderpriced   = errors.New("replace transaction underpriced")
+	ErrNothingToCancel      = errors.New("no transaction to cancel")
 	ErrTxTypeNotSupported   = errors.New("transaction type not supported")
 	ErrOversizedData        = errors.New("transaction data too big")
 	ErrNegativeValue        = errors.New("negative value")
+	ErrMissingValue         = errors.New("missing value")
+	ErrNegativeGasPrice     = errors.New("negative gas price")
+	ErrInvalidEncoding      = errors.New("transaction can't be encoded")
 	ErrGasLimit             = errors.New("gas limit too high")
 	ErrPriceVeryHigh        = errors.New("gas price too high")
+	ErrTipVeryHigh          = errors.New("max priority fee per gas higher than 2^256-1")
+	ErrTipAboveFeeCap       = errors.New("max priority fee per gas higher than max fee per gas")
 	ErrInvalidSender        = errors.New("invalid sender")
+	ErrInvalidHash          = errors.New("transaction hash mismatch")
+	ErrUnsignedFields       = errors.New("transaction carries fields not covered by its signature")
 	ErrIntrinsicGas         = errors.New("intrinsic gas too low")
+	ErrPoolClosed           = errors.New("transaction pool closed")
+	ErrMissingGasPrice      = errors.New("missing gas price")
+	ErrEmptyOutputs         = errors.New("withdrawal without output coins")
+	ErrUnorderedOutputs     = errors.New("withdrawal output coins not in canonical order")
+	ErrNonPositiveCoin      = errors.New("non-positive coin amount")
+	ErrWitnessMismatch      = errors.New("input coins and witnesses mismatch")
+	ErrInvalidWitness       = errors.New("witness doesn't authorize spending its coin")
+	ErrDuplicateInput       = errors.New("duplicate input coin")
+	ErrRechargeImbalance    = errors.New("recharge value doesn't match input coins")
+	ErrExecutionUnsupported = errors.New("contract execution not supported")
+	ErrExecutionFailed      = errors.New("execution fails within gas allowance")
 )
+
+// AddError is returned for a transaction rejected by the pool, carrying the hash
+// and claimed sender of the offending transaction next to the reason, so errors
+// of large batches can be told apart.
+type AddError struct {
+	Hash common.Hash
+	From common.Address
+	Err  error
+}
+
+func (e *AddError) Error() string {
+	return fmt.Sprintf("transaction %v from %v: %v", e.Hash, e.From, e.Err)
+}
+
+// Unwrap returns the reason of the rejection, so errors.Is matches the sentinels.
+func (e *AddError) Unwrap() error {
+	return e.Err
+}
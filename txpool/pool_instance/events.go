@@ -9,6 +9,14 @@ import (
 // NewTxsEvent is posted when a batch of transactions enter the transaction pool.
 type NewTxsEvent struct{ Txs types.Transactions }
 
+// ReannoTxsEvent is posted periodically with the local pending transactions that
+// should be re-broadcast to the network.
+type ReannoTxsEvent struct{ Txs types.Transactions }
+
+// ReplacedEvent is posted when a transaction in the pool is superseded by another
+// one with the same sender and nonce, e.g. after a gas price bump.
+type ReplacedEvent struct{ Old, New common.Hash }
+
 // NewMinedBlockEvent is posted when a block has been imported.
 type NewMinedBlockEvent struct{ Block types.Block }
 
@@ -25,4 +33,6 @@ type ChainSideEvent struct {
 	Block types.Block
 }
 
-type ChainHeadEvent struct{ Block types.Block }
+// ChainHeadEvent is posted when a new block becomes the head of the chain, it is
+// an alias of the chain's own event to allow subscribing through BlockChain.
+type ChainHeadEvent = types.ChainHeadEvent
@@ -18,6 +18,7 @@ package txpool_instance
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
@@ -33,6 +34,15 @@ import (
 // into the journal, but no such file is currently open.
 var errNoActiveJournal = errors.New("no active journal")
 
+// errUnknownJournalFormat is returned if the journal is configured with an
+// encoding the pool doesn't know of.
+var errUnknownJournalFormat = errors.New("unknown journal format")
+
+const (
+	JournalFormatJSON = "json" // Journal transactions as JSON, one object per line
+	JournalFormatRLP  = "rlp"  // Journal transactions as a stream of RLP items
+)
+
 // devNull is a WriteCloser that just discards anything written into it. Its
 // goal is to allow the transaction journal to write into a fake journal when
 // loading transactions on startup without printing warnings due to no file
@@ -45,14 +55,29 @@ func (*devNull) Close() error                      { return nil }
 // journal is a rotating log of transactions with the aim of storing locally
 // created transactions to allow non-executed ones to survive node restarts.
 type journal struct {
-	path   string         // Filesystem path to store the transactions at
-	writer io.WriteCloser // Output stream to write new transactions into
+	path       string           // Filesystem path to store the transactions at
+	serializer utils.Serializer // Encoding of the transactions on disk
+	writer     io.WriteCloser   // Output stream to write new transactions into
 }
 
-// newTxJournal creates a new transaction journal to
-func newTxJournal(path string) *journal {
+// newTxJournal creates a new transaction journal to persist local transactions
+// at the given filesystem path, encoded with the given serializer.
+func newTxJournal(path string, serializer utils.Serializer) *journal {
 	return &journal{
-		path: path,
+		path:       path,
+		serializer: serializer,
+	}
+}
+
+// journalSerializer returns the serializer of the given journal format.
+func journalSerializer(format string) (utils.Serializer, error) {
+	switch format {
+	case JournalFormatJSON:
+		return new(utils.JsonSerializer), nil
+	case JournalFormatRLP:
+		return new(utils.RlpSerializer), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnknownJournalFormat, format)
 	}
 }
 
@@ -70,13 +95,18 @@ func (journal *journal) load(add func(types.Transactions) []error) error {
 	}
 	defer input.Close()
 
+	// Nothing valid can be longer than the journal itself, bound the decoder by
+	// it so a corrupt length prefix can't make it allocate arbitrary memory
+	info, err := input.Stat()
+	if err != nil {
+		return err
+	}
 	// Temporarily discard any journal additions (don't double add on load)
 	journal.writer = new(devNull)
 	defer func() { journal.writer = nil }()
 
 	// Inject all transactions from the journal into the pool
-	var serializer utils.JsonSerializer
-	stream := serializer.GetDecoder(input, 0)
+	stream := journal.serializer.GetDecoder(input, uint64(info.Size()))
 	total, dropped := 0, 0
 
 	// Create a method to load a limited batch of transactions and bump the
@@ -124,9 +154,7 @@ func (journal *journal) insert(tx *types.Transaction) error {
 	if journal.writer == nil {
 		return errNoActiveJournal
 	}
-	var serializer utils.JsonSerializer
-	Encoder := serializer.GetEncoder(journal.writer)
-	if err := Encoder.Encode(tx); err != nil {
+	if err := journal.serializer.GetEncoder(journal.writer).Encode(tx); err != nil {
 		return err
 	}
 	return nil
@@ -147,12 +175,11 @@ func (journal *journal) rotate(all map[common.Address]types.Transactions) error
 	if err != nil {
 		return err
 	}
-	var serializer utils.JsonSerializer
-	Encoder := serializer.GetEncoder(replacement)
+	encoder := journal.serializer.GetEncoder(replacement)
 	journaled := 0
 	for _, txs := range all {
 		for _, tx := range txs {
-			if err = Encoder.Encode(tx); err != nil {
+			if err = encoder.Encode(tx); err != nil {
 				replacement.Close()
 				return err
 			}
@@ -21,6 +21,7 @@ import (
 	"math/big"
 
 	"execution/types"
+	"execution/types/gadget"
 )
 
 // List is a "List" of transactions belonging to an account, sorted by account
@@ -51,10 +52,28 @@ func (l *List) Contains(nonce uint64) bool {
 	return l.txs.Get(nonce) != nil
 }
 
+// Overlaps returns whether the transaction specified has the same nonce as one
+// already contained within the List.
+func (l *List) Overlaps(tx *types.Transaction) bool {
+	return l.Contains(tx.Nonce)
+}
+
 func (l *List) GetCost(nonce uint64) *big.Int {
 	return l.txs.GetCost(nonce)
 }
 
+// SubTotalCost returns the cumulative cost of all transactions in the List with
+// a nonce lower than the given threshold.
+func (l *List) SubTotalCost(threshold uint64) *big.Int {
+	return l.txs.SubTotalCost(threshold)
+}
+
+// TotalCost returns the cumulative cost of all transactions in the List. The
+// AVL tree keeps the sum up to date on every mutation, so this is O(1).
+func (l *List) TotalCost() *big.Int {
+	return l.txs.TotalCost()
+}
+
 // Add tries to insert a new transaction into the List, returning whether the
 // transaction was accepted, and if yes, any previous transaction it replaced.
 //
@@ -63,24 +82,8 @@ func (l *List) GetCost(nonce uint64) *big.Int {
 func (l *List) Add(tx *types.Transaction, priceBump uint64) (bool, *types.Transaction) {
 	// If there's an older better transaction, abort
 	old := l.txs.Get(tx.Nonce)
-	if old != nil {
-		oldPrice := old.GasPrice.Price
-		newPrice := tx.GasPrice.Price
-		if oldPrice.Cmp(newPrice) >= 0 {
-			return false, nil
-		}
-		// threshold = oldPrice  * (100 + priceBump) / 100
-		a := big.NewInt(100 + int64(priceBump))
-		aFee := new(big.Int).Mul(a, oldPrice)
-		b := big.NewInt(100)
-		thresholdFeeCap := aFee.Div(aFee, b)
-
-		// We have to ensure that both the new fee cap and tip are higher than the
-		// old ones as well as checking the percentage threshold to ensure that
-		// this is accurate for low (Wei-level) gas price replacements.
-		if newPrice.Cmp(thresholdFeeCap) < 0 {
-			return false, nil
-		}
+	if old != nil && !bumpsPrice(old.GasPrice, tx.GasPrice, priceBump) {
+		return false, nil
 	}
 	// Otherwise overwrite the old transaction with the current one
 	// and update the total cost, gas cap and cost cap
@@ -94,6 +97,49 @@ func (l *List) Add(tx *types.Transaction, priceBump uint64) (bool, *types.Transa
 	return true, old
 }
 
+// Merge inserts all transactions of other into the List in one pass, resolving
+// nonce collisions via onConflict as SortedMap.Merge does instead of applying the
+// price bump rules of Add. The Lists' cost and gas thresholds are updated for the
+// inserted transactions, and the ones losing a conflict are returned.
+func (l *List) Merge(other *List, onConflict func(a, b *types.Transaction) *types.Transaction) types.Transactions {
+	dropped := l.txs.Merge(other.txs, onConflict)
+	for _, tx := range other.Flatten() {
+		if l.txs.Get(tx.Nonce) != tx {
+			continue
+		}
+		if cost := tx.Cost(); l.costcap.Cmp(cost) < 0 {
+			l.costcap = cost
+		}
+		if gas := tx.GasLimit; l.gascap < gas {
+			l.gascap = gas
+		}
+	}
+	return dropped
+}
+
+// bumpsPrice reports whether a replacement priced at next outbids a transaction
+// priced at old by at least priceBump percent, in both the fee cap and the tip.
+// Bumping only one of them isn't enough: a higher cap with the same tip doesn't
+// pay the block producer more, a higher tip under the same cap may not either
+// once the base fee rises.
+func bumpsPrice(old, next *gadget.GasPrice, priceBump uint64) bool {
+	var (
+		oldFeeCap, oldTip = old.GasFeeCap(), old.GasTipCap()
+		newFeeCap, newTip = next.GasFeeCap(), next.GasTipCap()
+	)
+	if oldFeeCap.Cmp(newFeeCap) >= 0 || oldTip.Cmp(newTip) >= 0 {
+		return false
+	}
+	// threshold = old * (100 + priceBump) / 100, for the fee cap and tip alike
+	thresholdFeeCap := old.Mul(100+int64(priceBump), 100)
+	thresholdTip := old.MulTip(100+int64(priceBump), 100)
+
+	// We have to ensure that both the new fee cap and tip are higher than the
+	// old ones as well as checking the percentage threshold to ensure that
+	// this is accurate for low (Wei-level) gas price replacements.
+	return newFeeCap.Cmp(thresholdFeeCap) >= 0 && newTip.Cmp(thresholdTip) >= 0
+}
+
 // Forward removes all transactions from the List with a nonce lower than the
 // provided threshold. Every removed transaction is returned for any post-removal
 // maintenance.
@@ -136,10 +182,8 @@ func (l *List) Filter(costLimit *big.Int, gasLimit uint64) (types.Transactions,
 				lowest = nonce
 			}
 		}
-		// TODO: we can use LastElement() here, may be more efficient
-		invalids = l.txs.Filter(func(tx *types.Transaction) bool { return tx.Nonce > lowest })
+		invalids = l.txs.RemoveAbove(lowest)
 	}
-	// Reset total cost
 	return removed, invalids
 }
 
@@ -161,9 +205,7 @@ func (l *List) Remove(tx *types.Transaction) (bool, types.Transactions) {
 	}
 	// In strict mode, filter out non-executable transactions
 	if l.strict {
-		// TODO: we can use LastElement() here, may be more efficient
-		txs := l.txs.Filter(func(tx *types.Transaction) bool { return tx.Nonce > nonce })
-		return true, txs
+		return true, l.txs.RemoveAbove(nonce)
 	}
 	return true, nil
 }
@@ -180,6 +222,12 @@ func (l *List) Ready(start uint64, threshold *big.Int) types.Transactions {
 	return txs
 }
 
+// ReadyWithGasCap works like Ready, but additionally caps the summed gas limit of
+// the retrieved transactions, e.g. to pull a single block's worth of them.
+func (l *List) ReadyWithGasCap(start uint64, costThreshold *big.Int, gasCap uint64) types.Transactions {
+	return l.txs.ReadyWithGasCap(start, costThreshold, gasCap)
+}
+
 // Len returns the length of the transaction List.
 func (l *List) Len() int {
 	return l.txs.Len()
@@ -190,13 +238,33 @@ func (l *List) Empty() bool {
 	return l.Len() == 0
 }
 
+// FilterByTip returns the nonce-sorted transactions of the List whose tip cap is
+// below the given threshold. The List itself is not modified.
+func (l *List) FilterByTip(tip *big.Int) types.Transactions {
+	var below types.Transactions
+	for _, tx := range l.Flatten() {
+		if tx.GasPrice.GasTipCap().Cmp(tip) < 0 {
+			below = append(below, tx)
+		}
+	}
+	return below
+}
+
 // Flatten creates a nonce-sorted slice of transactions based on the loosely
 // sorted internal representation. The result of the sorting is cached in case
-// it's requested again before any modifications are made to the contents.
+// it's requested again before any modifications are made to the contents, and
+// is shared with other callers, so it must not be modified in place.
 func (l *List) Flatten() types.Transactions {
 	return l.txs.Flatten()
 }
 
+// Snapshot returns a nonce-sorted copy of the transactions of the List, which the
+// caller may modify freely. It doesn't touch the cache of Flatten, so it can be
+// used by readers holding the pool's read lock only.
+func (l *List) Snapshot() types.Transactions {
+	return l.txs.Snapshot()
+}
+
 // LastElement returns the last element of a flattened List, thus, the
 // transaction with the highest nonce
 func (l *List) LastElement() *types.Transaction {
@@ -0,0 +1,548 @@
+package txpool_instance
+
+import (
+	"bytes"
+	"container/heap"
+	"execution/common"
+	"execution/crypto"
+	"execution/types"
+	"math"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Tests that the total cost tracked by a List always equals the sum of the costs
+// of its contents after a randomized sequence of operations.
+func TestListTotalCost(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	list := NewList(false)
+	for i := 0; i < 2000; i++ {
+		switch rand.Intn(6) {
+		case 0, 1:
+			list.Add(pricedTransaction(uint64(rand.Intn(64)), uint64(21000+rand.Intn(100000)), big.NewInt(int64(1+rand.Intn(100))), key), DefaultConfig.PriceBump)
+		case 2:
+			if txs := list.Flatten(); len(txs) > 0 {
+				list.Remove(txs[rand.Intn(len(txs))])
+			}
+		case 3:
+			list.Forward(uint64(rand.Intn(16)))
+		case 4:
+			list.Filter(big.NewInt(int64(rand.Intn(10000000))), uint64(21000+rand.Intn(100000)))
+		case 5:
+			list.Cap(rand.Intn(32))
+		}
+		want := new(big.Int)
+		for _, tx := range list.Flatten() {
+			want.Add(want, tx.Cost())
+		}
+		if have := list.TotalCost(); have.Cmp(want) != 0 {
+			t.Fatalf("op %d: total cost mismatch: have %v, want %v", i, have, want)
+		}
+	}
+	// Ensure the returned total can't be used to corrupt the list
+	list.TotalCost().SetInt64(-1)
+	if list.TotalCost().Sign() < 0 {
+		t.Fatalf("total cost modified through returned value")
+	}
+}
+
+// Tests that remotes below a tip are selected by effective tip once a base fee is
+// given, always including those that can't cover the base fee, and never locals.
+func TestRemotesBelowTip(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	var (
+		lookup  = NewLookup()
+		legacy  = pricedTransaction(0, 100000, big.NewInt(10), key)
+		capped  = dynamicFeeTransaction(1, 100000, big.NewInt(12), big.NewInt(5), key)
+		tipped  = dynamicFeeTransaction(2, 100000, big.NewInt(20), big.NewInt(5), key)
+		starved = dynamicFeeTransaction(3, 100000, big.NewInt(6), big.NewInt(5), key)
+		local   = pricedTransaction(4, 100000, big.NewInt(1), key)
+	)
+	for _, tx := range []*types.Transaction{legacy, capped, tipped, starved} {
+		lookup.Add(tx, false)
+	}
+	lookup.Add(local, true)
+
+	below := func(threshold, baseFee *big.Int) map[common.Hash]bool {
+		found := make(map[common.Hash]bool)
+		for _, tx := range lookup.RemotesBelowTip(threshold, baseFee) {
+			found[tx.TxHash] = true
+		}
+		return found
+	}
+	// Without a base fee, the tip caps are compared
+	if found := below(big.NewInt(6), nil); len(found) != 3 || found[legacy.TxHash] {
+		t.Errorf("tip cap selection mismatch: have %d transactions, legacy included: %v", len(found), found[legacy.TxHash])
+	}
+	// With a base fee of 7, the effective tips are 3, 5, 5 and uncovered
+	found := below(big.NewInt(4), big.NewInt(7))
+	if len(found) != 2 || !found[legacy.TxHash] || !found[starved.TxHash] {
+		t.Errorf("effective tip selection mismatch: have %d transactions", len(found))
+	}
+}
+
+// Tests that after a base fee update the urgent heap sorts by effective tip while
+// the floating heap keeps sorting by fee cap, ordering the same set differently.
+func TestPricedListBaseFee(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	var (
+		lookup = NewLookup()
+		priced = NewPricedList(lookup)
+		capped = dynamicFeeTransaction(0, 100000, big.NewInt(100), big.NewInt(1), key)
+		tipped = dynamicFeeTransaction(1, 100000, big.NewInt(20), big.NewInt(10), key)
+	)
+	for _, tx := range []*types.Transaction{capped, tipped} {
+		lookup.Add(tx, false)
+		priced.Put(tx, false)
+	}
+	priced.SetBaseFee(big.NewInt(15))
+
+	if priced.floating.baseFee != nil {
+		t.Fatalf("floating heap base fee set: %v", priced.floating.baseFee)
+	}
+	// Effective tips are 1 and 5, fee caps are 100 and 20
+	if priced.urgent.cmp(capped, tipped) >= 0 {
+		t.Errorf("urgent heap doesn't sort by effective tip")
+	}
+	if priced.floating.cmp(capped, tipped) <= 0 {
+		t.Errorf("floating heap doesn't sort by fee cap")
+	}
+	// Rebuilding the heaps keeps both metrics apart
+	priced.Reheap()
+	if priced.urgent.baseFee == nil || priced.urgent.baseFee.Cmp(big.NewInt(15)) != 0 {
+		t.Errorf("urgent heap base fee mismatch after reheap: have %v, want %v", priced.urgent.baseFee, 15)
+	}
+	if priced.floating.baseFee != nil {
+		t.Errorf("floating heap base fee set after reheap: %v", priced.floating.baseFee)
+	}
+	if have := len(priced.urgent.list) + len(priced.floating.list); have != 2 {
+		t.Errorf("heaped transaction count mismatch: have %d, want %d", have, 2)
+	}
+}
+
+// Tests that equal priced transactions are evicted in hash order, regardless of
+// the order they were added in and of their senders and nonces.
+func TestPriceHeapTieBreak(t *testing.T) {
+	var txs types.Transactions
+	for i := 0; i < 8; i++ {
+		key, _ := crypto.GenerateKey()
+		for nonce := uint64(0); nonce < 4; nonce++ {
+			txs = append(txs, pricedTransaction(nonce, 100000, big.NewInt(1), key))
+		}
+	}
+	var first types.Transactions
+	for run := 0; run < 10; run++ {
+		rand.Shuffle(len(txs), func(i, j int) { txs[i], txs[j] = txs[j], txs[i] })
+
+		h := new(priceHeap)
+		for _, tx := range txs {
+			heap.Push(h, tx)
+		}
+		var evicted types.Transactions
+		for h.Len() > 0 {
+			evicted = append(evicted, heap.Pop(h).(*types.Transaction))
+		}
+		for i := 1; i < len(evicted); i++ {
+			prev, next := evicted[i-1].Hash(), evicted[i].Hash()
+			if bytes.Compare(prev[:], next[:]) >= 0 {
+				t.Fatalf("run %d: eviction %d out of hash order: %x after %x", run, i, next, prev)
+			}
+		}
+		if first == nil {
+			first = evicted
+			continue
+		}
+		for i := range evicted {
+			if evicted[i] != first[i] {
+				t.Fatalf("run %d: eviction %d mismatch: have %x, want %x", run, i, evicted[i].Hash(), first[i].Hash())
+			}
+		}
+	}
+	// A hash order independent of nonces evicts the highest nonces first only by
+	// chance, the odds of which are negligible for this many accounts
+	highFirst := true
+	for _, tx := range first[:8] {
+		if tx.Nonce != 3 {
+			highFirst = false
+		}
+	}
+	if highFirst {
+		t.Errorf("highest nonces evicted first")
+	}
+}
+
+// Tests that the priced list re-heaps once the configured ratio of stale price
+// points is exceeded, and never in lazy mode.
+func TestPricedListStaleRatio(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	fill := func(staleRatio int, lazy bool) (*Lookup, *PricedList, types.Transactions) {
+		lookup := NewLookup()
+		priced := NewPricedList(lookup)
+		priced.staleRatio, priced.lazy = staleRatio, lazy
+
+		txs := make(types.Transactions, 100)
+		for i := range txs {
+			txs[i] = pricedTransaction(uint64(i), 21000, big.NewInt(int64(1+i)), key)
+			lookup.Add(txs[i], false)
+			priced.Put(txs[i], false)
+		}
+		return lookup, priced, txs
+	}
+	lookup, priced, txs := fill(50, false)
+	for _, tx := range txs[:50] {
+		lookup.Remove(tx.Hash())
+	}
+	priced.Removed(50)
+	if stales := priced.stales.Load(); stales != 50 {
+		t.Fatalf("re-heaped within stale ratio: stales %d", stales)
+	}
+	lookup.Remove(txs[50].Hash())
+	priced.Removed(1)
+	if stales := priced.stales.Load(); stales != 0 {
+		t.Fatalf("no re-heap beyond stale ratio: stales %d", stales)
+	}
+	if have := len(priced.urgent.list) + len(priced.floating.list); have != 49 {
+		t.Fatalf("heaped transaction count mismatch: have %d, want %d", have, 49)
+	}
+	// Lazy lists keep stale price points around until reached
+	lookup, priced, txs = fill(50, true)
+	for _, tx := range txs[:99] {
+		lookup.Remove(tx.Hash())
+	}
+	priced.Removed(99)
+	if stales := priced.stales.Load(); stales != 99 {
+		t.Fatalf("lazy list re-heaped: stales %d", stales)
+	}
+	if !priced.Underpriced(pricedTransaction(0, 21000, big.NewInt(1), key)) {
+		t.Fatalf("cheap transaction not underpriced")
+	}
+	if priced.Underpriced(pricedTransaction(0, 21000, big.NewInt(101), key)) {
+		t.Fatalf("expensive transaction underpriced after lazy cleanup")
+	}
+}
+
+// Benchmarks a priced list under constant churn, each operation removing the
+// oldest transaction, adding a new one and checking a price, with the stale price
+// points purged by re-heaping at different ratios or lazily on access.
+func BenchmarkPricedListChurn25(b *testing.B)   { benchmarkPricedListChurn(b, 25, false) }
+func BenchmarkPricedListChurn100(b *testing.B)  { benchmarkPricedListChurn(b, 100, false) }
+func BenchmarkPricedListChurnLazy(b *testing.B) { benchmarkPricedListChurn(b, 25, true) }
+
+func benchmarkPricedListChurn(b *testing.B, staleRatio int, lazy bool) {
+	key, _ := crypto.GenerateKey()
+
+	lookup := NewLookup()
+	priced := NewPricedList(lookup)
+	priced.staleRatio, priced.lazy = staleRatio, lazy
+
+	txs := make(types.Transactions, 10000+b.N)
+	for i := range txs {
+		txs[i] = pricedTransaction(uint64(i), 21000, big.NewInt(int64(1+rand.Intn(1000))), key)
+	}
+	for _, tx := range txs[:10000] {
+		lookup.Add(tx, false)
+		priced.Put(tx, false)
+	}
+	probe := pricedTransaction(0, 21000, big.NewInt(500), key)
+	reheaps := 0
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lookup.Remove(txs[i].Hash())
+		priced.Removed(1)
+		if priced.stales.Load() == 0 {
+			reheaps++
+		}
+		lookup.Add(txs[10000+i], false)
+		priced.Put(txs[10000+i], false)
+		priced.Underpriced(probe)
+	}
+	b.ReportMetric(float64(reheaps)/float64(b.N), "reheaps/op")
+}
+
+// Tests that removing from a strict list invalidates exactly the transactions
+// above the removed nonce, and nothing when the last one is removed.
+func TestStrictListRemove(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	txs := make(types.Transactions, 10)
+	list := NewList(true)
+	for i := range txs {
+		txs[i] = transaction(uint64(i), 21000, key)
+		list.Add(txs[i], DefaultConfig.PriceBump)
+	}
+	if removed, invalids := list.Remove(txs[9]); !removed || len(invalids) != 0 {
+		t.Fatalf("last transaction removal mismatch: removed %v, invalidated %d", removed, len(invalids))
+	}
+	removed, invalids := list.Remove(txs[4])
+	if !removed || len(invalids) != 4 {
+		t.Fatalf("inner transaction removal mismatch: removed %v, invalidated %d, want 4", removed, len(invalids))
+	}
+	for i, tx := range invalids {
+		if tx.Nonce != uint64(5+i) {
+			t.Errorf("invalidated transaction %d nonce mismatch: have %d, want %d", i, tx.Nonce, 5+i)
+		}
+	}
+	if list.Len() != 4 {
+		t.Fatalf("list length mismatch: have %d, want %d", list.Len(), 4)
+	}
+}
+
+// Tests that filtering a strict list drops exactly the unaffordable transactions
+// and the ones above the lowest of them, never a cheaper transaction below, even
+// if the list has a nonce gap.
+func TestStrictListFilter(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	// Every transaction costs 21000 * price + 100, the limit affords price 10
+	limit := big.NewInt(21000*10 + 100)
+
+	tests := []struct {
+		nonces    []uint64
+		expensive []uint64
+		removed   []uint64
+		invalids  []uint64
+		remaining []uint64
+	}{
+		{[]uint64{0, 1, 2, 3, 4}, nil, nil, nil, []uint64{0, 1, 2, 3, 4}},
+		{[]uint64{0, 1, 2, 3, 4}, []uint64{3}, []uint64{3}, []uint64{4}, []uint64{0, 1, 2}},
+		{[]uint64{0, 1, 2, 3, 4}, []uint64{4}, []uint64{4}, nil, []uint64{0, 1, 2, 3}},
+		{[]uint64{0, 1, 2, 3, 4}, []uint64{1, 3}, []uint64{1, 3}, []uint64{2, 4}, []uint64{0}},
+		{[]uint64{0, 1, 3, 4}, []uint64{4}, []uint64{4}, nil, []uint64{0, 1, 3}},
+		{[]uint64{0, 1, 3, 4}, []uint64{3}, []uint64{3}, []uint64{4}, []uint64{0, 1}},
+		{[]uint64{0, 1, 3, 4}, []uint64{0}, []uint64{0}, []uint64{1, 3, 4}, nil},
+	}
+	nonces := func(txs types.Transactions) []uint64 {
+		var nonces []uint64
+		for _, tx := range txs {
+			nonces = append(nonces, tx.Nonce)
+		}
+		sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+		return nonces
+	}
+	for i, tt := range tests {
+		list := NewList(true)
+		for _, nonce := range tt.nonces {
+			price := big.NewInt(1)
+			for _, expensive := range tt.expensive {
+				if nonce == expensive {
+					price = big.NewInt(20)
+				}
+			}
+			list.Add(pricedTransaction(nonce, 21000, price, key), DefaultConfig.PriceBump)
+		}
+		removed, invalids := list.Filter(limit, 21000)
+		if have := nonces(removed); !reflect.DeepEqual(have, tt.removed) {
+			t.Errorf("test %d: removed mismatch: have %v, want %v", i, have, tt.removed)
+		}
+		if have := nonces(invalids); !reflect.DeepEqual(have, tt.invalids) {
+			t.Errorf("test %d: invalidated mismatch: have %v, want %v", i, have, tt.invalids)
+		}
+		if have := nonces(list.Flatten()); !reflect.DeepEqual(have, tt.remaining) {
+			t.Errorf("test %d: remaining mismatch: have %v, want %v", i, have, tt.remaining)
+		}
+	}
+}
+
+// Tests that merging lists keeps the higher priced transaction on nonce conflicts
+// by default, defers to a custom resolver otherwise, and keeps the totals sane.
+func TestListMerge(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	build := func(prices map[uint64]int64) (*List, map[uint64]*types.Transaction) {
+		list, txs := NewList(false), make(map[uint64]*types.Transaction)
+		for nonce, price := range prices {
+			txs[nonce] = pricedTransaction(nonce, 21000, big.NewInt(price), key)
+			list.Add(txs[nonce], DefaultConfig.PriceBump)
+		}
+		return list, txs
+	}
+	into, olds := build(map[uint64]int64{0: 1, 1: 5, 2: 1})
+	from, news := build(map[uint64]int64{1: 3, 2: 2, 3: 1})
+
+	dropped := into.Merge(from, nil)
+	if len(dropped) != 2 || dropped[0] != news[1] || dropped[1] != olds[2] {
+		t.Fatalf("dropped transactions mismatch: have %v", dropped)
+	}
+	want := []*types.Transaction{olds[0], olds[1], news[2], news[3]}
+	if have := into.Flatten(); len(have) != len(want) {
+		t.Fatalf("merged length mismatch: have %d, want %d", len(have), len(want))
+	} else {
+		for i := range want {
+			if have[i] != want[i] {
+				t.Errorf("merged transaction %d mismatch: have %x, want %x", i, have[i].Hash(), want[i].Hash())
+			}
+		}
+	}
+	if from.Len() != 3 {
+		t.Errorf("source list modified: have %d transactions, want 3", from.Len())
+	}
+	total := new(big.Int)
+	for _, tx := range want {
+		total.Add(total, tx.Cost())
+	}
+	if have := into.TotalCost(); have.Cmp(total) != 0 {
+		t.Errorf("total cost mismatch: have %v, want %v", have, total)
+	}
+	// Merge with a resolver always taking the incoming transaction
+	into, olds = build(map[uint64]int64{0: 1, 1: 5, 2: 1})
+	dropped = into.Merge(from, func(a, b *types.Transaction) *types.Transaction { return b })
+	if len(dropped) != 2 || dropped[0] != olds[1] || dropped[1] != olds[2] {
+		t.Fatalf("dropped transactions mismatch: have %v", dropped)
+	}
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		if have := into.txs.Get(nonce); have != news[nonce] {
+			t.Errorf("nonce %d: merged transaction mismatch: have %x, want %x", nonce, have.Hash(), news[nonce].Hash())
+		}
+	}
+}
+
+// Benchmarks removing a transaction close to the end of a 1000 entry strict list,
+// invalidating the few transactions above it.
+func BenchmarkStrictListRemove(b *testing.B) {
+	key, _ := crypto.GenerateKey()
+
+	txs := make(types.Transactions, 1000)
+	list := NewList(true)
+	for i := range txs {
+		txs[i] = transaction(uint64(i), 21000, key)
+		list.Add(txs[i], DefaultConfig.PriceBump)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, invalids := list.Remove(txs[990])
+
+		b.StopTimer()
+		list.Add(txs[990], DefaultConfig.PriceBump)
+		for _, tx := range invalids {
+			list.Add(tx, DefaultConfig.PriceBump)
+		}
+		b.StartTimer()
+	}
+}
+
+// Tests that FilterByTip returns the transactions below the tip in nonce order,
+// without removing them from the list.
+func TestListFilterByTip(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	list := NewList(false)
+	for i, tip := range []int64{5, 1, 3, 2, 5} {
+		list.Add(pricedTransaction(uint64(i), 21000, big.NewInt(tip), key), DefaultConfig.PriceBump)
+	}
+	below := list.FilterByTip(big.NewInt(3))
+	if len(below) != 2 || below[0].Nonce != 1 || below[1].Nonce != 3 {
+		t.Fatalf("filtered transactions mismatch: have %d, want nonces 1 and 3", len(below))
+	}
+	if below := list.FilterByTip(big.NewInt(1)); len(below) != 0 {
+		t.Fatalf("filtered transactions mismatch: have %d, want %d", len(below), 0)
+	}
+	if list.Len() != 5 {
+		t.Fatalf("list modified: have %d transactions, want %d", list.Len(), 5)
+	}
+}
+
+// Tests that a Lookup snapshot contains the requested sets and can be iterated
+// while modifying the Lookup, which would deadlock from within Range.
+func TestLookupFlatten(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	lookup := NewLookup()
+	for i := 0; i < 4; i++ {
+		lookup.Add(transaction(uint64(i), 21000, key), i%2 == 0)
+	}
+	if txs := lookup.Flatten(true, false); len(txs) != 2 {
+		t.Fatalf("local snapshot size mismatch: have %d, want %d", len(txs), 2)
+	}
+	if txs := lookup.Flatten(false, true); len(txs) != 2 {
+		t.Fatalf("remote snapshot size mismatch: have %d, want %d", len(txs), 2)
+	}
+	txs := lookup.Flatten(true, true)
+	if len(txs) != 4 {
+		t.Fatalf("snapshot size mismatch: have %d, want %d", len(txs), 4)
+	}
+	for _, tx := range txs {
+		lookup.Remove(tx.Hash())
+	}
+	if count := lookup.Count(); count != 0 {
+		t.Fatalf("lookup not emptied: have %d transactions", count)
+	}
+}
+
+// Tests that flattening caches the sorted transactions until the next change to
+// the list, and that earlier results are left untouched by later changes.
+func TestListFlattenCache(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	list := NewList(false)
+	for i := 0; i < 10; i++ {
+		list.Add(transaction(uint64(i), 0, key), DefaultConfig.PriceBump)
+	}
+	first, second := list.Flatten(), list.Flatten()
+	if len(first) != 10 || &first[0] != &second[0] || len(first) != len(second) || cap(first) != cap(second) {
+		t.Fatalf("unmodified list flattened twice into different slices")
+	}
+	// Appending to a result must not leak into the cache
+	_ = append(first, transaction(10, 0, key))
+	if third := list.Flatten(); len(third) != 10 || &third[0] != &first[0] {
+		t.Fatalf("cache changed by appending to a flattened result")
+	}
+	snapshot := append(types.Transactions{}, first...)
+
+	nonces := func(txs types.Transactions) []uint64 {
+		var nonces []uint64
+		for _, tx := range txs {
+			nonces = append(nonces, tx.Nonce)
+		}
+		return nonces
+	}
+	// Every kind of modification must be reflected by the next flattening
+	check := func(name string, want []uint64) {
+		t.Helper()
+		if have := nonces(list.Flatten()); !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: flattened nonces mismatch: have %v, want %v", name, have, want)
+		}
+		if !reflect.DeepEqual(first, snapshot) {
+			t.Fatalf("%s: earlier flattened result was modified", name)
+		}
+	}
+	list.Forward(2)
+	check("forward", []uint64{2, 3, 4, 5, 6, 7, 8, 9})
+
+	list.Cap(7)
+	check("cap", []uint64{2, 3, 4, 5, 6, 7, 8})
+
+	list.Remove(transaction(8, 0, key))
+	check("remove", []uint64{2, 3, 4, 5, 6, 7})
+
+	list.Add(transaction(8, 0, key), DefaultConfig.PriceBump)
+	check("put", []uint64{2, 3, 4, 5, 6, 7, 8})
+
+	list.txs.Filter(func(tx *types.Transaction) bool { return tx.Nonce == 5 })
+	check("filter", []uint64{2, 3, 4, 6, 7, 8})
+
+	list.txs.RemoveAbove(6)
+	check("remove above", []uint64{2, 3, 4, 6})
+
+	list.Ready(3, big.NewInt(math.MaxInt64))
+	check("ready", []uint64{6})
+
+	// Retrieving nothing must leave the cache in place
+	cached := list.Flatten()
+	if ready := list.Ready(5, big.NewInt(math.MaxInt64)); len(ready) != 0 {
+		t.Fatalf("gapped list retrieved %d transactions", len(ready))
+	}
+	if ready := list.Ready(6, big.NewInt(0)); len(ready) != 0 {
+		t.Fatalf("unaffordable list retrieved %d transactions", len(ready))
+	}
+	if have := list.Flatten(); &have[0] != &cached[0] {
+		t.Fatalf("cache dropped by retrieving no transactions")
+	}
+}
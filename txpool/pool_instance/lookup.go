@@ -35,7 +35,10 @@ func (as *accountSet) contains(addr common.Address) bool {
 // containsTx checks if the sender of a given tx is within the set. If the sender
 // cannot be derived, this method returns false.
 func (as *accountSet) containsTx(tx *types.Transaction) bool {
-	return as.contains(tx.From)
+	if addr, err := tx.Sender(); err == nil {
+		return as.contains(addr)
+	}
+	return false
 }
 
 // add inserts a new address into the set to track.
@@ -46,7 +49,22 @@ func (as *accountSet) add(addr common.Address) {
 
 // addTx adds the sender of tx into the set.
 func (as *accountSet) addTx(tx *types.Transaction) {
-	as.add(tx.From)
+	if addr, err := tx.Sender(); err == nil {
+		as.add(addr)
+	}
+}
+
+// remove deletes an address from the set.
+func (as *accountSet) remove(addr common.Address) {
+	delete(as.accounts, addr)
+	as.cache = nil
+}
+
+// removeTx deletes the sender of tx from the set.
+func (as *accountSet) removeTx(tx *types.Transaction) {
+	if addr, err := tx.Sender(); err == nil {
+		as.remove(addr)
+	}
 }
 
 // flatten returns the list of addresses within this set, also caching it for later
@@ -100,6 +118,10 @@ func NewLookup() *Lookup {
 // Range calls f on each key and value present in the map. The callback passed
 // should return the indicator whether the iteration needs to be continued.
 // Callers need to specify which set (or both) to be iterated.
+//
+// The Lookup stays read locked while iterating, so the callback must not call
+// back into the Lookup, and should be short to not block writers. Use Flatten
+// to iterate a snapshot instead.
 func (t *Lookup) Range(f func(hash common.Hash, tx *types.Transaction, local bool) bool, local bool, remote bool) {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
@@ -120,6 +142,34 @@ func (t *Lookup) Range(f func(hash common.Hash, tx *types.Transaction, local boo
 	}
 }
 
+// Flatten returns a snapshot of the transactions in the requested sets (or both),
+// copied under a brief read lock. The result may be iterated without holding any
+// lock, but doesn't reflect later changes to the Lookup.
+func (t *Lookup) Flatten(local bool, remote bool) types.Transactions {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	var size int
+	if local {
+		size += len(t.locals)
+	}
+	if remote {
+		size += len(t.remotes)
+	}
+	txs := make(types.Transactions, 0, size)
+	if local {
+		for _, tx := range t.locals {
+			txs = append(txs, tx)
+		}
+	}
+	if remote {
+		for _, tx := range t.remotes {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
 // Get returns a transaction if it exists in the Lookup, or nil if not found.
 func (t *Lookup) Get(hash common.Hash) *types.Transaction {
 	t.lock.RLock()
@@ -188,9 +238,9 @@ func (t *Lookup) Add(tx *types.Transaction, local bool) {
 	slotsGauge.Update(int64(t.slots))
 
 	if local {
-		t.locals[tx.TxHash] = tx
+		t.locals[tx.Hash()] = tx
 	} else {
-		t.remotes[tx.TxHash] = tx
+		t.remotes[tx.Hash()] = tx
 	}
 }
 
@@ -232,14 +282,15 @@ func (t *Lookup) RemoteToLocals(locals *accountSet) int {
 }
 
 // RemotesBelowTip finds all remote transactions below the given tip threshold.
-func (t *Lookup) RemotesBelowTip(threshold *big.Int) types.Transactions {
+// If a base fee is given, effective tips are compared instead of tip caps, and
+// transactions whose fee cap can't even cover the base fee are always included.
+func (t *Lookup) RemotesBelowTip(threshold *big.Int, baseFee *big.Int) types.Transactions {
 	found := make(types.Transactions, 0, 128)
-	t.Range(func(hash common.Hash, tx *types.Transaction, local bool) bool {
-		if tx.GasPrice.Price.Cmp(threshold) < 0 {
+	for _, tx := range t.Flatten(false, true) { // Only iterate remotes
+		if tip, err := tx.EffectiveGasTip(baseFee); err != nil || tip.Cmp(threshold) < 0 {
 			found = append(found, tx)
 		}
-		return true
-	}, false, true) // Only iterate remotes
+	}
 	return found
 }
 
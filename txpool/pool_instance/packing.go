@@ -0,0 +1,113 @@
+package txpool_instance
+
+import (
+	"bytes"
+	"container/heap"
+	"execution/common"
+	"execution/types"
+	"math/big"
+)
+
+// packHead is the next transaction of an account in line for packing, together
+// with its effective tip under the packing base fee.
+type packHead struct {
+	from common.Address
+	tx   *types.Transaction
+	tip  *big.Int
+}
+
+// packHeap is a heap.Interface implementation over account heads, retrieving the
+// highest effective tip first. Ties are broken by the sender address to keep the
+// packing deterministic.
+type packHeap []*packHead
+
+func (h packHeap) Len() int      { return len(h) }
+func (h packHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h packHeap) Less(i, j int) bool {
+	if c := h[i].tip.Cmp(h[j].tip); c != 0 {
+		return c > 0
+	}
+	return bytes.Compare(h[i].from[:], h[j].from[:]) < 0
+}
+
+func (h *packHeap) Push(x interface{}) {
+	*h = append(*h, x.(*packHead))
+}
+
+func (h *packHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[0 : n-1]
+	return x
+}
+
+// packer merges nonce sorted per account transaction lists into a single sequence
+// ordered by effective tip, while keeping the nonce order within each account.
+// Only the next transaction of every account competes on price at any time.
+type packer struct {
+	txs     map[common.Address]types.Transactions // Transactions per account following its head
+	heads   packHeap                              // Next transaction of every account, by tip
+	baseFee *big.Int                              // Base fee to compute the effective tips with
+}
+
+// newPacker creates a packer over the given nonce sorted transaction lists. The
+// lists are only read, never modified.
+func newPacker(txs map[common.Address][]*types.Transaction, baseFee *big.Int) *packer {
+	p := &packer{
+		txs:     make(map[common.Address]types.Transactions, len(txs)),
+		heads:   make(packHeap, 0, len(txs)),
+		baseFee: baseFee,
+	}
+	for from, list := range txs {
+		if head := p.next(from, list); head != nil {
+			p.heads = append(p.heads, head)
+		}
+	}
+	heap.Init(&p.heads)
+	return p
+}
+
+// next turns the first transaction of the list into the head of its account,
+// retaining the rest. Nil is returned if the list is exhausted or its first
+// transaction can't pay the base fee, which blocks all later ones too.
+func (p *packer) next(from common.Address, list types.Transactions) *packHead {
+	if len(list) == 0 {
+		delete(p.txs, from)
+		return nil
+	}
+	tip, err := list[0].EffectiveGasTip(p.baseFee)
+	if err != nil {
+		delete(p.txs, from)
+		return nil
+	}
+	p.txs[from] = list[1:]
+	return &packHead{from: from, tx: list[0], tip: tip}
+}
+
+// peek returns the best priced transaction available for packing, nil if none.
+func (p *packer) peek() *types.Transaction {
+	if len(p.heads) == 0 {
+		return nil
+	}
+	return p.heads[0].tx
+}
+
+// shift replaces the best priced transaction by the next one of the same account.
+func (p *packer) shift() {
+	from := p.heads[0].from
+	if head := p.next(from, p.txs[from]); head != nil {
+		p.heads[0] = head
+		heap.Fix(&p.heads, 0)
+		return
+	}
+	heap.Pop(&p.heads)
+}
+
+// pop drops the best priced transaction along with all later ones of its account.
+func (p *packer) pop() {
+	delete(p.txs, p.heads[0].from)
+	heap.Pop(&p.heads)
+}
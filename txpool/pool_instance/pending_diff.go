@@ -0,0 +1,89 @@
+package txpool_instance
+
+import (
+	"execution/common"
+	"execution/types"
+)
+
+// pendingChange is a single insertion into or removal from the pending set.
+type pendingChange struct {
+	tx      *types.Transaction
+	removed bool
+}
+
+// pendingLog is a fixed size ring buffer of the most recent changes to the pending
+// set, each tagged with a monotonically increasing sequence number. It allows
+// mirrors of the pool to catch up incrementally instead of re-fetching the
+// whole content.
+//
+// Note, the log is not thread safe, it relies on the pool lock being held!
+type pendingLog struct {
+	changes []pendingChange // Ring of recent changes, change seq lives at (seq-1) % len
+	seq     uint64          // Sequence number of the last recorded change
+}
+
+// newPendingLog creates a change log retaining the last slots changes.
+func newPendingLog(slots uint64) *pendingLog {
+	return &pendingLog{changes: make([]pendingChange, slots)}
+}
+
+// added records the insertion of a batch of transactions into the pending set.
+func (l *pendingLog) added(txs ...*types.Transaction) {
+	for _, tx := range txs {
+		l.record(tx, false)
+	}
+}
+
+// removed records the removal of a batch of transactions from the pending set.
+func (l *pendingLog) removed(txs ...*types.Transaction) {
+	for _, tx := range txs {
+		l.record(tx, true)
+	}
+}
+
+func (l *pendingLog) record(tx *types.Transaction, removed bool) {
+	l.changes[l.seq%uint64(len(l.changes))] = pendingChange{tx: tx, removed: removed}
+	l.seq++
+}
+
+// covers reports whether all changes after since are still retained.
+func (l *pendingLog) covers(since uint64) bool {
+	return since <= l.seq && l.seq-since <= uint64(len(l.changes))
+}
+
+// diff folds the changes recorded after since into the net set of transactions
+// that entered and left the pending set. Transactions which both entered and
+// left in the meantime are reported in neither. The log must cover since.
+func (l *pendingLog) diff(since uint64) (added, removed types.Transactions) {
+	type netChange struct {
+		tx      *types.Transaction
+		existed bool // Whether the transaction was pending at since
+		present bool // Whether the transaction is currently pending
+	}
+	var (
+		order []common.Hash
+		net   = make(map[common.Hash]*netChange)
+	)
+	for seq := since; seq < l.seq; seq++ {
+		change := l.changes[seq%uint64(len(l.changes))]
+		hash := change.tx.Hash()
+
+		entry := net[hash]
+		if entry == nil {
+			entry = &netChange{tx: change.tx, existed: change.removed}
+			net[hash] = entry
+			order = append(order, hash)
+		}
+		entry.present = !change.removed
+	}
+	for _, hash := range order {
+		entry := net[hash]
+		switch {
+		case !entry.existed && entry.present:
+			added = append(added, entry.tx)
+		case entry.existed && !entry.present:
+			removed = append(removed, entry.tx)
+		}
+	}
+	return added, removed
+}
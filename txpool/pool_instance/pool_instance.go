@@ -1,10 +1,14 @@
 package txpool_instance
 
 import (
+	"context"
 	"execution/common"
+	"execution/consensus/misc"
 	"execution/params"
 	"execution/state"
 	"execution/types"
+	"execution/types/gadget"
+	"fmt"
 	"math"
 	"math/big"
 	"sort"
@@ -12,6 +16,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/common/prque"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
@@ -33,8 +38,15 @@ type Config struct {
 	Journal   string           // Journal of local transactions to survive node restarts
 	Rejournal time.Duration    // Time interval to regenerate the local transaction journal
 
-	PriceLimit uint64 // Minimum gas price to enforce for acceptance into the pool
-	PriceBump  uint64 // Minimum price bump percentage to replace an already existing transaction (nonce)
+	JournalFormat string // Encoding of the journal, JournalFormatJSON or the more compact JournalFormatRLP
+
+	Reannounce time.Duration // Time interval to reannounce local pending transactions
+
+	PriceLimit  uint64   // Minimum gas price to enforce for acceptance into the pool
+	PriceBump   uint64   // Minimum price bump percentage to replace an already existing transaction (nonce)
+	MaxGasPrice *big.Int // Maximum gas price to accept into the pool, nil for no limit
+	MaxTxSize   uint64   // Maximum encoded size of a transaction to accept into the pool
+	AllowHighS  bool     // Whether to accept high-S signatures, e.g. during a tooling migration
 
 	AccountSlots uint64 // Number of executable transaction slots guaranteed per account
 	GlobalSlots  uint64 // Maximum number of executable transaction slots for all accounts
@@ -42,6 +54,12 @@ type Config struct {
 	GlobalQueue  uint64 // Maximum number of non-executable transaction slots for all accounts
 
 	Lifetime time.Duration // Maximum amount of time non-executable transaction are queued
+
+	PendingDiffSlots uint64 // Number of recent pending set changes retained for PendingDiff
+	MinedCache       uint64 // Number of recently mined transaction hashes to reject re-adds of
+
+	StaleRatio       uint64 // Percentage of stale price points tolerated before re-heaping the priced list
+	LazyPriceCleanup bool   // Whether to pop stale price points on access instead of re-heaping
 }
 
 // DefaultConfig contains the default configurations for the transaction pool.
@@ -49,8 +67,13 @@ var DefaultConfig = Config{
 	Journal:   "transactions.encoded",
 	Rejournal: time.Hour,
 
+	JournalFormat: JournalFormatJSON,
+
+	Reannounce: 10 * time.Minute,
+
 	PriceLimit: 1,
 	PriceBump:  10,
+	MaxTxSize:  txMaxSize,
 
 	AccountSlots: 16,
 	GlobalSlots:  4096 + 1024, // urgent + floating queue capacity with 4:1 ratio
@@ -58,6 +81,11 @@ var DefaultConfig = Config{
 	GlobalQueue:  1024,
 
 	Lifetime: 3 * time.Hour,
+
+	PendingDiffSlots: 4096,
+	MinedCache:       16384,
+
+	StaleRatio: defaultStaleRatio,
 }
 
 // sanitize checks the provided user configurations and changes anything that's
@@ -68,6 +96,14 @@ func (config *Config) sanitize() Config {
 		log.Warn("Sanitizing invalid txpool journal time", "provided", conf.Rejournal, "updated", time.Second)
 		conf.Rejournal = time.Second
 	}
+	if _, err := journalSerializer(conf.JournalFormat); err != nil {
+		log.Warn("Sanitizing invalid txpool journal format", "provided", conf.JournalFormat, "updated", DefaultConfig.JournalFormat)
+		conf.JournalFormat = DefaultConfig.JournalFormat
+	}
+	if conf.Reannounce < time.Minute {
+		log.Warn("Sanitizing invalid txpool reannounce time", "provided", conf.Reannounce, "updated", time.Minute)
+		conf.Reannounce = time.Minute
+	}
 	if conf.PriceLimit < 1 {
 		log.Warn("Sanitizing invalid txpool price limit", "provided", conf.PriceLimit, "updated", DefaultConfig.PriceLimit)
 		conf.PriceLimit = DefaultConfig.PriceLimit
@@ -76,6 +112,10 @@ func (config *Config) sanitize() Config {
 		log.Warn("Sanitizing invalid txpool price bump", "provided", conf.PriceBump, "updated", DefaultConfig.PriceBump)
 		conf.PriceBump = DefaultConfig.PriceBump
 	}
+	if conf.MaxTxSize < txSlotSize {
+		log.Warn("Sanitizing invalid txpool max transaction size", "provided", conf.MaxTxSize, "updated", DefaultConfig.MaxTxSize)
+		conf.MaxTxSize = DefaultConfig.MaxTxSize
+	}
 	if conf.AccountSlots < 1 {
 		log.Warn("Sanitizing invalid txpool account slots", "provided", conf.AccountSlots, "updated", DefaultConfig.AccountSlots)
 		conf.AccountSlots = DefaultConfig.AccountSlots
@@ -96,6 +136,18 @@ func (config *Config) sanitize() Config {
 		log.Warn("Sanitizing invalid txpool lifetime", "provided", conf.Lifetime, "updated", DefaultConfig.Lifetime)
 		conf.Lifetime = DefaultConfig.Lifetime
 	}
+	if conf.PendingDiffSlots < 1 {
+		log.Warn("Sanitizing invalid txpool pending diff slots", "provided", conf.PendingDiffSlots, "updated", DefaultConfig.PendingDiffSlots)
+		conf.PendingDiffSlots = DefaultConfig.PendingDiffSlots
+	}
+	if conf.MinedCache < 1 {
+		log.Warn("Sanitizing invalid txpool mined cache", "provided", conf.MinedCache, "updated", DefaultConfig.MinedCache)
+		conf.MinedCache = DefaultConfig.MinedCache
+	}
+	if conf.StaleRatio < 1 {
+		log.Warn("Sanitizing invalid txpool stale ratio", "provided", conf.StaleRatio, "updated", DefaultConfig.StaleRatio)
+		conf.StaleRatio = DefaultConfig.StaleRatio
+	}
 	return conf
 }
 
@@ -112,6 +164,8 @@ type LegacyPool struct {
 	chain       types.BlockChain
 	gasTip      atomic.Pointer[big.Int]
 	txFeed      event.Feed
+	reannoFeed  event.Feed
+	replaceFeed event.Feed
 	scope       event.SubscriptionScope
 	mu          sync.RWMutex
 
@@ -122,12 +176,16 @@ type LegacyPool struct {
 	locals  *accountSet // Set of local transaction to exempt from eviction rules
 	journal *journal    // Journal of local transaction to back up to disk
 
-	pending map[common.Address]*List     // All currently processable transactions
-	queue   map[common.Address]*List     // Queued but non-processable transactions
-	beats   map[common.Address]time.Time // Last heartbeat from each known account
-	all     *Lookup                      // All transactions to allow lookups
-	priced  *PricedList                  // All transactions sorted by price
+	pending map[common.Address]*List          // All currently processable transactions
+	queue   map[common.Address]*List          // Queued but non-processable transactions
+	beats   map[common.Address]time.Time      // Last heartbeat from each known account
+	all     *Lookup                           // All transactions to allow lookups
+	priced  *PricedList                       // All transactions sorted by price
+	changes *pendingLog                       // Recent changes to the pending set
+	mined   *lru.Cache[common.Hash, struct{}] // Recently mined transactions, rejected if re-added
 
+	chainHeadCh     chan ChainHeadEvent
+	chainHeadSub    event.Subscription
 	reqResetCh      chan *txpoolResetRequest
 	reqPromoteCh    chan *accountSet
 	queueTxEventCh  chan *types.Transaction
@@ -135,8 +193,10 @@ type LegacyPool struct {
 	reorgShutdownCh chan struct{}  // requests shutdown of scheduleReorgLoop
 	wg              sync.WaitGroup // tracks loop, scheduleReorgLoop
 	initDoneCh      chan struct{}  // is closed once the pool is initialized (for tests)
+	closed          atomic.Bool    // set once the pool is shut down, rejecting new transactions
 
-	changesSinceReorg int // A counter for how many drops we've performed in-between reorg.
+	changesSinceReorg int             // A counter for how many drops we've performed in-between reorg.
+	replacements      []ReplacedEvent // Replacements to announce after the next reorg
 }
 
 type txpoolResetRequest struct {
@@ -158,6 +218,7 @@ func New(config Config, chain types.BlockChain) *LegacyPool {
 		queue:           make(map[common.Address]*List),
 		beats:           make(map[common.Address]time.Time),
 		all:             NewLookup(),
+		chainHeadCh:     make(chan ChainHeadEvent, chainHeadChanSize),
 		reqResetCh:      make(chan *txpoolResetRequest),
 		reqPromoteCh:    make(chan *accountSet),
 		queueTxEventCh:  make(chan *types.Transaction),
@@ -171,10 +232,17 @@ func New(config Config, chain types.BlockChain) *LegacyPool {
 		pool.locals.add(addr)
 	}
 	pool.priced = NewPricedList(pool.all)
+	pool.priced.staleRatio, pool.priced.lazy = int(config.StaleRatio), config.LazyPriceCleanup
+	pool.changes = newPendingLog(config.PendingDiffSlots)
+	pool.mined = lru.NewCache[common.Hash, struct{}](int(config.MinedCache))
 
 	if !config.NoLocals && config.Journal != "" {
-		pool.journal = newTxJournal(config.Journal)
+		serializer, _ := journalSerializer(config.JournalFormat) // Format sanitized above
+		pool.journal = newTxJournal(config.Journal, serializer)
 	}
+	// Subscribe to chain head events, the pool resets itself onto every new head
+	// once its main loop is running
+	pool.chainHeadSub = chain.SubscribeChainHeadEvent(pool.chainHeadCh)
 	return pool
 }
 
@@ -207,6 +275,18 @@ func (pool *LegacyPool) requestReset(oldHead types.Header, newHead types.Header)
 	}
 }
 
+// Sync blocks until a full reorg iteration scheduled after the call has finished,
+// so that all previously submitted transactions and resets took effect, e.g. a
+// freshly added transaction shows up in Pending once it's executable. It is
+// mostly useful for tests and RPC callers racing the background reorgs.
+func (pool *LegacyPool) Sync() error {
+	<-pool.requestPromoteExecutables(newAccountSet())
+	if pool.closed.Load() {
+		return ErrPoolClosed
+	}
+	return nil
+}
+
 // Init sets the gas price needed to keep a transaction in the pool and the chain
 // head to allow balance / nonce checks. The transaction journal will be loaded
 // from disk and filtered based on the provided starting settings. The internal
@@ -230,11 +310,25 @@ func (pool *LegacyPool) Init(gasTip *big.Int, head types.Header) error {
 			log.Warn("Failed to rotate transaction journal", "err", err)
 		}
 	}
+	pool.warmLocals()
+
 	pool.wg.Add(1)
 	go pool.loop()
 	return nil
 }
 
+// warmLocals seeds the virtual nonces of all local accounts from the current
+// state, so promoting them after startup doesn't cause a burst of state reads.
+// Nonces already advanced by replaying the journal are kept.
+func (pool *LegacyPool) warmLocals() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for addr := range pool.locals.accounts {
+		pool.pendingNonces.Seed(addr, pool.currentState.GetNonce(addr))
+	}
+}
+
 // SetGasTip updates the minimum gas tip required by the transaction pool for a
 // new transaction, and drops all transactions below this threshold.
 func (pool *LegacyPool) SetGasTip(tip *big.Int) {
@@ -246,16 +340,24 @@ func (pool *LegacyPool) SetGasTip(tip *big.Int) {
 
 	// If the min miner fee increased, remove transactions below the new threshold
 	if tip.Cmp(old) > 0 {
-		// pool.priced is sorted by GasFeeCap, so we have to iterate through pool.all instead
-		drop := pool.all.RemotesBelowTip(tip)
+		// pool.priced is sorted by GasFeeCap, so we have to iterate through pool.all instead.
+		// The tip caps are compared as is, as transactions priced out by the current
+		// base fee may become includable again once it drops.
+		drop := pool.all.RemotesBelowTip(tip, nil)
 		for _, tx := range drop {
-			pool.removeTx(tx.TxHash, false)
+			pool.removeTx(tx.Hash(), false)
 		}
 		pool.priced.Removed(len(drop))
 	}
 	log.Info("Legacy pool tip threshold updated", "tip", tip)
 }
 
+// CurrentBaseFee returns the base fee projected for the block following the
+// current head, or nil if the chain doesn't price blocks with a base fee.
+func (pool *LegacyPool) CurrentBaseFee() *big.Int {
+	return misc.CalcBaseFee(*pool.currentHead.Load())
+}
+
 // Nonce returns the next nonce of an account, with all transactions executable
 // by the pool already applied on top.
 func (pool *LegacyPool) Nonce(addr common.Address) uint64 {
@@ -265,23 +367,142 @@ func (pool *LegacyPool) Nonce(addr common.Address) uint64 {
 	return pool.pendingNonces.Get(addr)
 }
 
+// EstimateGas returns the gas needed to include the given transaction on top of
+// the current head state. A missing gas price or value is treated as zero, as is
+// common for estimation requests. Plain value transfers need exactly their
+// intrinsic gas. Contract creations and calls into code are executed on copies
+// of the head state, binary searching the lowest gas limit they succeed with,
+// which requires the chain to implement types.Executor.
+func (pool *LegacyPool) EstimateGas(tx *types.Transaction) (uint64, error) {
+	if tx.Type() != types.NormalTx {
+		return 0, fmt.Errorf("%w: gas estimation only supports normal transactions", ErrTxTypeNotSupported)
+	}
+	value, feeCap := new(big.Int), new(big.Int)
+	if tx.Value != nil {
+		value.Set(tx.Value)
+	}
+	if tx.GasPrice != nil && tx.GasPrice.GasFeeCap() != nil {
+		feeCap.Set(tx.GasPrice.GasFeeCap())
+	}
+	if value.Sign() < 0 {
+		return 0, ErrNegativeValue
+	}
+	if feeCap.Sign() < 0 {
+		return 0, ErrNegativeGasPrice
+	}
+	intrGas, err := tx.IntrinsicGas(pool.chainconfig)
+	if err != nil {
+		return 0, err
+	}
+	// If the caller capped the gas, it needs to cover the bare minimum
+	if tx.GasLimit != 0 && tx.GasLimit < intrGas {
+		return 0, fmt.Errorf("%w: needed %v, allowed %v", ErrIntrinsicGas, intrGas, tx.GasLimit)
+	}
+	pool.mu.RLock()
+	head := *pool.currentHead.Load()
+	statedb := pool.currentState.Copy()
+	pool.mu.RUnlock()
+
+	hi := head.GasLimit()
+	if tx.GasLimit != 0 && tx.GasLimit < hi {
+		hi = tx.GasLimit
+	}
+	if hi < intrGas {
+		return 0, ErrGasLimit
+	}
+	// Make sure the sender can afford the value, and cap the gas by what's left
+	balance := statedb.GetBalance(tx.From)
+	if balance.Cmp(value) < 0 {
+		return 0, fmt.Errorf("%w: balance %v, tx value %v", ErrInsufficientFunds, balance, value)
+	}
+	if feeCap.Sign() > 0 {
+		allowance := new(big.Int).Sub(balance, value)
+		allowance.Div(allowance, feeCap)
+		if allowance.IsUint64() && allowance.Uint64() < hi {
+			hi = allowance.Uint64()
+		}
+		if hi < intrGas {
+			cost := new(big.Int).Mul(feeCap, new(big.Int).SetUint64(intrGas))
+			return 0, fmt.Errorf("%w: balance %v, tx cost %v", ErrInsufficientFunds, balance, cost.Add(cost, value))
+		}
+	}
+	if (tx.To != common.Address{}) && len(statedb.GetCode(tx.To)) == 0 {
+		return intrGas, nil
+	}
+	executor, ok := pool.chain.(types.Executor)
+	if !ok {
+		return 0, ErrExecutionUnsupported
+	}
+	// Execute the transaction with the given gas limit on a fresh copy of the
+	// head state, reporting whether it succeeded
+	run := func(gas uint64) (bool, error) {
+		failed, err := executor.Execute(statedb.Copy(), head, tx, gas)
+		return !failed, err
+	}
+	// If the transaction fails with all the gas allowed, no limit will help
+	if ok, err := run(hi); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, fmt.Errorf("%w: allowance %v", ErrExecutionFailed, hi)
+	}
+	// Binary search the lowest limit the transaction succeeds with, which is at
+	// least the intrinsic gas
+	lo := intrGas - 1
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		ok, err := run(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi, nil
+}
+
 // Content retrieves the data content of the transaction pool, returning all the
 // pending as well as queued transactions, grouped by account and sorted by nonce.
 func (pool *LegacyPool) Content() (map[common.Address][]*types.Transaction, map[common.Address][]*types.Transaction) {
-	pool.mu.Lock()
-	defer pool.mu.Unlock()
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
 
 	pending := make(map[common.Address][]*types.Transaction, len(pool.pending))
 	for addr, list := range pool.pending {
-		pending[addr] = list.Flatten()
+		pending[addr] = list.Snapshot()
 	}
 	queued := make(map[common.Address][]*types.Transaction, len(pool.queue))
 	for addr, list := range pool.queue {
-		queued[addr] = list.Flatten()
+		queued[addr] = list.Snapshot()
 	}
 	return pending, queued
 }
 
+// PendingDiff retrieves the transactions that entered and left the pending set
+// since the given sequence number, together with the current sequence number to
+// pass in on the next poll. Transactions both added and removed in between are
+// left out.
+//
+// If the changes after since are no longer retained, that is more than
+// Config.PendingDiffSlots changes happened or since is ahead of the pool, the
+// entire pending set is returned as added with no removals, and the caller
+// should rebuild its mirror from scratch.
+func (pool *LegacyPool) PendingDiff(since uint64) (added, removed types.Transactions, seq uint64) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	if !pool.changes.covers(since) {
+		for _, list := range pool.pending {
+			added = append(added, list.Snapshot()...)
+		}
+		return added, nil, pool.changes.seq
+	}
+	added, removed = pool.changes.diff(since)
+	return added, removed, pool.changes.seq
+}
+
 // ContentFrom retrieves the data content of the transaction pool, returning the
 // pending as well as queued transactions of this address, grouped by nonce.
 func (pool *LegacyPool) ContentFrom(addr common.Address) ([]*types.Transaction, []*types.Transaction) {
@@ -290,11 +511,11 @@ func (pool *LegacyPool) ContentFrom(addr common.Address) ([]*types.Transaction,
 
 	var pending []*types.Transaction
 	if list, ok := pool.pending[addr]; ok {
-		pending = list.Flatten()
+		pending = list.Snapshot()
 	}
 	var queued []*types.Transaction
 	if list, ok := pool.queue[addr]; ok {
-		queued = list.Flatten()
+		queued = list.Snapshot()
 	}
 	return pending, queued
 }
@@ -302,29 +523,31 @@ func (pool *LegacyPool) ContentFrom(addr common.Address) ([]*types.Transaction,
 // Pending retrieves all currently processable transactions, grouped by origin
 // account and sorted by nonce. The returned transaction set is a copy and can be
 // freely modified by calling code.
-func (pool *LegacyPool) Pending() map[common.Address][]*types.Transaction {
-	pool.mu.Lock()
-	defer pool.mu.Unlock()
+//
+// The enforceTips parameter can be used to do an extra filtering on the pending
+// transactions and only return those whose **effective** tip is large enough in
+// the next pending execution environment.
+func (pool *LegacyPool) Pending(enforceTips bool) map[common.Address][]*types.Transaction {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
 
+	var (
+		minTip  = pool.gasTip.Load()
+		baseFee = pool.priced.urgent.baseFee
+	)
 	pending := make(map[common.Address][]*types.Transaction, len(pool.pending))
 	for addr, list := range pool.pending {
-		txs := list.Ready(pool.pendingNonces.nonces[addr], pool.currentState.GetBalance(addr))
-		for i, tx := range txs {
-			if tx.GasPrice.Price.Cmp(pool.gasTip.Load()) < 0 {
-				txs = txs[:i]
-				break
+		txs := list.Snapshot()
+
+		// If the miner requests tip enforcement, cap the lists now
+		if enforceTips && !pool.locals.contains(addr) {
+			for i, tx := range txs {
+				if tip, err := tx.EffectiveGasTip(baseFee); err != nil || tip.Cmp(minTip) < 0 {
+					txs = txs[:i]
+					break
+				}
 			}
 		}
-		// // If the miner requests tip enforcement, cap the lists now
-		// if enforceTips && !pool.locals.contains(addr) {
-		// 	for i, tx := range txs {
-		// 		if tx.EffectiveGasTipIntCmp(pool.gasTip.Load(), pool.priced.urgent.baseFee) < 0 {
-		// 			txs = txs[:i]
-		// 			break
-		// 		}
-		// 	}
-		// }
-
 		if len(txs) > 0 {
 			pending[addr] = txs
 		}
@@ -332,33 +555,68 @@ func (pool *LegacyPool) Pending() map[common.Address][]*types.Transaction {
 	return pending
 }
 
-// Status returns the status (unknown/pending/queued) of a batch of transactions
-// identified by their hashes.
-func (pool *LegacyPool) Status(hash common.Hash) TxStatus {
-	tx := pool.get(hash)
-	if tx == nil {
-		return TxStatusUnknown
+// PackBlock selects a block's worth of pending transactions for a sequencer. It
+// greedily takes the transaction with the highest effective tip under baseFee
+// next, keeping the nonce order within every account, until gasLimit can't fit
+// another transaction. Accounts whose next transaction exceeds the remaining gas
+// or can't pay the base fee are skipped along with all their later transactions.
+//
+// The pool is left untouched, the transactions aren't removed from it.
+func (pool *LegacyPool) PackBlock(gasLimit uint64, baseFee *big.Int) types.Transactions {
+	var (
+		txs    = newPacker(pool.Pending(false), baseFee)
+		minGas = pool.chainconfig.GasSchedule().TxGas
+		packed types.Transactions
+	)
+	for gasLimit >= minGas {
+		tx := txs.peek()
+		if tx == nil {
+			break
+		}
+		if tx.GasLimit > gasLimit {
+			txs.pop()
+			continue
+		}
+		gasLimit -= tx.GasLimit
+		packed = append(packed, tx)
+		txs.shift()
 	}
-	from := tx.From
+	return packed
+}
+
+// Status returns the status (unknown/queued/pending/included) of a batch of
+// transactions identified by their hashes, all retrieved under a single lock.
+// Transactions recently removed from the pool as mined are reported included.
+func (pool *LegacyPool) Status(hashes []common.Hash) []TxStatus {
+	status := make([]TxStatus, len(hashes))
 
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
 
-	if txList := pool.pending[from]; txList != nil && txList.txs.items[tx.Nonce] != nil {
-		return TxStatusPending
-	} else if txList := pool.queue[from]; txList != nil && txList.txs.items[tx.Nonce] != nil {
-		return TxStatusQueued
+	for i, hash := range hashes {
+		tx := pool.all.Get(hash)
+		if tx == nil {
+			if pool.mined.Contains(hash) {
+				status[i] = TxStatusIncluded
+			}
+			continue
+		}
+		from := tx.From
+		if txList := pool.pending[from]; txList != nil && txList.txs.items[tx.Nonce] != nil {
+			status[i] = TxStatusPending
+		} else if txList := pool.queue[from]; txList != nil && txList.txs.items[tx.Nonce] != nil {
+			status[i] = TxStatusQueued
+		}
 	}
-	return TxStatusUnknown
+	return status
 }
 
 // Get returns a transaction if it is contained in the pool and nil otherwise.
+//
+// The lookup is served by the internally synchronised Lookup set, so it does not
+// contend on the pool mutex.
 func (pool *LegacyPool) Get(hash common.Hash) *types.Transaction {
-	tx := pool.get(hash)
-	if tx == nil {
-		return nil
-	}
-	return tx
+	return pool.get(hash)
 }
 
 // get returns a transaction if it is contained in the pool and nil otherwise.
@@ -369,7 +627,7 @@ func (pool *LegacyPool) get(hash common.Hash) *types.Transaction {
 // Has returns an indicator whether txpool has a transaction cached with the
 // given hash.
 func (pool *LegacyPool) Has(hash common.Hash) bool {
-	return pool.all.Get(hash) != nil
+	return pool.get(hash) != nil
 }
 
 // loop is the transaction pool's main event loop, waiting for and reacting to
@@ -381,14 +639,19 @@ func (pool *LegacyPool) loop() {
 	var (
 		prevPending, prevQueued, prevStales int
 
+		// Track the head the pool was last reset to, so new heads can be diffed
+		head = *pool.currentHead.Load()
+
 		// Start the stats reporting and transaction eviction tickers
 		report  = time.NewTicker(statsReportInterval)
 		evict   = time.NewTicker(evictionInterval)
 		journal = time.NewTicker(pool.config.Rejournal)
+		reanno  = time.NewTicker(pool.config.Reannounce)
 	)
 	defer report.Stop()
 	defer evict.Stop()
 	defer journal.Stop()
+	defer reanno.Stop()
 
 	// Notify tests that the init phase is done
 	close(pool.initDoneCh)
@@ -398,6 +661,17 @@ func (pool *LegacyPool) loop() {
 		case <-pool.reorgShutdownCh:
 			return
 
+		// Handle chain head events, resetting the pool onto the new head
+		case ev := <-pool.chainHeadCh:
+			if ev.Block != nil {
+				pool.requestReset(head, ev.Block.Header())
+				head = ev.Block.Header()
+			}
+
+		// Be unsubscribed due to system stopped
+		case <-pool.chainHeadSub.Err():
+			return
+
 		// Handle stats reporting ticks
 		case <-report.C:
 			pool.mu.RLock()
@@ -422,11 +696,24 @@ func (pool *LegacyPool) loop() {
 				if time.Since(pool.beats[addr]) > pool.config.Lifetime {
 					list := pool.queue[addr].Flatten()
 					for _, tx := range list {
-						pool.removeTx(tx.TxHash, true)
+						pool.removeTx(tx.Hash(), true)
 					}
 					queuedEvictionMeter.Mark(int64(len(list)))
 				}
 			}
+			// Forget local accounts idle for a whole lifetime. The heartbeat is
+			// started when one is first seen without transactions.
+			for _, addr := range pool.locals.flatten() {
+				if pool.pending[addr] != nil || pool.queue[addr] != nil || pool.isConfiguredLocal(addr) {
+					continue
+				}
+				if beat, ok := pool.beats[addr]; !ok {
+					pool.beats[addr] = time.Now()
+				} else if time.Since(beat) > pool.config.Lifetime {
+					pool.forgetLocal(addr)
+					delete(pool.beats, addr)
+				}
+			}
 			pool.mu.Unlock()
 
 		// Handle local transaction journal rotation
@@ -438,8 +725,30 @@ func (pool *LegacyPool) loop() {
 				}
 				pool.mu.Unlock()
 			}
+
+		// Handle local transaction reannouncement
+		case <-reanno.C:
+			pool.reannounce()
+		}
+	}
+}
+
+// reannounce publishes all local pending transactions on the reannounce feed so
+// they can be re-broadcast and aren't forgotten by the peers.
+func (pool *LegacyPool) reannounce() {
+	pool.mu.RLock()
+	var txs types.Transactions
+	for addr := range pool.locals.accounts {
+		if pending := pool.pending[addr]; pending != nil {
+			txs = append(txs, pending.Snapshot()...)
 		}
 	}
+	pool.mu.RUnlock()
+
+	if len(txs) > 0 {
+		log.Debug("Reannouncing local transactions", "count", len(txs))
+		pool.reannoFeed.Send(ReannoTxsEvent{Txs: txs})
+	}
 }
 
 // promoteExecutables moves transactions that have become processable from the
@@ -459,24 +768,36 @@ func (pool *LegacyPool) promoteExecutables(accounts []common.Address) []*types.T
 		// Drop all transactions that are deemed too old (low nonce)
 		forwards := list.Forward(pool.currentState.GetNonce(addr))
 		for _, tx := range forwards {
-			hash := tx.TxHash
+			hash := tx.Hash()
 			pool.all.Remove(hash)
+			pool.mined.Add(hash, struct{}{})
 		}
 		log.Trace("Removed old queued transactions", "count", len(forwards))
 		// Drop all transactions that are too costly (low balance or out of gas)
 		drops, _ := list.Filter(pool.currentState.GetBalance(addr), gasLimit)
 		for _, tx := range drops {
-			hash := tx.TxHash
+			hash := tx.Hash()
 			pool.all.Remove(hash)
 		}
 		log.Trace("Removed unpayable queued transactions", "count", len(drops))
 		queuedNofundsMeter.Mark(int64(len(drops)))
 
-		// Gather all executable transactions and promote them
-		readies := list.Ready(pool.pendingNonces.Get(addr), pool.currentState.GetBalance(addr))
+		// Gather all executable transactions and promote them, making sure the
+		// account's pending set as a whole stays within its balance. Ready caps
+		// the summed cost of the promoted transactions only, so the cost of the
+		// already pending ones is taken off the balance first.
+		allowance := pool.currentState.GetBalance(addr)
+		if pending := pool.pending[addr]; pending != nil {
+			if last := pending.LastElement(); last != nil {
+				allowance = new(big.Int).Sub(allowance, pending.GetCost(last.Nonce))
+			}
+		}
+		readies := list.Ready(pool.pendingNonces.Get(addr), allowance)
 		for _, tx := range readies {
-			hash := tx.TxHash
+			hash := tx.Hash()
 			if pool.promoteTx(addr, hash, tx) {
+				log.Debug("Promoted queued transaction", "hash", hash, "from", addr, "nonce", tx.Nonce)
+				queuedPromoteMeter.Mark(1)
 				promoted = append(promoted, tx)
 			}
 		}
@@ -488,7 +809,7 @@ func (pool *LegacyPool) promoteExecutables(accounts []common.Address) []*types.T
 		if !pool.locals.contains(addr) {
 			caps = list.Cap(int(pool.config.AccountQueue))
 			for _, tx := range caps {
-				hash := tx.TxHash
+				hash := tx.Hash()
 				pool.all.Remove(hash)
 				log.Trace("Removed cap-exceeding queued transaction", "hash", hash)
 			}
@@ -530,13 +851,14 @@ func (pool *LegacyPool) promoteTx(addr common.Address, hash common.Hash, tx *typ
 	}
 	// Otherwise discard any previous transaction and mark this
 	if old != nil {
-		pool.all.Remove(old.TxHash)
-		pool.priced.Removed(1)
+		pool.replaceTx(old, tx)
+		pool.changes.removed(old)
 		pendingReplaceMeter.Mark(1)
 	} else {
 		// Nothing was replaced, bump the pending counter
 		pendingGauge.Inc(1)
 	}
+	pool.changes.added(tx)
 	// Set the potentially new pending nonce and notify any subsystems of the new tx
 	pool.pendingNonces.Set(addr, tx.Nonce+1)
 
@@ -563,26 +885,31 @@ func (pool *LegacyPool) demoteUnexecutables() {
 		// Drop all transactions that are deemed too old (low nonce)
 		olds := list.Forward(nonce)
 		for _, tx := range olds {
-			hash := tx.TxHash
+			hash := tx.Hash()
 			pool.all.Remove(hash)
+			pool.mined.Add(hash, struct{}{})
 			log.Trace("Removed old pending transaction", "hash", hash)
 		}
 		// Drop all transactions that are too costly (low balance or out of gas), and queue any invalids back for later
 		drops, invalids := list.Filter(pool.currentState.GetBalance(addr), gasLimit)
 		for _, tx := range drops {
-			hash := tx.TxHash
+			hash := tx.Hash()
 			log.Trace("Removed unpayable pending transaction", "hash", hash)
 			pool.all.Remove(hash)
 		}
 		pendingNofundsMeter.Mark(int64(len(drops)))
 
 		for _, tx := range invalids {
-			hash := tx.TxHash
-			log.Trace("Demoting pending transaction", "hash", hash)
+			hash := tx.Hash()
+			log.Debug("Demoting pending transaction", "hash", hash, "from", addr, "nonce", tx.Nonce)
 
 			// Internal shuffle shouldn't touch the lookup set.
 			pool.enqueueTx(hash, tx, false, false)
 		}
+		pool.changes.removed(olds...)
+		pool.changes.removed(drops...)
+		pool.changes.removed(invalids...)
+		pendingDemoteMeter.Mark(int64(len(invalids)))
 		pendingGauge.Dec(int64(len(olds) + len(drops) + len(invalids)))
 		if pool.locals.contains(addr) {
 			localGauge.Dec(int64(len(olds) + len(drops) + len(invalids)))
@@ -591,32 +918,78 @@ func (pool *LegacyPool) demoteUnexecutables() {
 		if list.Len() > 0 && list.txs.Get(nonce) == nil {
 			gapped := list.Cap(0)
 			for _, tx := range gapped {
-				hash := tx.TxHash
-				log.Error("Demoting invalidated transaction", "hash", hash)
+				hash := tx.Hash()
+				log.Error("Demoting invalidated transaction", "hash", hash, "from", addr, "nonce", tx.Nonce)
 
 				// Internal shuffle shouldn't touch the lookup set.
 				pool.enqueueTx(hash, tx, false, false)
 			}
+			pool.changes.removed(gapped...)
+			pendingDemoteMeter.Mark(int64(len(gapped)))
 			pendingGauge.Dec(int64(len(gapped)))
 		}
-		// Delete the entire pending entry if it became empty.
+		// Lower the virtual nonce to the first gap, as nothing beyond it remained
+		// executable. Delete the entire pending entry if it became empty.
 		if list.Empty() {
+			pool.pendingNonces.SetIfLower(addr, nonce)
 			delete(pool.pending, addr)
+
+			// Promotions bump the heartbeat too, drop it if nothing else is queued
+			if pool.queue[addr] == nil {
+				delete(pool.beats, addr)
+			}
+		} else {
+			pool.pendingNonces.SetIfLower(addr, list.LastElement().Nonce+1)
 		}
 	}
 }
 
+// isLocal reports whether the sender of tx is tracked as a local account.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *LegacyPool) isLocal(tx *types.Transaction) bool {
+	return pool.locals.containsTx(tx)
+}
+
+// isConfiguredLocal reports whether addr is one of the local accounts set in the
+// pool config, which are never forgotten.
+func (pool *LegacyPool) isConfiguredLocal(addr common.Address) bool {
+	for _, local := range pool.config.Locals {
+		if local == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetLocal drops an account from the local set, so the set does not grow
+// unbounded. It is called for accounts without transactions left in the pool,
+// either explicitly removed or idle for a whole lifetime, but never on mining:
+// their transactions may return in a reorg and must be reinjected as locals.
+// Accounts configured as locals are retained.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *LegacyPool) forgetLocal(addr common.Address) {
+	if !pool.locals.contains(addr) || pool.isConfiguredLocal(addr) {
+		return
+	}
+	log.Debug("Forgetting local account", "address", addr)
+	pool.locals.remove(addr)
+}
+
 // truncatePending removes transactions from the pending queue if the pool is above the
 // pending limit. The algorithm tries to reduce transaction counts by an approximately
-// equal number for all for accounts with many pending transactions.
-func (pool *LegacyPool) truncatePending() {
+// equal number for all for accounts with many pending transactions. The dropped
+// transactions are returned.
+func (pool *LegacyPool) truncatePending() types.Transactions {
 	pending := uint64(0)
 	for _, list := range pool.pending {
 		pending += uint64(list.Len())
 	}
 	if pending <= pool.config.GlobalSlots {
-		return
+		return nil
 	}
+	var dropped types.Transactions
 
 	pendingBeforeCap := pending
 	// Assemble a spam order to penalize large transactors first
@@ -647,9 +1020,10 @@ func (pool *LegacyPool) truncatePending() {
 					list := pool.pending[offenders[i]]
 
 					caps := list.Cap(list.Len() - 1) // means a kind of pop
+					dropped = append(dropped, caps...)
 					for _, tx := range caps {
 						// Drop the transaction from the global pools too
-						hash := tx.TxHash
+						hash := tx.Hash()
 						pool.all.Remove(hash)
 
 						// Update the account nonce to the dropped transaction
@@ -657,6 +1031,7 @@ func (pool *LegacyPool) truncatePending() {
 						log.Trace("Removed fairness-exceeding pending transaction", "hash", hash)
 					}
 					pool.priced.Removed(len(caps))
+					pool.changes.removed(caps...)
 					pendingGauge.Dec(int64(len(caps)))
 					if pool.locals.contains(offenders[i]) {
 						localGauge.Dec(int64(len(caps)))
@@ -674,9 +1049,10 @@ func (pool *LegacyPool) truncatePending() {
 				list := pool.pending[addr]
 
 				caps := list.Cap(list.Len() - 1)
+				dropped = append(dropped, caps...)
 				for _, tx := range caps {
 					// Drop the transaction from the global pools too
-					hash := tx.TxHash
+					hash := tx.Hash()
 					pool.all.Remove(hash)
 
 					// Update the account nonce to the dropped transaction
@@ -684,6 +1060,7 @@ func (pool *LegacyPool) truncatePending() {
 					log.Trace("Removed fairness-exceeding pending transaction", "hash", hash)
 				}
 				pool.priced.Removed(len(caps))
+				pool.changes.removed(caps...)
 				pendingGauge.Dec(int64(len(caps)))
 				if pool.locals.contains(addr) {
 					localGauge.Dec(int64(len(caps)))
@@ -693,6 +1070,7 @@ func (pool *LegacyPool) truncatePending() {
 		}
 	}
 	pendingRateLimitMeter.Mark(int64(pendingBeforeCap - pending))
+	return dropped
 }
 
 // addressByHeartbeat is an account address tagged with its last activity timestamp.
@@ -708,14 +1086,16 @@ func (a addressesByHeartbeat) Less(i, j int) bool { return a[i].heartbeat.Before
 func (a addressesByHeartbeat) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
 // truncateQueue drops the oldest transactions in the queue if the pool is above the global queue limit.
-func (pool *LegacyPool) truncateQueue() {
+// The dropped transactions are returned.
+func (pool *LegacyPool) truncateQueue() types.Transactions {
 	queued := uint64(0)
 	for _, list := range pool.queue {
 		queued += uint64(list.Len())
 	}
 	if queued <= pool.config.GlobalQueue {
-		return
+		return nil
 	}
+	var dropped types.Transactions
 
 	// Sort all accounts with queued transactions by heartbeat
 	addresses := make(addressesByHeartbeat, 0, len(pool.queue))
@@ -737,7 +1117,8 @@ func (pool *LegacyPool) truncateQueue() {
 		// Drop all transactions if they are less than the overflow
 		if size := uint64(list.Len()); size <= drop {
 			for _, tx := range list.Flatten() {
-				pool.removeTx(tx.TxHash, true)
+				pool.removeTx(tx.Hash(), true)
+				dropped = append(dropped, tx)
 			}
 			drop -= size
 			queuedRateLimitMeter.Mark(int64(size))
@@ -746,11 +1127,13 @@ func (pool *LegacyPool) truncateQueue() {
 		// Otherwise drop only last few transactions
 		txs := list.Flatten()
 		for i := len(txs) - 1; i >= 0 && drop > 0; i-- {
-			pool.removeTx(txs[i].TxHash, true)
+			pool.removeTx(txs[i].Hash(), true)
+			dropped = append(dropped, txs[i])
 			drop--
 			queuedRateLimitMeter.Mark(1)
 		}
 	}
+	return dropped
 }
 
 // runReorg runs reset and promoteExecutables on behalf of scheduleReorgLoop.
@@ -793,13 +1176,10 @@ func (pool *LegacyPool) runReorg(done chan struct{}, reset *txpoolResetRequest,
 	// because of another transaction (e.g. higher gas price).
 	if reset != nil {
 		pool.demoteUnexecutables()
+		// A head without a base fee is followed by legacy pricing, clearing the
+		// base fee projected from an earlier head
 		if reset.newHead != nil {
-			// if pool.chainconfig.IsLondon(new(big.Int).Add(reset.newHead.Number, big.NewInt(1))) {
-			// 	pendingBaseFee := misc.CalcBaseFee(pool.chainconfig, reset.newHead)
-			// 	pool.priced.SetBaseFee(pendingBaseFee)
-			// } else {
-			pool.priced.Reheap()
-			// }
+			pool.priced.SetBaseFee(misc.CalcBaseFee(reset.newHead))
 		}
 		// Update all accounts to the latest known pending nonce
 		nonces := make(map[common.Address]uint64, len(pool.pending))
@@ -815,8 +1195,15 @@ func (pool *LegacyPool) runReorg(done chan struct{}, reset *txpoolResetRequest,
 
 	dropBetweenReorgHistogram.Update(int64(pool.changesSinceReorg))
 	pool.changesSinceReorg = 0 // Reset change counter
+	replacements := pool.replacements
+	pool.replacements = nil
 	pool.mu.Unlock()
 
+	// Notify subsystems of superseded transactions
+	for _, ev := range replacements {
+		pool.replaceFeed.Send(ev)
+	}
+
 	// Notify subsystems for newly added transactions
 	for _, tx := range promoted {
 		addr := tx.From
@@ -912,6 +1299,17 @@ func (pool *LegacyPool) Locals() []common.Address {
 	return pool.locals.flatten()
 }
 
+// LocalTransactions retrieves all currently known local transactions, pending and
+// queued, grouped by origin account and sorted by nonce. This is the set the
+// journal is rotated to. The returned transaction set is a copy and can be freely
+// modified by calling code.
+func (pool *LegacyPool) LocalTransactions() map[common.Address]types.Transactions {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.local()
+}
+
 // local retrieves all currently known local transactions, grouped by origin
 // account and sorted by nonce. The returned transaction set is a copy and can be
 // freely modified by calling code.
@@ -919,10 +1317,10 @@ func (pool *LegacyPool) local() map[common.Address]types.Transactions {
 	txs := make(map[common.Address]types.Transactions)
 	for addr := range pool.locals.accounts {
 		if pending := pool.pending[addr]; pending != nil {
-			txs[addr] = append(txs[addr], pending.Flatten()...)
+			txs[addr] = append(txs[addr], pending.Snapshot()...)
 		}
 		if queued := pool.queue[addr]; queued != nil {
-			txs[addr] = append(txs[addr], queued.Flatten()...)
+			txs[addr] = append(txs[addr], queued.Snapshot()...)
 		}
 	}
 	return txs
@@ -991,7 +1389,7 @@ func (pool *LegacyPool) reset(oldHead, newHead types.Header) {
 					// the firing of newhead-event and _now_: most likely a
 					// reorg caused by sync-reversion or explicit sethead back to an
 					// earlier block.
-					log.Warn("New head missing in txpool reset", "number", newHead.Number, "hash", newHead.Hash())
+					log.Warn("New head missing in txpool reset", "number", newHead.Number(), "hash", newHead.Hash())
 					return
 				}
 				var discarded, included types.Transactions
@@ -999,7 +1397,7 @@ func (pool *LegacyPool) reset(oldHead, newHead types.Header) {
 				for rem.NumberU64() > add.NumberU64() {
 					discarded = append(discarded, rem.Transactions()...)
 					if rem = pool.chain.GetBlock(rem.ParentHash(), rem.NumberU64()-1); rem == nil {
-						log.Error("Unrooted old chain seen by tx pool", "block", oldHead.Number, "hash", oldHead.Hash())
+						log.Error("Unrooted old chain seen by tx pool", "block", oldHead.Number(), "hash", oldHead.Hash())
 						return
 					}
 				}
@@ -1008,7 +1406,7 @@ func (pool *LegacyPool) reset(oldHead, newHead types.Header) {
 				for add.NumberU64() > rem.NumberU64() {
 					included = append(included, add.Transactions()...)
 					if add = pool.chain.GetBlock(add.ParentHash(), add.NumberU64()-1); add == nil {
-						log.Error("Unrooted new chain seen by tx pool", "block", newHead.Number, "hash", newHead.Hash())
+						log.Error("Unrooted new chain seen by tx pool", "block", newHead.Number(), "hash", newHead.Hash())
 						return
 					}
 				}
@@ -1020,12 +1418,12 @@ func (pool *LegacyPool) reset(oldHead, newHead types.Header) {
 				for rem.Hash() != add.Hash() {
 					discarded = append(discarded, rem.Transactions()...)
 					if rem = pool.chain.GetBlock(rem.ParentHash(), rem.NumberU64()-1); rem == nil {
-						log.Error("Unrooted old chain seen by tx pool", "block", oldHead.Number, "hash", oldHead.Hash())
+						log.Error("Unrooted old chain seen by tx pool", "block", oldHead.Number(), "hash", oldHead.Hash())
 						return
 					}
 					included = append(included, add.Transactions()...)
 					if add = pool.chain.GetBlock(add.ParentHash(), add.NumberU64()-1); add == nil {
-						log.Error("Unrooted new chain seen by tx pool", "block", newHead.Number, "hash", newHead.Hash())
+						log.Error("Unrooted new chain seen by tx pool", "block", newHead.Number(), "hash", newHead.Hash())
 						return
 					}
 				}
@@ -1047,11 +1445,46 @@ func (pool *LegacyPool) reset(oldHead, newHead types.Header) {
 	pool.pendingNonces = NewNoncer(statedb)
 
 	// Inject any transactions discarded due to reorgs
-	log.Debug("Reinjecting stale transactions", "count", len(reinject))
-	// Ethereum needs to recover the from or some other fields
-	// I am not sure if we need that
-	// core.SenderCacher.Recover(pool.signer, reinject)
-	pool.addTxsLocked(reinject, false)
+	pool.reinject(reinject)
+}
+
+// reinject re-adds the transactions of blocks reorged out of the canonical chain.
+// They are grouped by account and added in nonce order, keeping the local flag of
+// senders tracked in pool.locals, as the transactions themselves left the pool
+// when their block was applied. Transactions still in the pool are skipped. The
+// executable ones are promoted by the reorg run which requested the reset.
+//
+// The caller must hold pool.mu.
+func (pool *LegacyPool) reinject(txs types.Transactions) {
+	if len(txs) == 0 {
+		return
+	}
+	log.Debug("Reinjecting stale transactions", "count", len(txs))
+
+	byAccount := make(map[common.Address]types.Transactions)
+	for _, tx := range txs {
+		// Reorged out transactions are no longer mined, accept them again
+		pool.mined.Remove(tx.Hash())
+		if pool.all.Get(tx.Hash()) != nil {
+			continue
+		}
+		byAccount[tx.From] = append(byAccount[tx.From], tx)
+	}
+	dropped := 0
+	for _, list := range byAccount {
+		sort.Sort(types.TxByNonce(list))
+
+		errs, _ := pool.addTxsLocked(list, pool.isLocal(list[0]))
+		for i, err := range errs {
+			if err != nil {
+				log.Trace("Failed to reinject transaction", "hash", list[i].Hash(), "err", err)
+				dropped++
+			}
+		}
+	}
+	if dropped > 0 {
+		log.Debug("Dropped stale transactions", "count", dropped)
+	}
 }
 
 // Add enqueues a batch of transactions into the pool if they are valid. Depending
@@ -1062,7 +1495,30 @@ func (pool *LegacyPool) reset(oldHead, newHead types.Header) {
 func (pool *LegacyPool) Add(txs types.Transactions, local bool, sync bool) []error {
 	unwrapped := make([]*types.Transaction, len(txs))
 	copy(unwrapped, txs)
-	return pool.addTxs(unwrapped, local, sync)
+	return pool.addTxsCtx(context.Background(), unwrapped, local, sync)
+}
+
+// AddCtx is like Add, waiting for the pool reorganization, but gives up as soon
+// as the context is cancelled. Transactions not yet inserted when that happens,
+// i.e. the pool lock could not be acquired in time, are rejected with the error
+// of the context. Transactions already inserted are not rolled back, only the
+// wait for their promotion is abandoned.
+func (pool *LegacyPool) AddCtx(ctx context.Context, txs []*types.Transaction, local bool) []error {
+	return pool.addTxsCtx(ctx, txs, local, true)
+}
+
+// AddLocals enqueues a batch of transactions into the pool if they are valid, marking the
+// senders as local ones. Local transactions bypass the pricing constraints and eviction
+// rules, are journaled to disk and also promote any remote transactions of the senders
+// to locals. The errors are returned in the order of the transactions.
+func (pool *LegacyPool) AddLocals(txs []*types.Transaction) []error {
+	return pool.addLocals(txs)
+}
+
+// AddLocal enqueues a single local transaction into the pool if it is valid. This is
+// a convenience wrapper around AddLocals.
+func (pool *LegacyPool) AddLocal(tx *types.Transaction) error {
+	return pool.addLocal(tx)
 }
 
 // addLocals enqueues a batch of transactions into the pool if they are valid, marking the
@@ -1109,14 +1565,24 @@ func (pool *LegacyPool) addRemoteSync(tx *types.Transaction) error {
 
 // addTxs attempts to queue a batch of transactions if they are valid.
 func (pool *LegacyPool) addTxs(txs types.Transactions, local, sync bool) []error {
+	return pool.addTxsCtx(context.Background(), txs, local, sync)
+}
+
+// addTxsCtx attempts to queue a batch of transactions if they are valid, bailing
+// out of waiting for the pool lock or the reorganization once ctx is cancelled.
+func (pool *LegacyPool) addTxsCtx(ctx context.Context, txs types.Transactions, local, sync bool) []error {
 	// Filter out known ones without obtaining the pool lock or recovering signatures
 	var (
 		errs = make([]error, len(txs))
 		news = make([]*types.Transaction, 0, len(txs))
 	)
 	for i, tx := range txs {
-		// If the transaction is known, pre-set the error slot
-		if pool.all.Get(tx.TxHash) != nil {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		// If the transaction is known or was mined recently, pre-set the error slot
+		if pool.all.Get(tx.Hash()) != nil || pool.mined.Contains(tx.Hash()) {
 			errs[i] = ErrAlreadyKnown
 			knownTxMeter.Mark(1)
 			continue
@@ -1133,11 +1599,18 @@ func (pool *LegacyPool) addTxs(txs types.Transactions, local, sync bool) []error
 		news = append(news, tx)
 	}
 	if len(news) == 0 {
-		return errs
+		return wrapAddErrors(txs, errs)
 	}
 
 	// Process all the new transaction and merge any errors into the original slice
-	pool.mu.Lock()
+	if err := pool.lockCtx(ctx); err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return wrapAddErrors(txs, errs)
+	}
 	newErrs, dirtyAddrs := pool.addTxsLocked(news, local)
 	pool.mu.Unlock()
 
@@ -1152,7 +1625,51 @@ func (pool *LegacyPool) addTxs(txs types.Transactions, local, sync bool) []error
 	// Reorg the pool internals if needed and return
 	done := pool.requestPromoteExecutables(dirtyAddrs)
 	if sync {
-		<-done
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+	return wrapAddErrors(txs, errs)
+}
+
+// lockCtx acquires the pool lock, unless ctx is cancelled first. On cancellation
+// the lock is released on behalf of the caller as soon as it is obtained.
+func (pool *LegacyPool) lockCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		pool.mu.Lock()
+		return nil
+	}
+	if pool.mu.TryLock() {
+		return nil
+	}
+	locked := make(chan struct{})
+	go func() {
+		pool.mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			pool.mu.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// wrapAddErrors annotates the errors of a batch add with the transactions they
+// belong to.
+func wrapAddErrors(txs types.Transactions, errs []error) []error {
+	for i, err := range errs {
+		if err != nil {
+			errs[i] = &AddError{Hash: txs[i].Hash(), From: txs[i].From, Err: err}
+		}
 	}
 	return errs
 }
@@ -1173,6 +1690,12 @@ func (pool *LegacyPool) requestPromoteExecutables(set *accountSet) chan struct{}
 func (pool *LegacyPool) addTxsLocked(txs []*types.Transaction, local bool) ([]error, *accountSet) {
 	dirty := newAccountSet()
 	errs := make([]error, len(txs))
+	if pool.closed.Load() {
+		for i := range errs {
+			errs[i] = ErrPoolClosed
+		}
+		return errs, dirty
+	}
 	for i, tx := range txs {
 		replaced, err := pool.add(tx, local)
 		errs[i] = err
@@ -1190,8 +1713,14 @@ func (pool *LegacyPool) addTxsLocked(txs []*types.Transaction, local bool) ([]er
 // and does not require the pool mutex to be held.
 func (pool *LegacyPool) validateTxBasics(tx *types.Transaction, local bool) error {
 	opts := &ValidationOptions{
-		MaxSize: txMaxSize,
-		MinTip:  pool.gasTip.Load(),
+		MaxSize:     pool.config.MaxTxSize,
+		MinTip:      pool.gasTip.Load(),
+		MaxGasPrice: pool.config.MaxGasPrice,
+		AllowHighS:  pool.config.AllowHighS,
+		Config:      pool.chainconfig,
+	}
+	if pool.chainconfig != nil {
+		opts.ChainID = pool.chainconfig.ChainID
 	}
 	if local {
 		opts.MinTip = new(big.Int)
@@ -1206,13 +1735,11 @@ func (pool *LegacyPool) validateTxBasics(tx *types.Transaction, local bool) erro
 // rules and adheres to some heuristic limits of the local node (price and size).
 func (pool *LegacyPool) validateTx(tx *types.Transaction, local bool) error {
 	opts := &ValidationOptionsWithState{
-		State: pool.currentState,
+		State: state.NewReadOnlyStateDB(pool.currentState),
 
-		FirstNonceGap: nil, // Pool allows arbitrary arrival order, don't invalidate nonce gaps
 		ExistingExpenditure: func(addr common.Address, nonce uint64) *big.Int {
 			if list := pool.pending[addr]; list != nil {
-				cost := list.GetCost(nonce)
-				return cost
+				return list.SubTotalCost(nonce)
 			}
 			return new(big.Int)
 		},
@@ -1225,12 +1752,75 @@ func (pool *LegacyPool) validateTx(tx *types.Transaction, local bool) error {
 			return nil
 		},
 	}
+	// Transactions replacing a pending one go straight into the pending list, which
+	// must not have gaps. The queue accepts arbitrary arrival order, its gapped
+	// transactions are only promoted once the gap is filled.
+	if list := pool.pending[tx.From]; list != nil && list.Overlaps(tx) {
+		opts.FirstNonceGap = pool.firstNonceGap
+	}
 	if err := ValidateTransactionWithState(tx, opts); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Cancel builds an unsigned cancellation of the transaction sent by addr with the
+// given nonce: a zero value self-send paying newGasPrice. Once signed by the
+// caller and submitted, it replaces the original through the regular replacement
+// path. ErrNothingToCancel is returned if the account has no such transaction
+// in the pool, and ErrReplaceUnderpriced if newGasPrice doesn't clear the price
+// bump required for replacements.
+func (pool *LegacyPool) Cancel(addr common.Address, nonce uint64, newGasPrice *gadget.GasPrice) (*types.Transaction, error) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	var old *types.Transaction
+	if list := pool.pending[addr]; list != nil {
+		old = list.txs.Get(nonce)
+	}
+	if list := pool.queue[addr]; old == nil && list != nil {
+		old = list.txs.Get(nonce)
+	}
+	if old == nil {
+		return nil, ErrNothingToCancel
+	}
+	if !bumpsPrice(old.GasPrice, newGasPrice, pool.config.PriceBump) {
+		return nil, ErrReplaceUnderpriced
+	}
+	return &types.Transaction{
+		TxPreface: types.TxPreface{
+			From:     addr,
+			Nonce:    nonce,
+			GasLimit: pool.chainconfig.GasSchedule().TxGas,
+			GasPrice: newGasPrice,
+			Value:    new(big.Int),
+		},
+		TxInner: types.TxInner{
+			To: addr,
+		},
+	}, nil
+}
+
+// ValidateOnly runs the stateless and stateful checks a transaction would face on
+// insertion, without adding it to the pool. It allows wallets to dry-run a
+// transaction before submitting it.
+//
+// The checks only read the current state and the pool accounting, so they run
+// under the read lock without copying the state. Capacity related rejections,
+// such as underpricing in a full pool, are not reported.
+func (pool *LegacyPool) ValidateOnly(tx *types.Transaction, local bool) error {
+	if err := pool.validateTxBasics(tx, local); err != nil {
+		return err
+	}
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	if pool.all.Get(tx.Hash()) != nil {
+		return ErrAlreadyKnown
+	}
+	return pool.validateTx(tx, local || pool.isLocal(tx))
+}
+
 // add validates a transaction and inserts it into the non-executable queue for later
 // pending promotion and execution. If the transaction is a replacement for an already
 // pending or queued one, it overwrites the previous transaction if its price is higher.
@@ -1240,7 +1830,7 @@ func (pool *LegacyPool) validateTx(tx *types.Transaction, local bool) error {
 // out of the pool due to pricing constraints.
 func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, err error) {
 	// If the transaction is already known, discard it
-	hash := tx.TxHash
+	hash := tx.Hash()
 	if pool.all.Get(hash) != nil {
 		log.Trace("Discarding already known transaction", "hash", hash)
 		knownTxMeter.Mark(1)
@@ -1248,7 +1838,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 	}
 	// Make the local flag. If it's from local source or it's from the network but
 	// the sender is marked as local previously, treat it as the local transaction.
-	isLocal := local || pool.locals.containsTx(tx)
+	isLocal := local || pool.isLocal(tx)
 
 	// If the transaction fails basic validation, discard it
 	// here valid Tx need to be implemented
@@ -1260,7 +1850,6 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 
 	// already validated by this point
 	from := tx.From
-	// from, err := tx.TxPreface().Validation().GetFrom(tx.TxPreface().TxHash())
 
 	// If the transaction pool is full, discard underpriced transactions
 	if uint64(pool.all.Slots()+numSlots(tx)) > pool.config.GlobalSlots+pool.config.GlobalQueue {
@@ -1298,7 +1887,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 			var replacesPending bool
 			for _, dropTx := range drop {
 				dropSender := dropTx.From
-				if list := pool.pending[dropSender]; list != nil && list.Contains(dropTx.Nonce) {
+				if list := pool.pending[dropSender]; list != nil && list.Overlaps(dropTx) {
 					replacesPending = true
 					break
 				}
@@ -1315,15 +1904,15 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 
 		// Kick out the underpriced remote transactions.
 		for _, tx := range drop {
-			log.Trace("Discarding freshly underpriced transaction", "hash", tx.TxHash, "gasPrice", tx.GasPrice)
+			log.Trace("Discarding freshly underpriced transaction", "hash", tx.Hash(), "gasPrice", tx.GasPrice)
 			underpricedTxMeter.Mark(1)
-			dropped := pool.removeTx(tx.TxHash, false)
+			dropped := pool.removeTx(tx.Hash(), false)
 			pool.changesSinceReorg += dropped
 		}
 	}
 
 	// Try to replace an existing transaction in the pending pool
-	if list := pool.pending[from]; list != nil && list.Contains(tx.Nonce) {
+	if list := pool.pending[from]; list != nil && list.Overlaps(tx) {
 		// Nonce already pending, check if required price bump is met
 		inserted, old := list.Add(tx, pool.config.PriceBump)
 		if !inserted {
@@ -1332,10 +1921,11 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 		}
 		// New transaction is better, replace old one
 		if old != nil {
-			pool.all.Remove(old.TxHash)
-			pool.priced.Removed(1)
+			pool.replaceTx(old, tx)
+			pool.changes.removed(old)
 			pendingReplaceMeter.Mark(1)
 		}
+		pool.changes.added(tx)
 		pool.all.Add(tx, isLocal)
 		pool.priced.Put(tx, isLocal)
 		pool.journalTx(from, tx)
@@ -1366,20 +1956,34 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 	return replaced, nil
 }
 
-// Close terminates the transaction pool.
+// Close terminates the transaction pool. It is safe to call multiple times, any
+// transactions added afterwards are rejected with ErrPoolClosed.
 func (pool *LegacyPool) Close() error {
+	// Mark the pool closed under the lock, so in-flight additions either complete
+	// before the shutdown or get rejected
+	pool.mu.Lock()
+	if !pool.closed.CompareAndSwap(false, true) {
+		pool.mu.Unlock()
+		return nil
+	}
+	pool.mu.Unlock()
+
 	// Unsubscribe all subscriptions registered from txpool
+	pool.chainHeadSub.Unsubscribe()
 	pool.scope.Close()
 
 	// Terminate the pool reorger and return
 	close(pool.reorgShutdownCh)
 	pool.wg.Wait()
 
+	var err error
 	if pool.journal != nil {
-		pool.journal.close()
+		pool.mu.Lock()
+		err = pool.journal.close()
+		pool.mu.Unlock()
 	}
 	log.Info("Transaction pool stopped")
-	return nil
+	return err
 }
 
 // SubscribeTransactions registers a subscription of NewTxsEvent and
@@ -1388,13 +1992,40 @@ func (pool *LegacyPool) SubscribeTransactions(ch chan<- NewTxsEvent) event.Subsc
 	return pool.scope.Track(pool.txFeed.Subscribe(ch))
 }
 
+// SubscribeReannoTxsEvent registers a subscription of ReannoTxsEvent and
+// starts sending event to the given channel.
+func (pool *LegacyPool) SubscribeReannoTxsEvent(ch chan<- ReannoTxsEvent) event.Subscription {
+	return pool.scope.Track(pool.reannoFeed.Subscribe(ch))
+}
+
+// firstNonceGap returns the first nonce of the account which is neither included
+// in the state nor pooled as pending, i.e. the nonce a transaction needs to join
+// the pending list without leaving a gap.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *LegacyPool) firstNonceGap(addr common.Address) uint64 {
+	nonce := pool.currentState.GetNonce(addr)
+	if list := pool.pending[addr]; list != nil {
+		for list.Contains(nonce) {
+			nonce++
+		}
+	}
+	return nonce
+}
+
+// SubscribeReplacements registers a subscription of ReplacedEvent and starts
+// sending event to the given channel.
+func (pool *LegacyPool) SubscribeReplacements(ch chan<- ReplacedEvent) event.Subscription {
+	return pool.scope.Track(pool.replaceFeed.Subscribe(ch))
+}
+
 // isGapped reports whether the given transaction is immediately executable.
 func (pool *LegacyPool) isGapped(from common.Address, tx *types.Transaction) bool {
 	// Short circuit if transaction falls within the scope of the pending list
 	// or matches the next pending nonce which can be promoted as an executable
 	// transaction afterwards. Note, the tx staleness is already checked in
 	// 'validateTx' function previously.
-	next := pool.pendingNonces.Get(from)
+	next := pool.firstNonceGap(from)
 	if tx.Nonce <= next {
 		return false
 	}
@@ -1429,8 +2060,7 @@ func (pool *LegacyPool) enqueueTx(hash common.Hash, tx *types.Transaction, local
 	}
 	// Discard any previous transaction and mark this
 	if old != nil {
-		pool.all.Remove(old.TxHash)
-		pool.priced.Removed(1)
+		pool.replaceTx(old, tx)
 		queuedReplaceMeter.Mark(1)
 	} else {
 		// Nothing was replaced, bump the queued counter
@@ -1452,6 +2082,19 @@ func (pool *LegacyPool) enqueueTx(hash common.Hash, tx *types.Transaction, local
 	return old != nil, nil
 }
 
+// replaceTx drops a transaction superseded by another one from the lookup and
+// price sets, and records the replacement to be announced after the next reorg.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *LegacyPool) replaceTx(old, tx *types.Transaction) {
+	if tx.IsCancel() {
+		log.Trace("Cancelling transaction", "hash", old.Hash(), "cancel", tx.Hash())
+	}
+	pool.all.Remove(old.Hash())
+	pool.priced.Removed(1)
+	pool.replacements = append(pool.replacements, ReplacedEvent{Old: old.Hash(), New: tx.Hash()})
+}
+
 // journalTx adds the specified transaction to the local disk journal if it is
 // deemed to have been sent from a local account.
 func (pool *LegacyPool) journalTx(from common.Address, tx *types.Transaction) {
@@ -1464,6 +2107,27 @@ func (pool *LegacyPool) journalTx(from common.Address, tx *types.Transaction) {
 	}
 }
 
+// RemoveTx explicitly drops a single transaction from the pool, e.g. when it is
+// cancelled by its sender. Any higher nonce pending transactions of the account
+// are moved back to the future queue, as they are no longer executable. A local
+// account left without transactions is forgotten.
+// Returns the number of transactions removed from the pending queue, including
+// the demoted ones.
+func (pool *LegacyPool) RemoveTx(hash common.Hash, outofbound bool) int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	tx := pool.all.Get(hash)
+	if tx == nil {
+		return 0
+	}
+	removed := pool.removeTx(hash, outofbound)
+	if addr := tx.From; pool.pending[addr] == nil && pool.queue[addr] == nil {
+		pool.forgetLocal(addr)
+	}
+	return removed
+}
+
 // removeTx removes a single transaction from the queue, moving all subsequent
 // transactions back to the future queue.
 // Returns the number of transactions removed from the pending queue.
@@ -1486,17 +2150,26 @@ func (pool *LegacyPool) removeTx(hash common.Hash, outofbound bool) int {
 	// Remove the transaction from the pending lists and reset the account nonce
 	if pending := pool.pending[addr]; pending != nil {
 		if removed, invalids := pending.Remove(tx); removed {
-			// If no more pending transactions are left, remove the list
+			// If no more pending transactions are left, remove the list, and
+			// the heartbeat too unless the account has queued transactions
 			if pending.Empty() {
 				delete(pool.pending, addr)
+				if pool.queue[addr] == nil && len(invalids) == 0 {
+					delete(pool.beats, addr)
+				}
 			}
 			// Postpone any invalidated transactions
 			for _, tx := range invalids {
+				log.Debug("Demoting pending transaction", "hash", tx.Hash(), "from", addr, "nonce", tx.Nonce)
+
 				// Internal shuffle shouldn't touch the lookup set.
-				pool.enqueueTx(tx.TxHash, tx, false, false)
+				pool.enqueueTx(tx.Hash(), tx, false, false)
 			}
+			pendingDemoteMeter.Mark(int64(len(invalids)))
 			// Update the account nonce if needed
 			pool.pendingNonces.SetIfLower(addr, tx.Nonce)
+			pool.changes.removed(tx)
+			pool.changes.removed(invalids...)
 			// Reduce the pending counter
 			pendingGauge.Dec(int64(1 + len(invalids)))
 			return 1 + len(invalids)
@@ -1,6 +1,8 @@
 package txpool_instance
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
 	crand "crypto/rand"
 	"errors"
@@ -13,6 +15,8 @@ import (
 	"math/big"
 	"math/rand"
 	"os"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -38,14 +42,20 @@ type EasyBlockChain struct {
 	gasLimit      atomic.Uint64
 	statedb       state.StateDB
 	chainHeadFeed *event.Feed
+
+	blocks   map[common.Hash]types.Block // Blocks served by GetBlock, if injected
+	blocksMu sync.RWMutex
 }
 
 func NewEasyBlockChain(config *params.ChainConfig, gasLimit uint64, statedb state.StateDB, chainHeadFeed *event.Feed) *EasyBlockChain {
+	if chainHeadFeed == nil {
+		chainHeadFeed = new(event.Feed)
+	}
 	bc := &EasyBlockChain{
 		config:        config,
 		gasLimit:      atomic.Uint64{},
 		statedb:       statedb,
-		chainHeadFeed: new(event.Feed),
+		chainHeadFeed: chainHeadFeed,
 	}
 	bc.gasLimit.Store(gasLimit)
 	return bc
@@ -56,13 +66,33 @@ func (bc *EasyBlockChain) Config() *params.ChainConfig {
 }
 
 func (bc *EasyBlockChain) CurrentBlock() types.Header {
-	return types.NewEasyHeader(common.Hash{}, common.Hash{}, new(big.Int), bc.gasLimit.Load())
+	return types.NewEasyHeader(common.Hash{}, common.Hash{}, new(big.Int), bc.gasLimit.Load(), 0, nil)
 }
 
 func (bc *EasyBlockChain) GetBlock(hash common.Hash, number uint64) types.Block {
+	bc.blocksMu.RLock()
+	defer bc.blocksMu.RUnlock()
+
+	if block, ok := bc.blocks[hash]; ok {
+		return block
+	}
 	return types.NewEasyBlock(bc.CurrentBlock(), nil)
 }
 
+// insertBlock makes the given block available through GetBlock, returning its
+// header for use in pool resets.
+func (bc *EasyBlockChain) insertBlock(hash, parent common.Hash, number int64, txs types.Transactions) types.Header {
+	bc.blocksMu.Lock()
+	defer bc.blocksMu.Unlock()
+
+	if bc.blocks == nil {
+		bc.blocks = make(map[common.Hash]types.Block)
+	}
+	header := types.NewEasyHeader(hash, parent, big.NewInt(number), bc.gasLimit.Load(), 0, nil)
+	bc.blocks[hash] = types.NewEasyBlock(header, types.NewEasyBody(txs))
+	return header
+}
+
 func (bc *EasyBlockChain) StateAt(common.Hash) (state.StateDB, error) {
 	return bc.statedb, nil
 }
@@ -93,6 +123,32 @@ func pricedDataTransaction(nonce uint64, gaslimit uint64, gasprice *big.Int, key
 	return tx
 }
 
+func dynamicFeeTransaction(nonce uint64, gaslimit uint64, gasFeeCap *big.Int, gasTipCap *big.Int, key *ecdsa.PrivateKey) *types.Transaction {
+	gp := gadget.NewDynamicGasPrice(gasFeeCap, gasTipCap)
+	to := common.Address{}
+	to.SetBytes([]byte("to"))
+	tx := types.NewNormalTransaction(nonce, to, big.NewInt(100), gaslimit, gp, nil, key)
+	return tx
+}
+
+// signingHash returns the signing hash of a transaction known to be encodable.
+func signingHash(tx *types.Transaction) common.Hash {
+	hash, err := tx.SigningHash()
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// rehash refreshes the stored hash of a transaction modified after signing.
+func rehash(tx *types.Transaction) {
+	hash, err := tx.ComputeHash()
+	if err != nil {
+		panic(err)
+	}
+	tx.TxHash = hash
+}
+
 func setupPool() (*LegacyPool, *ecdsa.PrivateKey) {
 	return setupPoolWithConfig()
 }
@@ -174,7 +230,11 @@ func validateEvents(events chan NewTxsEvent, count int) error {
 }
 
 func deriveSender(tx *types.Transaction) (common.Address, error) {
-	return tx.Validation.GetFrom(tx.TxHash)
+	hash, err := tx.SigningHash()
+	if err != nil {
+		return common.Address{}, err
+	}
+	return tx.Validation.GetFrom(hash)
 }
 
 type testChain struct {
@@ -350,6 +410,70 @@ func TestQueue2(t *testing.T) {
 	}
 }
 
+// Tests that promotion takes the cost of the already pending transactions into
+// account, not promoting queued ones the account can no longer pay for.
+func TestQueuePromotionOverdraft(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	tx0 := transaction(0, 100000, key)
+	tx1 := transaction(1, 100000, key)
+	from, _ := deriveSender(tx0)
+	testAddBalance(pool, from, new(big.Int).Add(tx0.Cost(), big.NewInt(1)))
+	pool.reset(nil, nil)
+
+	pool.enqueueTx(tx0.TxHash, tx0, false, true)
+	pool.promoteExecutables([]common.Address{from})
+	if pool.pending[from].Len() != 1 {
+		t.Fatalf("expected 1 pending transaction, got %d", pool.pending[from].Len())
+	}
+	pool.enqueueTx(tx1.TxHash, tx1, false, true)
+	pool.promoteExecutables([]common.Address{from})
+	if pool.pending[from].Len() != 1 {
+		t.Errorf("expected 1 pending transaction, got %d", pool.pending[from].Len())
+	}
+	if pool.queue[from].Len() != 1 {
+		t.Errorf("expected 1 queued transaction, got %d", pool.queue[from].Len())
+	}
+}
+
+// Tests that filling a nonce gap only promotes as many queued transactions as the
+// balance left over by the pending ones pays for, keeping the rest queued.
+func TestQueuePromotionPartialAllowance(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	txs := []*types.Transaction{transaction(0, 100000, key), transaction(1, 100000, key), transaction(2, 100000, key)}
+
+	balance := new(big.Int)
+	for _, tx := range txs {
+		balance.Add(balance, tx.Cost())
+	}
+	testAddBalance(pool, addr, balance.Sub(balance, big.NewInt(1)))
+
+	// Pool the first and the last transaction, then fill the gap between them
+	for _, tx := range []*types.Transaction{txs[0], txs[2], txs[1]} {
+		if err := pool.addRemoteSync(tx); err != nil {
+			t.Fatalf("failed to add transaction %d: %v", tx.Nonce, err)
+		}
+	}
+	pending, queued := pool.ContentFrom(addr)
+	if len(pending) != 2 || len(queued) != 1 || queued[0].Nonce != 2 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 2 pending 1 queued", len(pending), len(queued))
+	}
+	if cost := pool.pending[addr].GetCost(1); cost.Cmp(balance) > 0 {
+		t.Errorf("pending set overdrafts the account: cost %v, balance %v", cost, balance)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 func TestNegativeValue(t *testing.T) {
 	t.Parallel()
 
@@ -357,917 +481,2696 @@ func TestNegativeValue(t *testing.T) {
 	defer pool.Close()
 	gp := gadget.NewGasPrice(big.NewInt(1))
 	tx := types.NewNormalTransaction(0, common.Address{}, big.NewInt(-100), 100, gp, nil, key)
-	from, _ := deriveSender(tx)
+	from := crypto.PubkeyToAddress(key.PublicKey)
 	testAddBalance(pool, from, big.NewInt(1))
-	if err := pool.addRemote(tx); err != ErrNegativeValue {
+	if err := pool.addRemote(tx); !errors.Is(err, ErrNegativeValue) {
 		t.Error("expected", ErrNegativeValue, "got", err)
 	}
 }
 
-func TestChainFork(t *testing.T) {
+// Tests that malformed transactions lacking the amounts needed for cost accounting
+// are rejected during validation instead of crashing the pool.
+func TestMissingAmounts(t *testing.T) {
 	t.Parallel()
 
 	pool, key := setupPool()
 	defer pool.Close()
 
 	tx := transaction(0, 100000, key)
-	addr := tx.From
-	resetState := func() {
-		statedb := state.NewEasyStateDB()
-		statedb.AddBalance(addr, big.NewInt(100000000000000))
+	testAddBalance(pool, tx.From, big.NewInt(1000000000))
 
-		pool.chain = NewEasyBlockChain(pool.chainconfig, 1000000, statedb, new(event.Feed))
-		<-pool.requestReset(nil, nil)
+	tx.Value = nil
+	if err := pool.addRemote(tx); !errors.Is(err, ErrMissingValue) {
+		t.Error("expected", ErrMissingValue, "got", err)
 	}
-	resetState()
-
-	if _, err := pool.add(tx, false); err != nil {
-		t.Error("didn't expect error", err)
+	tx = transaction(0, 100000, key)
+	tx.GasPrice = &gadget.GasPrice{}
+	if err := pool.addRemote(tx); !errors.Is(err, ErrMissingGasPrice) {
+		t.Error("expected", ErrMissingGasPrice, "got", err)
 	}
-	pool.removeTx(tx.TxHash, true)
-
-	// reset the pool's internal state
-	resetState()
-	if _, err := pool.add(tx, false); err != nil {
-		t.Error("didn't expect error", err)
+	tx = transaction(0, 100000, key)
+	tx.GasPrice = nil
+	if err := pool.addRemote(tx); !errors.Is(err, ErrMissingGasPrice) {
+		t.Error("expected", ErrMissingGasPrice, "got", err)
+	}
+	if err := pool.addLocal(tx); !errors.Is(err, ErrMissingGasPrice) {
+		t.Error("expected", ErrMissingGasPrice, "got", err)
+	}
+	if cost := tx.Cost(); cost.Cmp(tx.Value) != 0 {
+		t.Errorf("unpriced transaction cost mismatch: have %v, want %v", cost, tx.Value)
+	}
+	if pending, queued := pool.Stats(); pending != 0 || queued != 0 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 0 pending 0 queued", pending, queued)
 	}
 }
 
-func TestDoubleNonce(t *testing.T) {
+// Tests that dry-run validation reports the same errors as insertion without
+// touching the pool.
+func TestValidateOnly(t *testing.T) {
 	t.Parallel()
 
 	pool, key := setupPool()
 	defer pool.Close()
 
+	from := crypto.PubkeyToAddress(key.PublicKey)
 	tx := transaction(0, 100000, key)
-	addr := tx.From
-
-	resetState := func() {
-		statedb := state.NewEasyStateDB()
-		statedb.AddBalance(addr, big.NewInt(100000000000000))
-
-		pool.chain = NewEasyBlockChain(pool.chainconfig, 1000000, statedb, new(event.Feed))
-		<-pool.requestReset(nil, nil)
+	if err := pool.ValidateOnly(tx, false); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("expected %v, got %v", ErrInsufficientFunds, err)
 	}
-	resetState()
-
-	gp1 := gadget.NewGasPrice(big.NewInt(1))
-	gp2 := gadget.NewGasPrice(big.NewInt(2))
-	tx1 := types.NewNormalTransaction(0, common.Address{}, big.NewInt(100), 100000, gp1, nil, key)
-	tx2 := types.NewNormalTransaction(0, common.Address{}, big.NewInt(100), 1000000, gp2, nil, key)
-	tx3 := types.NewNormalTransaction(0, common.Address{}, big.NewInt(100), 1000000, gp1, nil, key)
+	testAddBalance(pool, from, big.NewInt(1000000000))
 
-	// Add the first two transaction, ensure higher priced stays only
-	if replace, err := pool.add(tx1, false); err != nil || replace {
-		t.Errorf("first transaction insert failed (%v) or reported replacement (%v)", err, replace)
-	}
-	if replace, err := pool.add(tx2, false); err != nil || !replace {
-		t.Errorf("second transaction insert failed (%v) or not reported replacement (%v)", err, replace)
+	tx = transaction(0, 100000, key)
+	tx.Value = big.NewInt(101)
+	if err := pool.ValidateOnly(tx, false); !errors.Is(err, ErrInvalidHash) {
+		t.Fatalf("expected %v, got %v", ErrInvalidHash, err)
 	}
-	<-pool.requestPromoteExecutables(newAccountSet(addr))
-	if pool.pending[addr].Len() != 1 {
-		t.Error("expected 1 pending transactions, got", pool.pending[addr].Len())
+	tx = transaction(0, 100000, key)
+	if err := pool.ValidateOnly(tx, false); err != nil {
+		t.Fatalf("failed to validate transaction: %v", err)
 	}
-	if tx := pool.pending[addr].txs.items[0]; tx.TxHash != tx2.TxHash {
-		t.Errorf("transaction mismatch: have %x, want %x", tx.TxHash, tx2.TxHash)
+	if pending, queued := pool.Stats(); pending != 0 || queued != 0 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 0 pending 0 queued", pending, queued)
 	}
-
-	// Add the third transaction and ensure it's not saved (smaller price)
-	pool.add(tx3, false)
-	<-pool.requestPromoteExecutables(newAccountSet(addr))
-	if pool.pending[addr].Len() != 1 {
-		t.Error("expected 1 pending transactions, got", pool.pending[addr].Len())
+	if pool.Has(tx.Hash()) {
+		t.Fatalf("validated transaction was inserted into the pool")
 	}
-	if tx := pool.pending[addr].txs.items[0]; tx.TxHash != tx2.TxHash {
-		t.Errorf("transaction mismatch: have %x, want %x", tx.TxHash, tx2.TxHash)
+	if err := pool.addRemoteSync(tx); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
 	}
-	// Ensure the total transaction count is correct
-	if pool.all.Count() != 1 {
-		t.Error("expected 1 total transactions, got", pool.all.Count())
+	if err := pool.ValidateOnly(tx, false); !errors.Is(err, ErrAlreadyKnown) {
+		t.Fatalf("expected %v, got %v", ErrAlreadyKnown, err)
 	}
 }
 
-func TestMissingNonce(t *testing.T) {
+// Tests that transactions whose advertised hash doesn't match their contents are
+// rejected instead of shadowing other transactions.
+func TestInvalidHash(t *testing.T) {
 	t.Parallel()
 
 	pool, key := setupPool()
 	defer pool.Close()
 
-	tx := transaction(1, 100000, key)
-	addr := tx.From
-	testAddBalance(pool, addr, big.NewInt(100000000000000))
-	if _, err := pool.add(tx, false); err != nil {
-		t.Error("didn't expect error", err)
-	}
-	if len(pool.pending) != 0 {
-		t.Error("expected 0 pending transactions, got", len(pool.pending))
-	}
-	if pool.queue[addr].Len() != 1 {
-		t.Error("expected 1 queued transaction, got", pool.queue[addr].Len())
+	tx := transaction(0, 100000, key)
+	testAddBalance(pool, tx.From, big.NewInt(1000000000))
+
+	tx.Value = big.NewInt(101)
+	if err := pool.addRemote(tx); !errors.Is(err, ErrInvalidHash) {
+		t.Error("expected", ErrInvalidHash, "got", err)
 	}
-	if pool.all.Count() != 1 {
-		t.Error("expected 1 total transactions, got", pool.all.Count())
+	if pool.Has(tx.TxHash) {
+		t.Error("tampered transaction was accepted into the pool")
 	}
 }
 
-func TestNonceRecovery(t *testing.T) {
+// Tests that replay protected transactions are only accepted if signed for the
+// pool's chain, while legacy signatures remain valid on any chain.
+func TestChainIDReplayProtection(t *testing.T) {
 	t.Parallel()
 
-	const n = 10
-	pool, key := setupPool()
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(&params.ChainConfig{ChainID: big.NewInt(1)}, 10000000, statedb, new(event.Feed))
+
+	pool := New(testTxPoolConfig, blockchain)
+	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
 	defer pool.Close()
-	tx := transaction(n, 100000, key)
-	addr := tx.From
 
-	testSetNonce(pool, addr, n)
-	testAddBalance(pool, addr, big.NewInt(100000000000000))
-	<-pool.requestReset(nil, nil)
+	key, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
 
-	if err := pool.addRemote(tx); err != nil {
-		t.Error(err)
+	protected := func(chainID int64) *types.Transaction {
+		tx := transaction(0, 100000, key)
+		tx.Validation.SignWithChainID(signingHash(tx), key, big.NewInt(chainID))
+		rehash(tx)
+		return tx
 	}
-	// simulate some weird re-order of transactions and missing nonce(s)
-	testSetNonce(pool, addr, n-1)
-	<-pool.requestReset(nil, nil)
-	if fn := pool.Nonce(addr); fn != n-1 {
-		t.Errorf("expected nonce to be %d, got %d", n-1, fn)
+	if err := pool.addRemote(protected(2)); !errors.Is(err, ErrInvalidSender) {
+		t.Errorf("foreign chain transaction error mismatch: have %v, want %v", err, ErrInvalidSender)
+	}
+	// Rewrite the chain ID of a transaction signed for another chain to ours
+	replayed := protected(2)
+	replayed.Validation.V.Sub(replayed.Validation.V, big.NewInt(2))
+	rehash(replayed)
+	if err := pool.addRemote(replayed); !errors.Is(err, ErrInvalidSender) {
+		t.Errorf("rewritten chain transaction error mismatch: have %v, want %v", err, ErrInvalidSender)
+	}
+	if err := pool.addRemote(protected(1)); err != nil {
+		t.Errorf("failed to add protected transaction: %v", err)
+	}
+	if err := pool.addRemote(transaction(1, 100000, key)); err != nil {
+		t.Errorf("failed to add legacy transaction: %v", err)
 	}
 }
 
-func TestDropping(t *testing.T) {
+// Tests that transactions signed by an account other than the one they claim to
+// originate from are rejected.
+func TestForgedSender(t *testing.T) {
 	t.Parallel()
 
-	// Create a test account and fund it
 	pool, key := setupPool()
 	defer pool.Close()
 
-	tx := transaction(0, 100000, key)
-	account := tx.From
-	testAddBalance(pool, account, big.NewInt(1000))
+	victim, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(victim.PublicKey), big.NewInt(1000000000))
 
-	// Add some pending and some queued transactions
-	var (
-		tx0  = transaction(0, 100, key)
-		tx1  = transaction(1, 200, key)
-		tx2  = transaction(2, 300, key)
-		tx10 = transaction(10, 100, key)
-		tx11 = transaction(11, 200, key)
-		tx12 = transaction(12, 300, key)
-	)
-	pool.all.Add(tx0, false)
-	pool.priced.Put(tx0, false)
-	pool.promoteTx(account, tx0.TxHash, tx0)
+	tx := transaction(0, 100000, key)
+	tx.From = crypto.PubkeyToAddress(victim.PublicKey)
+	tx.Validation.Sign(signingHash(tx), key)
+	rehash(tx)
 
-	pool.all.Add(tx1, false)
-	pool.priced.Put(tx1, false)
-	pool.promoteTx(account, tx1.TxHash, tx1)
+	if err := pool.addRemote(tx); !errors.Is(err, ErrInvalidSender) {
+		t.Errorf("forged transaction error mismatch: have %v, want %v", err, ErrInvalidSender)
+	}
+}
 
-	pool.all.Add(tx2, false)
-	pool.priced.Put(tx2, false)
-	pool.promoteTx(account, tx2.TxHash, tx2)
+// Tests that malleable high-S signatures are rejected by default, but accepted if
+// the pool is configured to tolerate them.
+func TestHighSSignatures(t *testing.T) {
+	t.Parallel()
 
-	pool.enqueueTx(tx10.TxHash, tx10, false, true)
-	pool.enqueueTx(tx11.TxHash, tx11, false, true)
-	pool.enqueueTx(tx12.TxHash, tx12, false, true)
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
 
-	// Check that pre and post validations leave the pool as is
-	if pool.pending[account].Len() != 3 {
-		t.Errorf("pending transaction mismatch: have %d, want %d", pool.pending[account].Len(), 3)
-	}
-	if pool.queue[account].Len() != 3 {
-		t.Errorf("queued transaction mismatch: have %d, want %d", pool.queue[account].Len(), 3)
+	// Flip the signature into the upper half of the curve order, which recovers
+	// the very same sender with the opposite parity
+	malleate := func(tx *types.Transaction) *types.Transaction {
+		tx.Validation.S = new(big.Int).Sub(crypto.Secp256k1N, tx.Validation.S)
+		tx.Validation.V = new(big.Int).Sub(big.NewInt(55), tx.Validation.V)
+		rehash(tx)
+		return tx
 	}
-	if pool.all.Count() != 6 {
-		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 6)
+	if _, err := malleate(transaction(0, 100000, key)).Sender(); err == nil {
+		t.Fatalf("high-S signature accepted under strict rules")
 	}
-
-	<-pool.requestReset(nil, nil)
-	if pool.pending[account].Len() != 3 {
-		t.Errorf("pending transaction mismatch: have %d, want %d", pool.pending[account].Len(), 3)
+	if sender, err := malleate(transaction(0, 100000, key)).RecoverSender(false); err != nil || sender != from {
+		t.Fatalf("high-S sender mismatch: have %v (%v), want %v", sender, err, from)
 	}
-	if pool.queue[account].Len() != 3 {
-		t.Errorf("queued transaction mismatch: have %d, want %d", pool.queue[account].Len(), 3)
+	// A sender recovered under the relaxed rules must not leak into strict ones
+	lenient := malleate(transaction(0, 100000, key))
+	if _, err := lenient.RecoverSender(false); err != nil {
+		t.Fatalf("failed to recover high-S sender: %v", err)
 	}
-	if pool.all.Count() != 6 {
-		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 6)
+	if _, err := lenient.Sender(); err == nil {
+		t.Fatalf("high-S signature accepted under strict rules after relaxed recovery")
 	}
+	for _, allow := range []bool{false, true} {
+		statedb := state.NewEasyStateDB()
+		blockchain := NewEasyBlockChain(nil, 10000000, statedb, new(event.Feed))
 
-	// Reduce the balance of the account, and check that invalidated transactions are dropped
-	testAddBalance(pool, account, big.NewInt(-650))
-	<-pool.requestReset(nil, nil)
+		config := testTxPoolConfig
+		config.AllowHighS = allow
 
-	if _, ok := pool.pending[account].txs.items[tx0.Nonce]; !ok {
-		t.Errorf("funded pending transaction missing: %v", tx0)
-	}
-	if _, ok := pool.pending[account].txs.items[tx1.Nonce]; !ok {
-		t.Errorf("funded pending transaction missing: %v", tx0)
+		pool := New(config, blockchain)
+		if err := pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock()); err != nil {
+			t.Fatalf("failed to init pool: %v", err)
+		}
+		testAddBalance(pool, from, big.NewInt(1000000000))
+
+		err := pool.addRemoteSync(malleate(transaction(0, 100000, key)))
+		switch {
+		case !allow && !errors.Is(err, ErrInvalidSender):
+			t.Errorf("strict pool error mismatch: have %v, want %v", err, ErrInvalidSender)
+		case allow && err != nil:
+			t.Errorf("lenient pool rejected high-S signature: %v", err)
+		}
+		pool.Close()
 	}
-	if _, ok := pool.pending[account].txs.items[tx2.Nonce]; ok {
-		t.Errorf("out-of-fund pending transaction present: %v", tx1)
+}
+
+// Tests that withdraw and recharge transactions go through their own dedicated
+// validation rules instead of bypassing them.
+func TestValidateCoinTransactions(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	owner := common.BytesToAddress([]byte("owner"))
+	other := common.BytesToAddress([]byte("other"))
+	outputs := func(amount int64) []gadget.OutputCoin {
+		return []gadget.OutputCoin{{Amount: big.NewInt(amount), Owner: owner}}
+	}
+	coinKey, _ := crypto.GenerateKey()
+	coinOwner := crypto.PubkeyToAddress(coinKey.PublicKey)
+	// Witnesses sign the recharge they are part of, so they are made over a draft
+	// without them, which shares the signing hash of the final transaction
+	draft := func(inputs []gadget.InputCoin, value *big.Int) *types.Transaction {
+		tx := types.NewRechargeTransaction(inputs, nil, gadget.NewGasPrice(big.NewInt(1)), owner)
+		tx.Value = value
+		return tx
+	}
+	witness := func(tx *types.Transaction, input int, prv *ecdsa.PrivateKey) gadget.Witness {
+		w, err := gadget.NewWitness(signingHash(tx), input, prv)
+		if err != nil {
+			t.Fatalf("failed to sign coin: %v", err)
+		}
+		return w
+	}
+	inputs := []gadget.InputCoin{{TxHash: common.GenerateHash([]byte("coin")), Amount: big.NewInt(1000), Owner: coinOwner.Bytes()}}
+	separate := []gadget.InputCoin{
+		{TxHash: common.GenerateHash([]byte("coin")), Amount: big.NewInt(1000), Owner: coinOwner.Bytes()},
+		{TxHash: common.GenerateHash([]byte("coin")), Index: 1, Amount: big.NewInt(1000), Owner: coinOwner.Bytes(), WitnessIndex: 1},
+	}
+	shared := []gadget.InputCoin{separate[0], separate[1]}
+	shared[1].WitnessIndex = 0
+
+	double := []gadget.InputCoin{inputs[0], inputs[0]}
+	worthless := []gadget.InputCoin{{TxHash: common.GenerateHash([]byte("coin")), Amount: big.NewInt(0), Owner: coinOwner.Bytes()}}
+	unvalued := []gadget.InputCoin{{TxHash: common.GenerateHash([]byte("coin")), Owner: coinOwner.Bytes()}}
+
+	unvaluedOutput := types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), []gadget.OutputCoin{{Owner: owner}}, key)
+	inflated := []gadget.InputCoin{{TxHash: common.GenerateHash([]byte("coin")), Amount: big.NewInt(2000), Owner: coinOwner.Bytes()}}
+	outOfRange := []gadget.InputCoin{{TxHash: common.GenerateHash([]byte("coin")), Amount: big.NewInt(1000), Owner: coinOwner.Bytes(), WitnessIndex: 1}}
+
+	valid := []gadget.Witness{witness(draft(inputs, nil), 0, coinKey)}
+	stolen := []gadget.Witness{witness(draft(inputs, nil), 0, key)}
+	separateWitnesses := []gadget.Witness{witness(draft(separate, nil), 0, coinKey), witness(draft(separate, nil), 1, coinKey)}
+	sharedWitnesses := []gadget.Witness{witness(draft(shared, nil), 0, coinKey)}
+
+	// A valid witness lifted into a recharge paying someone else
+	lifted := types.NewRechargeTransaction(inputs, valid, gadget.NewGasPrice(big.NewInt(1)), other)
+
+	stray := types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), outputs(1000), key)
+	stray.Witnesses = []gadget.Witness{{}}
+
+	tampered := types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), outputs(1000), key)
+	tampered.OutputCoins[0].Amount = big.NewInt(2000)
+
+	unordered := types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), []gadget.OutputCoin{{Amount: big.NewInt(1), Owner: owner}, {Amount: big.NewInt(1), Owner: other}}, key)
+	unordered.OutputCoins[0], unordered.OutputCoins[1] = unordered.OutputCoins[1], unordered.OutputCoins[0]
+	unordered.Validation.Sign(signingHash(unordered), key)
+	rehash(unordered)
+
+	// Fields the withdrawal doesn't sign, attached after signing by a relayer
+	stuffed := types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), outputs(1000), key)
+	stuffed.Data = []byte{0x01}
+	rehash(stuffed)
+
+	repeated := types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), []gadget.OutputCoin{{Amount: big.NewInt(2), Owner: owner}, {Amount: big.NewInt(1), Owner: owner}}, key)
+
+	recharge := func(value int64) *types.Transaction {
+		tx := draft(inputs, big.NewInt(value))
+		tx.Witnesses = []gadget.Witness{witness(tx, 0, coinKey)}
+		rehash(tx)
+		return tx
+	}
+	underpriced := types.NewRechargeTransaction(inputs, nil, gadget.NewGasPrice(big.NewInt(0)), owner)
+	underpriced.Witnesses = []gadget.Witness{witness(underpriced, 0, coinKey)}
+	rehash(underpriced)
+
+	// A recharge claiming another hash than its contents derive
+	misnamed := types.NewRechargeTransaction(inputs, valid, gadget.NewGasPrice(big.NewInt(1)), owner)
+	misnamed.TxHash = common.Hash{0x01}
+
+	tests := []struct {
+		name string
+		tx   *types.Transaction
+		err  error
+	}{
+		{"withdraw", types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), outputs(1000), key), nil},
+		{"withdraw zero output", types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), outputs(0), key), ErrNonPositiveCoin},
+		{"withdraw negative output", types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), outputs(-1), key), ErrNonPositiveCoin},
+		{"withdraw nil output", unvaluedOutput, ErrNonPositiveCoin},
+		{"withdraw tampered", tampered, ErrInvalidHash},
+		{"withdraw underpriced", types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(0)), outputs(1000), key), ErrUnderpriced},
+		{"withdraw unordered outputs", unordered, ErrUnorderedOutputs},
+		{"withdraw repeated owner", repeated, nil},
+		{"withdraw unsigned data", stuffed, ErrUnsignedFields},
+		{"recharge", types.NewRechargeTransaction(inputs, valid, gadget.NewGasPrice(big.NewInt(1)), owner), nil},
+		{"recharge without witness", types.NewRechargeTransaction(inputs, nil, gadget.NewGasPrice(big.NewInt(1)), owner), ErrWitnessMismatch},
+		{"recharge empty witness", types.NewRechargeTransaction(inputs, []gadget.Witness{{}}, gadget.NewGasPrice(big.NewInt(1)), owner), ErrInvalidWitness},
+		{"recharge stolen coin", types.NewRechargeTransaction(inputs, stolen, gadget.NewGasPrice(big.NewInt(1)), owner), ErrInvalidWitness},
+		{"recharge inflated coin", types.NewRechargeTransaction(inflated, valid, gadget.NewGasPrice(big.NewInt(1)), owner), ErrInvalidWitness},
+		{"recharge without gas price", types.NewRechargeTransaction(inputs, valid, nil, owner), ErrMissingGasPrice},
+		{"recharge separate witnesses", types.NewRechargeTransaction(separate, separateWitnesses, gadget.NewGasPrice(big.NewInt(1)), owner), nil},
+		{"recharge shared witness", types.NewRechargeTransaction(shared, sharedWitnesses, gadget.NewGasPrice(big.NewInt(1)), owner), ErrInvalidWitness},
+		{"recharge lifted witness", lifted, ErrInvalidWitness},
+		{"recharge witness out of range", types.NewRechargeTransaction(outOfRange, valid, gadget.NewGasPrice(big.NewInt(1)), owner), ErrWitnessMismatch},
+		{"recharge unused witness", types.NewRechargeTransaction(inputs, append(valid, valid[0]), gadget.NewGasPrice(big.NewInt(1)), owner), ErrWitnessMismatch},
+		{"withdraw stray witness", stray, ErrWitnessMismatch},
+		{"recharge double spend", types.NewRechargeTransaction(double, valid, gadget.NewGasPrice(big.NewInt(1)), owner), ErrDuplicateInput},
+		{"recharge zero input", types.NewRechargeTransaction(worthless, []gadget.Witness{witness(draft(worthless, nil), 0, coinKey)}, gadget.NewGasPrice(big.NewInt(1)), owner), ErrNonPositiveCoin},
+		{"recharge nil input", types.NewRechargeTransaction(unvalued, []gadget.Witness{witness(draft(unvalued, nil), 0, coinKey)}, gadget.NewGasPrice(big.NewInt(1)), owner), ErrNonPositiveCoin},
+		{"recharge matching value", recharge(1000), nil},
+		{"recharge inflated value", recharge(2000), ErrRechargeImbalance},
+		{"recharge without inputs", types.NewRechargeTransaction(nil, nil, gadget.NewGasPrice(big.NewInt(1)), owner), ErrRechargeImbalance},
+		{"recharge underpriced", underpriced, ErrUnderpriced},
+		{"recharge invalid hash", misnamed, ErrInvalidHash},
+	}
+	for _, tt := range tests {
+		if err := pool.validateTxBasics(tt.tx, false); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.err)
+		}
 	}
-	if _, ok := pool.queue[account].txs.items[tx10.Nonce]; !ok {
-		t.Errorf("funded queued transaction missing: %v", tx10)
+}
+
+// Tests that negative amounts are rejected for every transaction type before
+// anything relies on the encoding, which can't represent them.
+func TestValidateNegativeAmounts(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	owner := common.BytesToAddress([]byte("owner"))
+	withdraw := func() *types.Transaction {
+		return types.NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), []gadget.OutputCoin{{Amount: big.NewInt(1000), Owner: owner}}, key)
 	}
-	if _, ok := pool.queue[account].txs.items[tx11.Nonce]; !ok {
-		t.Errorf("funded queued transaction missing: %v", tx10)
+	negativeValue := withdraw()
+	negativeValue.Value = big.NewInt(-1)
+
+	negativeTip := withdraw()
+	negativeTip.GasPrice = gadget.NewDynamicGasPrice(big.NewInt(1), big.NewInt(-1))
+
+	negativePrice := transaction(0, 100000, key)
+	negativePrice.GasPrice = gadget.NewGasPrice(big.NewInt(-1))
+
+	negativeRefund := transaction(0, 100000, key)
+	negativeRefund.Refund = &gadget.Refund{Gas: big.NewInt(-1)}
+
+	tests := []struct {
+		name string
+		tx   *types.Transaction
+		err  error
+	}{
+		{"withdraw negative value", negativeValue, ErrNegativeValue},
+		{"withdraw negative tip", negativeTip, ErrNegativeGasPrice},
+		{"normal negative price", negativePrice, ErrNegativeGasPrice},
+		{"normal negative refund", negativeRefund, ErrInvalidEncoding},
 	}
-	if _, ok := pool.queue[account].txs.items[tx12.Nonce]; ok {
-		t.Errorf("out-of-fund queued transaction present: %v", tx11)
-	}
-	if pool.all.Count() != 4 {
-		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 4)
+	for _, tt := range tests {
+		if err := pool.validateTxBasics(tt.tx, false); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.err)
+		}
 	}
+}
 
-	// Reduce the block gas limit, check that invalidated transactions are dropped
-	pool.chain.(*EasyBlockChain).gasLimit.Store(100)
+// Tests that withdrawals are checked against the nonce and balance of the account
+// they pay out from, just like normal transactions.
+func TestValidateWithdrawState(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	owner := common.BytesToAddress([]byte("owner"))
+	withdraw := func(nonce uint64, amount int64) *types.Transaction {
+		return types.NewWithdrawTransaction(nonce, gadget.NewGasPrice(big.NewInt(1)), []gadget.OutputCoin{{Amount: big.NewInt(amount), Owner: owner}}, key)
+	}
+	testAddBalance(pool, from, big.NewInt(500))
+	testSetNonce(pool, from, 1)
 	<-pool.requestReset(nil, nil)
 
-	if _, ok := pool.pending[account].txs.items[tx0.Nonce]; !ok {
-		t.Errorf("funded pending transaction missing: %v", tx0)
+	if err := pool.addRemoteSync(withdraw(1, 1000)); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("overdrawing withdrawal error mismatch: have %v, want %v", err, ErrInsufficientFunds)
 	}
-	if _, ok := pool.pending[account].txs.items[tx1.Nonce]; ok {
-		t.Errorf("over-gased pending transaction present: %v", tx1)
+	if err := pool.addRemoteSync(withdraw(0, 100)); !errors.Is(err, ErrNonceTooLow) {
+		t.Errorf("stale withdrawal error mismatch: have %v, want %v", err, ErrNonceTooLow)
 	}
-	if _, ok := pool.queue[account].txs.items[tx10.Nonce]; !ok {
-		t.Errorf("funded queued transaction missing: %v", tx10)
+	if err := pool.addRemoteSync(withdraw(1, 300)); err != nil {
+		t.Fatalf("failed to add withdrawal: %v", err)
 	}
-	if _, ok := pool.queue[account].txs.items[tx11.Nonce]; ok {
-		t.Errorf("over-gased queued transaction present: %v", tx11)
+	// The pending withdrawal counts against the balance of the next one
+	if err := pool.addRemoteSync(withdraw(2, 300)); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("overdrafting withdrawal error mismatch: have %v, want %v", err, ErrInsufficientFunds)
 	}
-	if pool.all.Count() != 2 {
-		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 2)
+	if pending, _ := pool.Stats(); pending != 1 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 1)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
 	}
 }
 
-func TestPostponing(t *testing.T) {
+// Tests that transactions priced above the configured ceiling are rejected, even
+// if local, while the ceiling itself is still acceptable.
+func TestMaxGasPrice(t *testing.T) {
 	t.Parallel()
 
-	// Create the pool to test the postponing with
 	statedb := state.NewEasyStateDB()
-	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+	blockchain := NewEasyBlockChain(nil, 10000000, statedb, new(event.Feed))
 
-	pool := New(testTxPoolConfig, blockchain)
+	config := testTxPoolConfig
+	config.MaxGasPrice = big.NewInt(100)
+
+	pool := New(config, blockchain)
 	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
 	defer pool.Close()
 
-	// Create two test accounts to produce different gap profiles with
-	keys := make([]*ecdsa.PrivateKey, 2)
-	accs := make([]common.Address, len(keys))
+	key, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
 
-	for i := 0; i < len(keys); i++ {
-		keys[i], _ = crypto.GenerateKey()
-		accs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
-		testAddBalance(pool, accs[i], big.NewInt(50100))
-	}
-	// Add a batch consecutive pending transactions for validation
-	txs := []*types.Transaction{}
-	for i, key := range keys {
-		for j := 0; j < 100; j++ {
-			var tx *types.Transaction
-			if (i+j)%2 == 0 {
-				tx = transaction(uint64(j), 25000, key)
-			} else {
-				tx = transaction(uint64(j), 50000, key)
-			}
-			txs = append(txs, tx)
-		}
-	}
-	for i, err := range pool.addRemotesSync(txs) {
-		if err != nil {
-			t.Fatalf("tx %d: failed to add transactions: %v", i, err)
-		}
-	}
-	// Check that pre and post validations leave the pool as is
-	if pending := pool.pending[accs[0]].Len() + pool.pending[accs[1]].Len(); pending != 2 {
-		t.Errorf("pending transaction mismatch: have %d, want %d", pending, 4)
+	if err := pool.addLocal(pricedTransaction(0, 100000, big.NewInt(101), key)); !errors.Is(err, ErrPriceVeryHigh) {
+		t.Errorf("overpriced transaction error mismatch: have %v, want %v", err, ErrPriceVeryHigh)
 	}
-	if queue := pool.queue[accs[0]].Len() + pool.queue[accs[1]].Len(); queue != 128 {
-		t.Errorf("queued transaction mismatch: have %d, want %d", queue, 128)
+	if err := pool.addRemote(dynamicFeeTransaction(0, 100000, big.NewInt(101), big.NewInt(1), key)); !errors.Is(err, ErrPriceVeryHigh) {
+		t.Errorf("overpriced dynamic fee transaction error mismatch: have %v, want %v", err, ErrPriceVeryHigh)
 	}
-	if pool.all.Count() != 130 { // discard 70 = 35 * 2, and 2 are going to pending, 128 to queue
-		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 130)
+	if err := pool.addRemote(pricedTransaction(0, 100000, big.NewInt(100), key)); err != nil {
+		t.Errorf("failed to add transaction at the price ceiling: %v", err)
 	}
+}
 
-	<-pool.requestReset(nil, nil)
+// Tests that gas estimation returns the intrinsic gas of value transfers and
+// refuses what it can't estimate without executing code.
+func TestEstimateGas(t *testing.T) {
+	t.Parallel()
 
-	if pending := pool.pending[accs[0]].Len() + pool.pending[accs[1]].Len(); pending != 2 {
-		t.Errorf("pending transaction mismatch: have %d, want %d", pending, 4)
-	}
-	if queue := pool.queue[accs[0]].Len() + pool.queue[accs[1]].Len(); queue != 128 {
-		t.Errorf("queued transaction mismatch: have %d, want %d", queue, 128)
-	}
-	if pool.all.Count() != 130 { // discard 70 = 35 * 2, and 2 are going to pending, 128 to queue
-		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 130)
-	}
+	pool, key := setupPool()
+	defer pool.Close()
 
-	// Reduce the balance of the account, and check that transactions are reorganised
-	for _, addr := range accs {
-		testAddBalance(pool, addr, big.NewInt(-1))
-	}
-	<-pool.requestReset(nil, nil)
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000))
 
-	// The first account's first transaction remains valid, check that subsequent
-	// ones are either filtered out, or queued up for later.
-	if _, ok := pool.pending[accs[0]].txs.items[txs[0].Nonce]; !ok {
-		t.Errorf("tx %d: valid and funded transaction missing from pending pool: %v", 0, txs[0])
-	}
-	// the second account's first transaction is invalid.
-	if _, ok := pool.queue[accs[1]].txs.items[txs[100].Nonce]; ok {
-		t.Errorf("tx %d: out-of-fund transaction present in future queue: %v", 100, txs[100])
+	// Plain transfers need exactly their intrinsic gas, data included
+	tx := pricedDataTransaction(0, 0, big.NewInt(1), key, 10)
+	want, _ := tx.IntrinsicGas(pool.chainconfig)
+	if gas, err := pool.EstimateGas(tx); err != nil || gas != want {
+		t.Errorf("transfer estimate mismatch: have %d (%v), want %d", gas, err, want)
 	}
-
-	// every promoteExecutable and demoteUnexcutable function will filter pending and queue with Balance
-	// there are lots of transactions discarded
-	for i, tx := range txs[1:100] {
-		if tx.Nonce >= 65 {
-			continue
-		}
-		if (i+1)%2 == 1 { //cost 50100, which is insufficient
-			if _, ok := pool.queue[accs[0]].txs.items[tx.Nonce]; ok {
-				t.Errorf("tx %d: out-of-fund transaction present in future queue: %v", i+1, tx)
-			}
-		} else { // cost 25100, which is sufficient
-			if _, ok := pool.queue[accs[0]].txs.items[tx.Nonce]; !ok {
-				t.Errorf("tx %d: valid but future transaction missing from future queue: %v", i+1, tx)
-			}
-		}
+	// A gas limit below the intrinsic gas is rejected early
+	if _, err := pool.EstimateGas(transaction(0, 20000, key)); !errors.Is(err, ErrIntrinsicGas) {
+		t.Errorf("low gas limit error mismatch: have %v, want %v", err, ErrIntrinsicGas)
 	}
-	// The second account's first transaction got invalid, check that all transactions
-	// are either filtered out, or queued up for later.
-	if pool.pending[accs[1]] != nil {
-		t.Errorf("invalidated account still has pending transactions")
+	// Senders that can't afford the transfer can't have it estimated
+	poor, _ := crypto.GenerateKey()
+	if _, err := pool.EstimateGas(transaction(0, 0, poor)); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("unfunded estimate error mismatch: have %v, want %v", err, ErrInsufficientFunds)
 	}
-
-	for i, tx := range txs[101:] {
-		if tx.Nonce >= 65 {
-			continue
-		}
-		if (i+101)%2 == 1 { //cost 50100, which is insufficient
-			if _, ok := pool.queue[accs[0]].txs.items[tx.Nonce]; ok {
-				t.Errorf("tx %d: out-of-fund transaction present in future queue: %v", i+101, tx)
-			}
-		} else { // cost 25100, which is sufficient
-			if _, ok := pool.queue[accs[0]].txs.items[tx.Nonce]; !ok {
-				t.Errorf("tx %d: valid but future transaction missing from future queue: %v", i+101, tx)
-			}
-		}
+	// Without an executor, contract creations and calls into code can't be run
+	create := types.NewNormalTransaction(0, common.Address{}, big.NewInt(0), 0, gadget.NewGasPrice(big.NewInt(1)), []byte{0x60, 0x00}, key)
+	if _, err := pool.EstimateGas(create); !errors.Is(err, ErrExecutionUnsupported) {
+		t.Errorf("contract creation error mismatch: have %v, want %v", err, ErrExecutionUnsupported)
 	}
-	// half in the queue will be dsiacrded because of insufficient balance
-	if pool.all.Count() != 65 {
-		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 65)
+	pool.mu.Lock()
+	pool.currentState.SetCode(tx.To, []byte{0x60, 0x00})
+	pool.mu.Unlock()
+	if _, err := pool.EstimateGas(tx); !errors.Is(err, ErrExecutionUnsupported) {
+		t.Errorf("contract call error mismatch: have %v, want %v", err, ErrExecutionUnsupported)
 	}
 }
 
-// Tests that if the transaction pool has both executable and non-executable
-// transactions from an origin account, filling the nonce gap moves all queued
-// ones into the pending pool.
-func TestGapFilling(t *testing.T) {
+// Tests that estimation requests lacking a price or value, as usually sent by
+// wallets, are estimated as if free instead of crashing the pool, while negative
+// amounts are rejected.
+func TestEstimateGasMissingAmounts(t *testing.T) {
 	t.Parallel()
 
-	// Create a test account and fund it
 	pool, key := setupPool()
 	defer pool.Close()
 
-	account := crypto.PubkeyToAddress(key.PublicKey)
-	testAddBalance(pool, account, big.NewInt(1000000))
+	want, _ := transaction(0, 0, key).IntrinsicGas(pool.chainconfig)
 
-	// Keep track of transaction events to ensure all executables get announced
-	events := make(chan NewTxsEvent, testTxPoolConfig.AccountQueue+5)
-	sub := pool.txFeed.Subscribe(events)
-	defer sub.Unsubscribe()
+	// The sender can afford the value, but not the gas, so only free transfers
+	// can be estimated
+	tx := transaction(0, 0, key)
+	testAddBalance(pool, tx.From, tx.Value)
 
-	// Create a pending and a queued transaction with a nonce-gap in between
-	pool.addRemotesSync([]*types.Transaction{
-		transaction(0, 100000, key),
-		transaction(2, 100000, key),
-	})
-	pending, queued := pool.Stats()
-	if pending != 1 {
-		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 1)
-	}
-	if queued != 1 {
-		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	tx.GasPrice = nil
+	if gas, err := pool.EstimateGas(tx); err != nil || gas != want {
+		t.Errorf("unpriced estimate mismatch: have %d (%v), want %d", gas, err, want)
 	}
-	if err := validateEvents(events, 1); err != nil {
-		t.Fatalf("original event firing failed: %v", err)
+	tx.GasPrice = &gadget.GasPrice{}
+	if gas, err := pool.EstimateGas(tx); err != nil || gas != want {
+		t.Errorf("empty priced estimate mismatch: have %d (%v), want %d", gas, err, want)
 	}
-	if err := validatePoolInternals(pool); err != nil {
-		t.Fatalf("pool internal state corrupted: %v", err)
+	tx.Value = nil
+	if gas, err := pool.EstimateGas(tx); err != nil || gas != want {
+		t.Errorf("valueless estimate mismatch: have %d (%v), want %d", gas, err, want)
 	}
-	// Fill the nonce gap and ensure all transactions become pending
-	if err := pool.addRemoteSync(transaction(1, 100000, key)); err != nil {
-		t.Fatalf("failed to add gapped transaction: %v", err)
+	tx = transaction(0, 0, key)
+	tx.Value = nil
+	if _, err := pool.EstimateGas(tx); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("valueless unfunded estimate error mismatch: have %v, want %v", err, ErrInsufficientFunds)
 	}
-	pending, queued = pool.Stats()
-	if pending != 3 {
-		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 3)
+	tx.Value = big.NewInt(-1)
+	if _, err := pool.EstimateGas(tx); !errors.Is(err, ErrNegativeValue) {
+		t.Errorf("negative value error mismatch: have %v, want %v", err, ErrNegativeValue)
 	}
-	if queued != 0 {
-		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	tx = pricedTransaction(0, 0, big.NewInt(-1), key)
+	if _, err := pool.EstimateGas(tx); !errors.Is(err, ErrNegativeGasPrice) {
+		t.Errorf("negative price error mismatch: have %v, want %v", err, ErrNegativeGasPrice)
 	}
-	if err := validateEvents(events, 2); err != nil {
-		t.Fatalf("gap-filling event firing failed: %v", err)
+}
+
+// executingBlockChain is a test chain running contract code by charging a fixed
+// amount of gas on top of the intrinsic gas, writing a storage slot on success.
+type executingBlockChain struct {
+	*EasyBlockChain
+	gas   uint64 // Gas needed on top of the intrinsic gas for the code to succeed
+	calls int    // Number of executions, checked to be logarithmic in the limit
+}
+
+func (bc *executingBlockChain) Execute(statedb state.StateDB, head types.Header, tx *types.Transaction, gas uint64) (bool, error) {
+	bc.calls++
+	intrGas, err := tx.IntrinsicGas(bc.Config())
+	if err != nil {
+		return false, err
 	}
-	if err := validatePoolInternals(pool); err != nil {
-		t.Fatalf("pool internal state corrupted: %v", err)
+	if gas < intrGas+bc.gas {
+		return true, nil
 	}
+	statedb.SetState(tx.To, common.Hash{}, common.Hash{0x01})
+	return false, nil
 }
 
-// Tests that if the transaction count belonging to a single account goes above
-// some threshold, the higher transactions are dropped to prevent DOS attacks.
-func TestQueueAccountLimiting(t *testing.T) {
+// Tests that calls into code are estimated by binary searching the lowest gas
+// limit their trial execution succeeds with, on copies of the head state.
+func TestEstimateGasExecution(t *testing.T) {
 	t.Parallel()
 
-	// Create a test account and fund it
-	pool, key := setupPool()
+	statedb := state.NewEasyStateDB()
+	blockchain := &executingBlockChain{EasyBlockChain: NewEasyBlockChain(nil, 10000000, statedb, new(event.Feed)), gas: 12345}
+
+	pool := New(testTxPoolConfig, blockchain)
+	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
 	defer pool.Close()
 
-	account := crypto.PubkeyToAddress(key.PublicKey)
-	testAddBalance(pool, account, big.NewInt(1000000))
+	key, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
 
-	// Keep queuing up transactions and make sure all above a limit are dropped
-	for i := uint64(1); i <= testTxPoolConfig.AccountQueue+5; i++ {
-		if err := pool.addRemoteSync(transaction(i, 100000, key)); err != nil {
-			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
-		}
-		if len(pool.pending) != 0 {
-			t.Errorf("tx %d: pending pool size mismatch: have %d, want %d", i, len(pool.pending), 0)
-		}
-		if i <= testTxPoolConfig.AccountQueue {
-			if pool.queue[account].Len() != int(i) {
-				t.Errorf("tx %d: queue size mismatch: have %d, want %d", i, pool.queue[account].Len(), i)
-			}
-		} else {
-			if pool.queue[account].Len() != int(testTxPoolConfig.AccountQueue) {
-				t.Errorf("tx %d: queue limit mismatch: have %d, want %d", i, pool.queue[account].Len(), testTxPoolConfig.AccountQueue)
-			}
-		}
+	tx := transaction(0, 0, key)
+	pool.mu.Lock()
+	pool.currentState.SetCode(tx.To, []byte{0x60, 0x00})
+	pool.mu.Unlock()
+
+	intrGas, _ := tx.IntrinsicGas(pool.chainconfig)
+	if gas, err := pool.EstimateGas(tx); err != nil || gas != intrGas+blockchain.gas {
+		t.Errorf("call estimate mismatch: have %d (%v), want %d", gas, err, intrGas+blockchain.gas)
 	}
-	if pool.all.Count() != int(testTxPoolConfig.AccountQueue) {
-		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), testTxPoolConfig.AccountQueue)
+	if blockchain.calls > 30 {
+		t.Errorf("too many trial executions: have %d, want at most 30", blockchain.calls)
 	}
-}
+	pool.mu.RLock()
+	if slot := pool.currentState.GetState(tx.To, common.Hash{}); slot != (common.Hash{}) {
+		t.Errorf("trial execution leaked into the pool state: have %v", slot)
+	}
+	pool.mu.RUnlock()
 
-// Tests that if the transaction count belonging to multiple accounts go above
-// some threshold, the higher transactions are dropped to prevent DOS attacks.
-//
-// This logic should not hold for local transactions, unless the local tracking
-// mechanism is disabled.
-func TestQueueGlobalLimiting(t *testing.T) {
-	testQueueGlobalLimiting(t, false)
-}
-func TestQueueGlobalLimitingNoLocals(t *testing.T) {
-	testQueueGlobalLimiting(t, true)
+	// A limit below what the code needs can never succeed
+	if _, err := pool.EstimateGas(transaction(0, intrGas+blockchain.gas-1, key)); !errors.Is(err, ErrExecutionFailed) {
+		t.Errorf("capped estimate error mismatch: have %v, want %v", err, ErrExecutionFailed)
+	}
+	// Neither can a limit the sender can't pay for, even if they cover the value
+	poor, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(poor.PublicKey), new(big.Int).SetUint64(intrGas+blockchain.gas-1))
+	call := transaction(0, 0, poor)
+	call.To = tx.To
+	if _, err := pool.EstimateGas(call); !errors.Is(err, ErrExecutionFailed) {
+		t.Errorf("unaffordable estimate error mismatch: have %v, want %v", err, ErrExecutionFailed)
+	}
 }
 
-func testQueueGlobalLimiting(t *testing.T, nolocals bool) {
+// Tests that the pool charges the intrinsic gas of the chain it runs on, not the
+// default schedule.
+func TestChainGasSchedule(t *testing.T) {
 	t.Parallel()
 
-	// Create the pool to test the limit enforcement with
-	statedb := state.NewEasyStateDB()
-	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+	schedule := params.DefaultGasSchedule()
+	schedule.TxGas = 30000
 
-	config := testTxPoolConfig
-	config.NoLocals = nolocals
-	config.GlobalQueue = config.AccountQueue*3 - 1 // reduce the queue limits to shorten test time (-1 to make it non divisible)
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(&params.ChainConfig{Gas: &schedule}, 10000000, statedb, new(event.Feed))
 
-	pool := New(config, blockchain)
+	pool := New(testTxPoolConfig, blockchain)
 	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
 	defer pool.Close()
 
-	// Create a number of test accounts and fund them (last one will be the local)
-	keys := make([]*ecdsa.PrivateKey, 5)
-	for i := 0; i < len(keys); i++ {
-		keys[i], _ = crypto.GenerateKey()
-		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
+	key, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000))
+
+	// Enough gas for the default schedule, but not for the chain's
+	if err := pool.addRemote(transaction(0, 25000, key)); !errors.Is(err, ErrIntrinsicGas) {
+		t.Errorf("underfunded gas error mismatch: have %v, want %v", err, ErrIntrinsicGas)
 	}
-	local := keys[len(keys)-1]
+	if gas, err := pool.EstimateGas(transaction(0, 0, key)); err != nil || gas != 30000 {
+		t.Errorf("estimate mismatch: have %d (%v), want %d", gas, err, 30000)
+	}
+	if err := pool.addRemote(transaction(0, 30000, key)); err != nil {
+		t.Errorf("failed to add transaction covering the chain's intrinsic gas: %v", err)
+	}
+}
 
-	// Generate and queue a batch of transactions
-	nonces := make(map[common.Address]uint64)
+// Tests that transactions with missing signature values are rejected instead of
+// crashing the pool while checking their chain ID.
+func TestIncompleteSignature(t *testing.T) {
+	t.Parallel()
 
-	txs := make(types.Transactions, 0, 3*config.GlobalQueue)
-	for len(txs) < cap(txs) {
-		key := keys[rand.Intn(len(keys)-1)] // skip adding transactions with the local account
-		addr := crypto.PubkeyToAddress(key.PublicKey)
+	pool, key := setupPool()
+	defer pool.Close()
 
-		txs = append(txs, transaction(nonces[addr]+1, 100000, key))
-		nonces[addr]++
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	strip := map[string]func(sig *gadget.Validation){
+		"v": func(sig *gadget.Validation) { sig.V = nil },
+		"r": func(sig *gadget.Validation) { sig.R = nil },
+		"s": func(sig *gadget.Validation) { sig.S = nil },
 	}
-	// Import the batch and verify that limits have been enforced
-	pool.addRemotesSync(txs)
+	for name, strip := range strip {
+		tx := transaction(0, 100000, key)
+		strip(tx.Validation)
+		rehash(tx)
 
-	queued := 0
-	for addr, list := range pool.queue {
-		if list.Len() > int(config.AccountQueue) {
-			t.Errorf("addr %x: queued accounts overflown allowance: %d > %d", addr, list.Len(), config.AccountQueue)
+		if err := pool.addRemote(tx); !errors.Is(err, ErrInvalidSender) {
+			t.Errorf("missing %s: error mismatch: have %v, want %v", name, err, ErrInvalidSender)
 		}
-		queued += list.Len()
-	}
-	if queued > int(config.GlobalQueue) {
-		t.Fatalf("total transactions overflow allowance: %d > %d", queued, config.GlobalQueue)
 	}
-	// Generate a batch of transactions from the local account and import them
-	txs = txs[:0]
-	for i := uint64(0); i < 3*config.GlobalQueue; i++ {
-		txs = append(txs, transaction(i+1, 100000, local))
-	}
-	pool.addLocals(txs)
+}
 
-	// If locals are disabled, the previous eviction algorithm should apply here too
-	if nolocals {
-		queued := 0
-		for addr, list := range pool.queue {
-			if list.Len() > int(config.AccountQueue) {
-				t.Errorf("addr %x: queued accounts overflown allowance: %d > %d", addr, list.Len(), config.AccountQueue)
-			}
-			queued += list.Len()
-		}
-		if queued > int(config.GlobalQueue) {
-			t.Fatalf("total transactions overflow allowance: %d > %d", queued, config.GlobalQueue)
-		}
-	} else {
-		// Local exemptions are enabled, make sure the local account owned the queue
-		if len(pool.queue) != 1 {
-			t.Errorf("multiple accounts in queue: have %v, want %v", len(pool.queue), 1)
-		}
-		// Also ensure no local transactions are ever dropped, even if above global limits
-		if queued := pool.queue[crypto.PubkeyToAddress(local.PublicKey)].Len(); uint64(queued) != 3*config.GlobalQueue {
-			t.Fatalf("local account queued transaction count mismatch: have %v, want %v", queued, 3*config.GlobalQueue)
-		}
+func TestTipAboveFeeCap(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	tx := dynamicFeeTransaction(0, 100, big.NewInt(1), big.NewInt(2), key)
+	if err := pool.addRemote(tx); !errors.Is(err, ErrTipAboveFeeCap) {
+		t.Error("expected", ErrTipAboveFeeCap, "got", err)
 	}
 }
 
-// Tests that if an account remains idle for a prolonged amount of time, any
-// non-executable transactions queued up are dropped to prevent wasting resources
-// on shuffling them around.
-//
-// This logic should not hold for local transactions, unless the local tracking
-// mechanism is disabled.
-func TestQueueTimeLimiting(t *testing.T) {
-	testQueueTimeLimiting(t, false)
+// Tests that dynamic fee transactions are charged at their fee cap and accepted
+// based on their tip.
+func TestDynamicFeeTransaction(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	pool.gasTip.Store(big.NewInt(2))
+
+	tx := dynamicFeeTransaction(0, 100000, big.NewInt(10), big.NewInt(1), key)
+	if err, want := pool.addRemote(tx), ErrUnderpriced; !errors.Is(err, want) {
+		t.Errorf("want %v have %v", want, err)
+	}
+	tx = dynamicFeeTransaction(0, 100000, big.NewInt(10), big.NewInt(2), key)
+	if want := big.NewInt(10*100000 + 100); tx.Cost().Cmp(want) != 0 {
+		t.Fatalf("cost mismatch: have %v, want %v", tx.Cost(), want)
+	}
+	testAddBalance(pool, from, new(big.Int).Sub(tx.Cost(), big.NewInt(1)))
+	if err, want := pool.addRemote(tx), ErrInsufficientFunds; !errors.Is(err, want) {
+		t.Errorf("want %v have %v", want, err)
+	}
+	testAddBalance(pool, from, big.NewInt(1))
+	if err := pool.addRemoteSync(tx); err != nil {
+		t.Fatalf("failed to add dynamic fee transaction: %v", err)
+	}
+	if pending, _ := pool.Stats(); pending != 1 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 1)
+	}
 }
-func TestQueueTimeLimitingNoLocals(t *testing.T) {
-	testQueueTimeLimiting(t, true)
+
+func TestChainFork(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	tx := transaction(0, 100000, key)
+	addr := tx.From
+	resetState := func() {
+		statedb := state.NewEasyStateDB()
+		statedb.AddBalance(addr, big.NewInt(100000000000000))
+
+		pool.chain = NewEasyBlockChain(pool.chainconfig, 1000000, statedb, new(event.Feed))
+		<-pool.requestReset(nil, nil)
+	}
+	resetState()
+
+	if _, err := pool.add(tx, false); err != nil {
+		t.Error("didn't expect error", err)
+	}
+	pool.removeTx(tx.TxHash, true)
+
+	// reset the pool's internal state
+	resetState()
+	if _, err := pool.add(tx, false); err != nil {
+		t.Error("didn't expect error", err)
+	}
 }
 
-func testQueueTimeLimiting(t *testing.T, nolocals bool) {
-	// Reduce the eviction interval to a testable amount
-	defer func(old time.Duration) { evictionInterval = old }(evictionInterval)
-	evictionInterval = time.Millisecond * 100
+// Tests that the transactions of blocks reorged out of the canonical chain are
+// reinjected into the pool, keeping the local flag of their senders.
+func TestReorgReinjection(t *testing.T) {
+	t.Parallel()
 
-	// Create the pool to test the non-expiration enforcement
 	statedb := state.NewEasyStateDB()
 	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
 
-	config := testTxPoolConfig
-	config.Lifetime = time.Second
-	config.NoLocals = nolocals
-
-	pool := New(config, blockchain)
-	pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock())
+	pool := New(testTxPoolConfig, blockchain)
+	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
 	defer pool.Close()
 
-	// Create two test accounts to ensure remotes expire but locals do not
-	local, _ := crypto.GenerateKey()
 	remote, _ := crypto.GenerateKey()
+	local, _ := crypto.GenerateKey()
+	remoteAddr := crypto.PubkeyToAddress(remote.PublicKey)
+	localAddr := crypto.PubkeyToAddress(local.PublicKey)
 
-	testAddBalance(pool, crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
-	testAddBalance(pool, crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+	testAddBalance(pool, remoteAddr, big.NewInt(1000000000))
+	testAddBalance(pool, localAddr, big.NewInt(1000000000))
 
-	// Add the two transactions and ensure they both are queued up
-	if err := pool.addLocal(pricedTransaction(1, 100000, big.NewInt(1), local)); err != nil {
-		t.Fatalf("failed to add local transaction: %v", err)
+	pool.mu.Lock()
+	pool.locals.add(localAddr)
+	pool.mu.Unlock()
+
+	// Build two competing chains on top of a common genesis, the old one including
+	// the transactions and the new one being empty
+	var (
+		rtx0 = transaction(0, 100000, remote)
+		rtx1 = transaction(1, 100000, remote)
+		ltx0 = transaction(0, 100000, local)
+
+		genesis = blockchain.insertBlock(common.Hash{0x00}, common.Hash{}, 0, nil)
+		old1    = blockchain.insertBlock(common.Hash{0xa1}, genesis.Hash(), 1, types.Transactions{rtx0})
+		old2    = blockchain.insertBlock(common.Hash{0xa2}, old1.Hash(), 2, types.Transactions{rtx1, ltx0})
+		new1    = blockchain.insertBlock(common.Hash{0xb1}, genesis.Hash(), 1, nil)
+		new2    = blockchain.insertBlock(common.Hash{0xb2}, new1.Hash(), 2, nil)
+	)
+	// The pool is empty at the old head, with the nonces bumped by its blocks
+	testSetNonce(pool, remoteAddr, 2)
+	testSetNonce(pool, localAddr, 1)
+	<-pool.requestReset(genesis, old2)
+
+	if pending, queued := pool.Stats(); pending != 0 || queued != 0 {
+		t.Fatalf("pool state mismatch before reorg: have %d pending %d queued, want 0 pending 0 queued", pending, queued)
 	}
-	if err := pool.addRemote(pricedTransaction(1, 100000, big.NewInt(1), remote)); err != nil {
-		t.Fatalf("failed to add remote transaction: %v", err)
+	// Reorg to the empty chain, reverting the nonces
+	testSetNonce(pool, remoteAddr, 0)
+	testSetNonce(pool, localAddr, 0)
+	<-pool.requestReset(old2, new2)
+
+	if pending, queued := pool.Stats(); pending != 3 || queued != 0 {
+		t.Fatalf("pool state mismatch after reorg: have %d pending %d queued, want 3 pending 0 queued", pending, queued)
 	}
-	pending, queued := pool.Stats()
-	if pending != 0 {
-		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 0)
+	if pool.all.GetRemote(rtx0.Hash()) == nil || pool.all.GetRemote(rtx1.Hash()) == nil {
+		t.Errorf("remote transactions not reinjected as remotes")
 	}
-	if queued != 2 {
-		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 2)
+	if pool.all.GetLocal(ltx0.Hash()) == nil {
+		t.Errorf("local transaction not reinjected as local")
+	}
+	if nonce := pool.Nonce(remoteAddr); nonce != 2 {
+		t.Errorf("pending nonce mismatch: have %d, want %d", nonce, 2)
 	}
 	if err := validatePoolInternals(pool); err != nil {
 		t.Fatalf("pool internal state corrupted: %v", err)
 	}
+}
 
-	// Allow the eviction interval to run
-	time.Sleep(2 * evictionInterval)
+// Tests that a transaction submitted as local and then mined is reinjected as a
+// local one when its block is reorged out of the canonical chain.
+func TestReorgReinjectionMinedLocal(t *testing.T) {
+	t.Parallel()
 
-	// Transactions should not be evicted from the queue yet since lifetime duration has not passed
-	pending, queued = pool.Stats()
-	if pending != 0 {
-		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 0)
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	pool := New(testTxPoolConfig, blockchain)
+	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
+	defer pool.Close()
+
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, addr, big.NewInt(1000000000))
+
+	tx := transaction(0, 100000, key)
+	if err := pool.addLocal(tx); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
 	}
-	if queued != 2 {
-		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 2)
+	var (
+		genesis = blockchain.insertBlock(common.Hash{0x00}, common.Hash{}, 0, nil)
+		old1    = blockchain.insertBlock(common.Hash{0xa1}, genesis.Hash(), 1, types.Transactions{tx})
+		new1    = blockchain.insertBlock(common.Hash{0xb1}, genesis.Hash(), 1, nil)
+		new2    = blockchain.insertBlock(common.Hash{0xb2}, new1.Hash(), 2, nil)
+	)
+	// Mine the transaction, emptying the account
+	testSetNonce(pool, addr, 1)
+	<-pool.requestReset(genesis, old1)
+
+	if pending, queued := pool.Stats(); pending != 0 || queued != 0 {
+		t.Fatalf("pool state mismatch before reorg: have %d pending %d queued, want 0 pending 0 queued", pending, queued)
+	}
+	// Reorg to the chain without it, which must reinject it as a local
+	testSetNonce(pool, addr, 0)
+	<-pool.requestReset(old1, new2)
+
+	if pending, _ := pool.Stats(); pending != 1 {
+		t.Fatalf("pending transactions mismatched after reorg: have %d, want %d", pending, 1)
+	}
+	if pool.all.GetLocal(tx.Hash()) == nil {
+		t.Errorf("mined local transaction not reinjected as local")
 	}
 	if err := validatePoolInternals(pool); err != nil {
 		t.Fatalf("pool internal state corrupted: %v", err)
 	}
+}
 
-	// Wait a bit for eviction to run and clean up any leftovers, and ensure only the local remains
-	time.Sleep(2 * config.Lifetime)
+// Tests that the pool follows the chain on its own, resetting onto every head
+// announced through the chain head subscription.
+func TestChainHeadReset(t *testing.T) {
+	t.Parallel()
 
-	pending, queued = pool.Stats()
-	if pending != 0 {
-		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 0)
+	var (
+		feed       = new(event.Feed)
+		statedb    = state.NewEasyStateDB()
+		blockchain = NewEasyBlockChain(nil, 10000000, statedb, feed)
+	)
+	pool := New(testTxPoolConfig, blockchain)
+	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
+	<-pool.initDoneCh
+	defer pool.Close()
+
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, from, big.NewInt(1000000000))
+
+	txs := types.Transactions{
+		transaction(0, 100000, key),
+		transaction(1, 100000, key),
+		transaction(2, 100000, key),
 	}
-	if nolocals {
-		if queued != 0 {
-			t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	for i, err := range pool.addRemotesSync(txs) {
+		if err != nil {
+			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
 		}
-	} else {
-		if queued != 1 {
-			t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	// Mine the first two transactions and announce the block as the new head
+	// without ever resetting the pool by hand
+	head := blockchain.insertBlock(common.Hash{0x01}, blockchain.CurrentBlock().Hash(), 1, txs[:2])
+	testSetNonce(pool, from, 2)
+	feed.Send(ChainHeadEvent{Block: blockchain.GetBlock(head.Hash(), 1)})
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if pending, _ := pool.Stats(); pending == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			pending, queued := pool.Stats()
+			t.Fatalf("pool not reset onto new head: have %d pending %d queued, want 1 pending 0 queued", pending, queued)
 		}
 	}
+	if head := *pool.currentHead.Load(); head.Hash() != (common.Hash{0x01}) {
+		t.Errorf("pool head mismatch: have %x, want %x", head.Hash(), common.Hash{0x01})
+	}
+	if status := pool.Status([]common.Hash{txs[0].Hash()}); status[0] != TxStatusIncluded {
+		t.Errorf("mined transaction status mismatch: have %d, want %d", status[0], TxStatusIncluded)
+	}
 	if err := validatePoolInternals(pool); err != nil {
 		t.Fatalf("pool internal state corrupted: %v", err)
 	}
+}
+
+func TestDoubleNonce(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	tx := transaction(0, 100000, key)
+	addr := tx.From
+
+	resetState := func() {
+		statedb := state.NewEasyStateDB()
+		statedb.AddBalance(addr, big.NewInt(100000000000000))
+
+		pool.chain = NewEasyBlockChain(pool.chainconfig, 1000000, statedb, new(event.Feed))
+		<-pool.requestReset(nil, nil)
+	}
+	resetState()
+
+	gp1 := gadget.NewGasPrice(big.NewInt(1))
+	gp2 := gadget.NewGasPrice(big.NewInt(2))
+	tx1 := types.NewNormalTransaction(0, common.Address{}, big.NewInt(100), 100000, gp1, nil, key)
+	tx2 := types.NewNormalTransaction(0, common.Address{}, big.NewInt(100), 1000000, gp2, nil, key)
+	tx3 := types.NewNormalTransaction(0, common.Address{}, big.NewInt(100), 1000000, gp1, nil, key)
+
+	// Add the first two transaction, ensure higher priced stays only
+	if replace, err := pool.add(tx1, false); err != nil || replace {
+		t.Errorf("first transaction insert failed (%v) or reported replacement (%v)", err, replace)
+	}
+	if replace, err := pool.add(tx2, false); err != nil || !replace {
+		t.Errorf("second transaction insert failed (%v) or not reported replacement (%v)", err, replace)
+	}
+	<-pool.requestPromoteExecutables(newAccountSet(addr))
+	if pool.pending[addr].Len() != 1 {
+		t.Error("expected 1 pending transactions, got", pool.pending[addr].Len())
+	}
+	if tx := pool.pending[addr].txs.items[0]; tx.TxHash != tx2.TxHash {
+		t.Errorf("transaction mismatch: have %x, want %x", tx.TxHash, tx2.TxHash)
+	}
+
+	// Add the third transaction and ensure it's not saved (smaller price)
+	pool.add(tx3, false)
+	<-pool.requestPromoteExecutables(newAccountSet(addr))
+	if pool.pending[addr].Len() != 1 {
+		t.Error("expected 1 pending transactions, got", pool.pending[addr].Len())
+	}
+	if tx := pool.pending[addr].txs.items[0]; tx.TxHash != tx2.TxHash {
+		t.Errorf("transaction mismatch: have %x, want %x", tx.TxHash, tx2.TxHash)
+	}
+	// Ensure the total transaction count is correct
+	if pool.all.Count() != 1 {
+		t.Error("expected 1 total transactions, got", pool.all.Count())
+	}
+}
+
+func TestMissingNonce(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	tx := transaction(1, 100000, key)
+	addr := tx.From
+	testAddBalance(pool, addr, big.NewInt(100000000000000))
+	if _, err := pool.add(tx, false); err != nil {
+		t.Error("didn't expect error", err)
+	}
+	if len(pool.pending) != 0 {
+		t.Error("expected 0 pending transactions, got", len(pool.pending))
+	}
+	if pool.queue[addr].Len() != 1 {
+		t.Error("expected 1 queued transaction, got", pool.queue[addr].Len())
+	}
+	if pool.all.Count() != 1 {
+		t.Error("expected 1 total transactions, got", pool.all.Count())
+	}
+}
+
+func TestNonceRecovery(t *testing.T) {
+	t.Parallel()
+
+	const n = 10
+	pool, key := setupPool()
+	defer pool.Close()
+	tx := transaction(n, 100000, key)
+	addr := tx.From
+
+	testSetNonce(pool, addr, n)
+	testAddBalance(pool, addr, big.NewInt(100000000000000))
+	<-pool.requestReset(nil, nil)
+
+	if err := pool.addRemote(tx); err != nil {
+		t.Error(err)
+	}
+	// simulate some weird re-order of transactions and missing nonce(s)
+	testSetNonce(pool, addr, n-1)
+	<-pool.requestReset(nil, nil)
+	if fn := pool.Nonce(addr); fn != n-1 {
+		t.Errorf("expected nonce to be %d, got %d", n-1, fn)
+	}
+}
+
+func TestDropping(t *testing.T) {
+	t.Parallel()
+
+	// Create a test account and fund it
+	pool, key := setupPool()
+	defer pool.Close()
+
+	tx := transaction(0, 100000, key)
+	account := tx.From
+	testAddBalance(pool, account, big.NewInt(1000))
+
+	// Add some pending and some queued transactions
+	var (
+		tx0  = transaction(0, 100, key)
+		tx1  = transaction(1, 200, key)
+		tx2  = transaction(2, 300, key)
+		tx10 = transaction(10, 100, key)
+		tx11 = transaction(11, 200, key)
+		tx12 = transaction(12, 300, key)
+	)
+	pool.all.Add(tx0, false)
+	pool.priced.Put(tx0, false)
+	pool.promoteTx(account, tx0.TxHash, tx0)
+
+	pool.all.Add(tx1, false)
+	pool.priced.Put(tx1, false)
+	pool.promoteTx(account, tx1.TxHash, tx1)
+
+	pool.all.Add(tx2, false)
+	pool.priced.Put(tx2, false)
+	pool.promoteTx(account, tx2.TxHash, tx2)
+
+	pool.enqueueTx(tx10.TxHash, tx10, false, true)
+	pool.enqueueTx(tx11.TxHash, tx11, false, true)
+	pool.enqueueTx(tx12.TxHash, tx12, false, true)
+
+	// Check that pre and post validations leave the pool as is
+	if pool.pending[account].Len() != 3 {
+		t.Errorf("pending transaction mismatch: have %d, want %d", pool.pending[account].Len(), 3)
+	}
+	if pool.queue[account].Len() != 3 {
+		t.Errorf("queued transaction mismatch: have %d, want %d", pool.queue[account].Len(), 3)
+	}
+	if pool.all.Count() != 6 {
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 6)
+	}
+
+	<-pool.requestReset(nil, nil)
+	if pool.pending[account].Len() != 3 {
+		t.Errorf("pending transaction mismatch: have %d, want %d", pool.pending[account].Len(), 3)
+	}
+	if pool.queue[account].Len() != 3 {
+		t.Errorf("queued transaction mismatch: have %d, want %d", pool.queue[account].Len(), 3)
+	}
+	if pool.all.Count() != 6 {
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 6)
+	}
+
+	// Reduce the balance of the account, and check that invalidated transactions are dropped
+	testAddBalance(pool, account, big.NewInt(-650))
+	<-pool.requestReset(nil, nil)
+
+	if _, ok := pool.pending[account].txs.items[tx0.Nonce]; !ok {
+		t.Errorf("funded pending transaction missing: %v", tx0)
+	}
+	if _, ok := pool.pending[account].txs.items[tx1.Nonce]; !ok {
+		t.Errorf("funded pending transaction missing: %v", tx0)
+	}
+	if _, ok := pool.pending[account].txs.items[tx2.Nonce]; ok {
+		t.Errorf("out-of-fund pending transaction present: %v", tx1)
+	}
+	if _, ok := pool.queue[account].txs.items[tx10.Nonce]; !ok {
+		t.Errorf("funded queued transaction missing: %v", tx10)
+	}
+	if _, ok := pool.queue[account].txs.items[tx11.Nonce]; !ok {
+		t.Errorf("funded queued transaction missing: %v", tx10)
+	}
+	if _, ok := pool.queue[account].txs.items[tx12.Nonce]; ok {
+		t.Errorf("out-of-fund queued transaction present: %v", tx11)
+	}
+	if pool.all.Count() != 4 {
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 4)
+	}
+
+	// Reduce the block gas limit, check that invalidated transactions are dropped
+	pool.chain.(*EasyBlockChain).gasLimit.Store(100)
+	<-pool.requestReset(nil, nil)
+
+	if _, ok := pool.pending[account].txs.items[tx0.Nonce]; !ok {
+		t.Errorf("funded pending transaction missing: %v", tx0)
+	}
+	if _, ok := pool.pending[account].txs.items[tx1.Nonce]; ok {
+		t.Errorf("over-gased pending transaction present: %v", tx1)
+	}
+	if _, ok := pool.queue[account].txs.items[tx10.Nonce]; !ok {
+		t.Errorf("funded queued transaction missing: %v", tx10)
+	}
+	if _, ok := pool.queue[account].txs.items[tx11.Nonce]; ok {
+		t.Errorf("over-gased queued transaction present: %v", tx11)
+	}
+	if pool.all.Count() != 2 {
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 2)
+	}
+}
+
+func TestPostponing(t *testing.T) {
+	t.Parallel()
+
+	// Create the pool to test the postponing with
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	pool := New(testTxPoolConfig, blockchain)
+	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
+	defer pool.Close()
+
+	// Create two test accounts to produce different gap profiles with
+	keys := make([]*ecdsa.PrivateKey, 2)
+	accs := make([]common.Address, len(keys))
+
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		accs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+		testAddBalance(pool, accs[i], big.NewInt(50100))
+	}
+	// Add a batch consecutive pending transactions for validation
+	txs := []*types.Transaction{}
+	for i, key := range keys {
+		for j := 0; j < 100; j++ {
+			var tx *types.Transaction
+			if (i+j)%2 == 0 {
+				tx = transaction(uint64(j), 25000, key)
+			} else {
+				tx = transaction(uint64(j), 50000, key)
+			}
+			txs = append(txs, tx)
+		}
+	}
+	for i, err := range pool.addRemotesSync(txs) {
+		if err != nil {
+			t.Fatalf("tx %d: failed to add transactions: %v", i, err)
+		}
+	}
+	// Check that pre and post validations leave the pool as is
+	if pending := pool.pending[accs[0]].Len() + pool.pending[accs[1]].Len(); pending != 2 {
+		t.Errorf("pending transaction mismatch: have %d, want %d", pending, 4)
+	}
+	if queue := pool.queue[accs[0]].Len() + pool.queue[accs[1]].Len(); queue != 128 {
+		t.Errorf("queued transaction mismatch: have %d, want %d", queue, 128)
+	}
+	if pool.all.Count() != 130 { // discard 70 = 35 * 2, and 2 are going to pending, 128 to queue
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 130)
+	}
+
+	<-pool.requestReset(nil, nil)
+
+	if pending := pool.pending[accs[0]].Len() + pool.pending[accs[1]].Len(); pending != 2 {
+		t.Errorf("pending transaction mismatch: have %d, want %d", pending, 4)
+	}
+	if queue := pool.queue[accs[0]].Len() + pool.queue[accs[1]].Len(); queue != 128 {
+		t.Errorf("queued transaction mismatch: have %d, want %d", queue, 128)
+	}
+	if pool.all.Count() != 130 { // discard 70 = 35 * 2, and 2 are going to pending, 128 to queue
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 130)
+	}
+
+	// Reduce the balance of the account, and check that transactions are reorganised
+	for _, addr := range accs {
+		testAddBalance(pool, addr, big.NewInt(-1))
+	}
+	<-pool.requestReset(nil, nil)
+
+	// The first account's first transaction remains valid, check that subsequent
+	// ones are either filtered out, or queued up for later.
+	if _, ok := pool.pending[accs[0]].txs.items[txs[0].Nonce]; !ok {
+		t.Errorf("tx %d: valid and funded transaction missing from pending pool: %v", 0, txs[0])
+	}
+	// the second account's first transaction is invalid.
+	if _, ok := pool.queue[accs[1]].txs.items[txs[100].Nonce]; ok {
+		t.Errorf("tx %d: out-of-fund transaction present in future queue: %v", 100, txs[100])
+	}
+
+	// every promoteExecutable and demoteUnexcutable function will filter pending and queue with Balance
+	// there are lots of transactions discarded
+	for i, tx := range txs[1:100] {
+		if tx.Nonce >= 65 {
+			continue
+		}
+		if (i+1)%2 == 1 { //cost 50100, which is insufficient
+			if _, ok := pool.queue[accs[0]].txs.items[tx.Nonce]; ok {
+				t.Errorf("tx %d: out-of-fund transaction present in future queue: %v", i+1, tx)
+			}
+		} else { // cost 25100, which is sufficient
+			if _, ok := pool.queue[accs[0]].txs.items[tx.Nonce]; !ok {
+				t.Errorf("tx %d: valid but future transaction missing from future queue: %v", i+1, tx)
+			}
+		}
+	}
+	// The second account's first transaction got invalid, check that all transactions
+	// are either filtered out, or queued up for later.
+	if pool.pending[accs[1]] != nil {
+		t.Errorf("invalidated account still has pending transactions")
+	}
+
+	for i, tx := range txs[101:] {
+		if tx.Nonce >= 65 {
+			continue
+		}
+		if (i+101)%2 == 1 { //cost 50100, which is insufficient
+			if _, ok := pool.queue[accs[0]].txs.items[tx.Nonce]; ok {
+				t.Errorf("tx %d: out-of-fund transaction present in future queue: %v", i+101, tx)
+			}
+		} else { // cost 25100, which is sufficient
+			if _, ok := pool.queue[accs[0]].txs.items[tx.Nonce]; !ok {
+				t.Errorf("tx %d: valid but future transaction missing from future queue: %v", i+101, tx)
+			}
+		}
+	}
+	// half in the queue will be dsiacrded because of insufficient balance
+	if pool.all.Count() != 65 {
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), 65)
+	}
+}
+
+// Tests that if the transaction pool has both executable and non-executable
+// transactions from an origin account, filling the nonce gap moves all queued
+// ones into the pending pool.
+func TestGapFilling(t *testing.T) {
+	t.Parallel()
+
+	// Create a test account and fund it
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, big.NewInt(1000000))
+
+	// Keep track of transaction events to ensure all executables get announced
+	events := make(chan NewTxsEvent, testTxPoolConfig.AccountQueue+5)
+	sub := pool.txFeed.Subscribe(events)
+	defer sub.Unsubscribe()
+
+	// Create a pending and a queued transaction with a nonce-gap in between
+	pool.addRemotesSync([]*types.Transaction{
+		transaction(0, 100000, key),
+		transaction(2, 100000, key),
+	})
+	pending, queued := pool.Stats()
+	if pending != 1 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 1)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	if err := validateEvents(events, 1); err != nil {
+		t.Fatalf("original event firing failed: %v", err)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	// Fill the nonce gap and ensure all transactions become pending
+	if err := pool.addRemoteSync(transaction(1, 100000, key)); err != nil {
+		t.Fatalf("failed to add gapped transaction: %v", err)
+	}
+	pending, queued = pool.Stats()
+	if pending != 3 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 3)
+	}
+	if queued != 0 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	}
+	if err := validateEvents(events, 2); err != nil {
+		t.Fatalf("gap-filling event firing failed: %v", err)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that if the transaction count belonging to a single account goes above
+// some threshold, the higher transactions are dropped to prevent DOS attacks.
+func TestQueueAccountLimiting(t *testing.T) {
+	t.Parallel()
+
+	// Create a test account and fund it
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, big.NewInt(1000000))
+
+	// Keep queuing up transactions and make sure all above a limit are dropped
+	for i := uint64(1); i <= testTxPoolConfig.AccountQueue+5; i++ {
+		if err := pool.addRemoteSync(transaction(i, 100000, key)); err != nil {
+			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
+		}
+		if len(pool.pending) != 0 {
+			t.Errorf("tx %d: pending pool size mismatch: have %d, want %d", i, len(pool.pending), 0)
+		}
+		if i <= testTxPoolConfig.AccountQueue {
+			if pool.queue[account].Len() != int(i) {
+				t.Errorf("tx %d: queue size mismatch: have %d, want %d", i, pool.queue[account].Len(), i)
+			}
+		} else {
+			if pool.queue[account].Len() != int(testTxPoolConfig.AccountQueue) {
+				t.Errorf("tx %d: queue limit mismatch: have %d, want %d", i, pool.queue[account].Len(), testTxPoolConfig.AccountQueue)
+			}
+		}
+	}
+	if pool.all.Count() != int(testTxPoolConfig.AccountQueue) {
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), testTxPoolConfig.AccountQueue)
+	}
+}
+
+// Tests that a single batch of gapped transactions from a remote account is capped
+// to the account queue limit, dropping the highest nonces, while local accounts
+// are exempt from the limit.
+func TestQueueAccountLimitingBatch(t *testing.T) {
+	t.Parallel()
+
+	pool, remote := setupPool()
+	defer pool.Close()
+
+	local, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+	testAddBalance(pool, crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
+
+	var remotes, locals types.Transactions
+	for i := uint64(1); i <= 100; i++ {
+		remotes = append(remotes, transaction(i, 100000, remote))
+		locals = append(locals, transaction(i, 100000, local))
+	}
+	pool.addRemotesSync(remotes)
+	pool.addLocals(locals)
+
+	queue := pool.queue[crypto.PubkeyToAddress(remote.PublicKey)]
+	if queue.Len() != int(testTxPoolConfig.AccountQueue) {
+		t.Fatalf("remote queue size mismatch: have %d, want %d", queue.Len(), testTxPoolConfig.AccountQueue)
+	}
+	for i, tx := range queue.Flatten() {
+		if want := uint64(i + 1); tx.Nonce != want {
+			t.Fatalf("remote queued transaction %d nonce mismatch: have %d, want %d", i, tx.Nonce, want)
+		}
+	}
+	if have := pool.queue[crypto.PubkeyToAddress(local.PublicKey)].Len(); have != 100 {
+		t.Fatalf("local queue size mismatch: have %d, want %d", have, 100)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that truncating the queue returns exactly the transactions it dropped,
+// taking the highest nonces from the least recently active account first.
+func TestTruncateQueueDropped(t *testing.T) {
+	t.Parallel()
+
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.GlobalQueue = 4
+
+	pool := New(config, blockchain)
+	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
+	defer pool.Close()
+
+	old, _ := crypto.GenerateKey()
+	recent, _ := crypto.GenerateKey()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for i := uint64(1); i <= 3; i++ {
+		tx := transaction(i, 100000, old)
+		pool.enqueueTx(tx.TxHash, tx, false, true)
+	}
+	pool.beats[crypto.PubkeyToAddress(old.PublicKey)] = time.Now().Add(-time.Hour)
+	for i := uint64(1); i <= 3; i++ {
+		tx := transaction(i, 100000, recent)
+		pool.enqueueTx(tx.TxHash, tx, false, true)
+	}
+	dropped := pool.truncateQueue()
+	if len(dropped) != 2 {
+		t.Fatalf("dropped transaction count mismatch: have %d, want %d", len(dropped), 2)
+	}
+	for i, tx := range dropped {
+		if tx.From != crypto.PubkeyToAddress(old.PublicKey) || tx.Nonce != uint64(3-i) {
+			t.Errorf("dropped transaction %d mismatch: from %v nonce %d", i, tx.From, tx.Nonce)
+		}
+		if pool.all.Get(tx.TxHash) != nil {
+			t.Errorf("dropped transaction %d still tracked", i)
+		}
+	}
+	if dropped := pool.truncateQueue(); dropped != nil {
+		t.Fatalf("truncating a queue within limits dropped %d transactions", len(dropped))
+	}
+}
+
+// Tests that demoting pending transactions lowers the account's virtual nonce to
+// the first gap, so the next transaction can fill it instead of getting stuck.
+func TestDemotionLowersPendingNonce(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, big.NewInt(1000000000))
+
+	txs := types.Transactions{
+		pricedTransaction(0, 100000, big.NewInt(1), key),
+		pricedTransaction(1, 100000, big.NewInt(100), key),
+		pricedTransaction(2, 100000, big.NewInt(1), key),
+	}
+	for i, err := range pool.addRemotesSync(txs) {
+		if err != nil {
+			t.Fatalf("failed to add transaction %d: %v", i, err)
+		}
+	}
+	if nonce := pool.Nonce(account); nonce != 3 {
+		t.Fatalf("pending nonce mismatch: have %d, want %d", nonce, 3)
+	}
+	// Make the middle transaction unaffordable and demote without a full reset
+	pool.mu.Lock()
+	pool.currentState.SetBalance(account, big.NewInt(1000000))
+	pool.demoteUnexecutables()
+	pool.mu.Unlock()
+
+	if nonce := pool.Nonce(account); nonce != 1 {
+		t.Fatalf("pending nonce mismatch after demotion: have %d, want %d", nonce, 1)
+	}
+	if pending, queued := pool.Stats(); pending != 1 || queued != 1 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 1 pending 1 queued", pending, queued)
+	}
+	if pool.queue[account] == nil || !pool.queue[account].Contains(2) {
+		t.Fatalf("higher nonce transaction not demoted to the queue")
+	}
+}
+
+// Tests that explicitly removing a pending transaction drops it from the pool and
+// demotes the higher nonce transactions of the account back into the queue.
+func TestRemoveTx(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, big.NewInt(1000000000))
+
+	txs := types.Transactions{
+		transaction(0, 100000, key),
+		transaction(1, 100000, key),
+		transaction(2, 100000, key),
+		transaction(4, 100000, key),
+	}
+	for i, err := range pool.addRemotesSync(txs) {
+		if err != nil {
+			t.Fatalf("failed to add transaction %d: %v", i, err)
+		}
+	}
+	if removed := pool.RemoveTx(common.Hash{0xff}, true); removed != 0 {
+		t.Fatalf("removed unknown transaction: have %d, want %d", removed, 0)
+	}
+	if removed := pool.RemoveTx(txs[1].Hash(), true); removed != 2 {
+		t.Fatalf("removed count mismatch: have %d, want %d", removed, 2)
+	}
+	if pool.Has(txs[1].Hash()) {
+		t.Fatalf("removed transaction still in the pool")
+	}
+	if pending, queued := pool.Stats(); pending != 1 || queued != 2 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 1 pending 2 queued", pending, queued)
+	}
+	if nonce := pool.Nonce(account); nonce != 1 {
+		t.Fatalf("pending nonce mismatch: have %d, want %d", nonce, 1)
+	}
+	// Removing a queued transaction doesn't touch the pending set
+	if removed := pool.RemoveTx(txs[3].Hash(), true); removed != 0 {
+		t.Fatalf("removed count mismatch: have %d, want %d", removed, 0)
+	}
+	if pending, queued := pool.Stats(); pending != 1 || queued != 1 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 1 pending 1 queued", pending, queued)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that if the transaction count belonging to multiple accounts go above
+// some threshold, the higher transactions are dropped to prevent DOS attacks.
+//
+// This logic should not hold for local transactions, unless the local tracking
+// mechanism is disabled.
+func TestQueueGlobalLimiting(t *testing.T) {
+	testQueueGlobalLimiting(t, false)
+}
+func TestQueueGlobalLimitingNoLocals(t *testing.T) {
+	testQueueGlobalLimiting(t, true)
+}
+
+func testQueueGlobalLimiting(t *testing.T, nolocals bool) {
+	t.Parallel()
+
+	// Create the pool to test the limit enforcement with
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.NoLocals = nolocals
+	config.GlobalQueue = config.AccountQueue*3 - 1 // reduce the queue limits to shorten test time (-1 to make it non divisible)
+
+	pool := New(config, blockchain)
+	pool.Init(new(big.Int).SetUint64(testTxPoolConfig.PriceLimit), blockchain.CurrentBlock())
+	defer pool.Close()
+
+	// Create a number of test accounts and fund them (last one will be the local)
+	keys := make([]*ecdsa.PrivateKey, 5)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
+	}
+	local := keys[len(keys)-1]
+
+	// Generate and queue a batch of transactions
+	nonces := make(map[common.Address]uint64)
+
+	txs := make(types.Transactions, 0, 3*config.GlobalQueue)
+	for len(txs) < cap(txs) {
+		key := keys[rand.Intn(len(keys)-1)] // skip adding transactions with the local account
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+
+		txs = append(txs, transaction(nonces[addr]+1, 100000, key))
+		nonces[addr]++
+	}
+	// Import the batch and verify that limits have been enforced
+	pool.addRemotesSync(txs)
+
+	queued := 0
+	for addr, list := range pool.queue {
+		if list.Len() > int(config.AccountQueue) {
+			t.Errorf("addr %x: queued accounts overflown allowance: %d > %d", addr, list.Len(), config.AccountQueue)
+		}
+		queued += list.Len()
+	}
+	if queued > int(config.GlobalQueue) {
+		t.Fatalf("total transactions overflow allowance: %d > %d", queued, config.GlobalQueue)
+	}
+	// Generate a batch of transactions from the local account and import them
+	txs = txs[:0]
+	for i := uint64(0); i < 3*config.GlobalQueue; i++ {
+		txs = append(txs, transaction(i+1, 100000, local))
+	}
+	pool.addLocals(txs)
+
+	// If locals are disabled, the previous eviction algorithm should apply here too
+	if nolocals {
+		queued := 0
+		for addr, list := range pool.queue {
+			if list.Len() > int(config.AccountQueue) {
+				t.Errorf("addr %x: queued accounts overflown allowance: %d > %d", addr, list.Len(), config.AccountQueue)
+			}
+			queued += list.Len()
+		}
+		if queued > int(config.GlobalQueue) {
+			t.Fatalf("total transactions overflow allowance: %d > %d", queued, config.GlobalQueue)
+		}
+	} else {
+		// Local exemptions are enabled, make sure the local account owned the queue
+		if len(pool.queue) != 1 {
+			t.Errorf("multiple accounts in queue: have %v, want %v", len(pool.queue), 1)
+		}
+		// Also ensure no local transactions are ever dropped, even if above global limits
+		if queued := pool.queue[crypto.PubkeyToAddress(local.PublicKey)].Len(); uint64(queued) != 3*config.GlobalQueue {
+			t.Fatalf("local account queued transaction count mismatch: have %v, want %v", queued, 3*config.GlobalQueue)
+		}
+	}
+}
+
+// Tests that if an account remains idle for a prolonged amount of time, any
+// non-executable transactions queued up are dropped to prevent wasting resources
+// on shuffling them around.
+//
+// This logic should not hold for local transactions, unless the local tracking
+// mechanism is disabled.
+func TestQueueTimeLimiting(t *testing.T) {
+	testQueueTimeLimiting(t, false)
+}
+func TestQueueTimeLimitingNoLocals(t *testing.T) {
+	testQueueTimeLimiting(t, true)
+}
+
+func testQueueTimeLimiting(t *testing.T, nolocals bool) {
+	// Reduce the eviction interval to a testable amount
+	defer func(old time.Duration) { evictionInterval = old }(evictionInterval)
+	evictionInterval = time.Millisecond * 100
+
+	// Create the pool to test the non-expiration enforcement
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.Lifetime = time.Second
+	config.NoLocals = nolocals
+
+	pool := New(config, blockchain)
+	pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock())
+	defer pool.Close()
+
+	// Create two test accounts to ensure remotes expire but locals do not
+	local, _ := crypto.GenerateKey()
+	remote, _ := crypto.GenerateKey()
+
+	testAddBalance(pool, crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
+	testAddBalance(pool, crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+
+	// Add the two transactions and ensure they both are queued up
+	if err := pool.addLocal(pricedTransaction(1, 100000, big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	if err := pool.addRemote(pricedTransaction(1, 100000, big.NewInt(1), remote)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	pending, queued := pool.Stats()
+	if pending != 0 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 0)
+	}
+	if queued != 2 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 2)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+
+	// Allow the eviction interval to run
+	time.Sleep(2 * evictionInterval)
+
+	// Transactions should not be evicted from the queue yet since lifetime duration has not passed
+	pending, queued = pool.Stats()
+	if pending != 0 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 0)
+	}
+	if queued != 2 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 2)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+
+	// Wait a bit for eviction to run and clean up any leftovers, and ensure only the local remains
+	time.Sleep(2 * config.Lifetime)
+
+	pending, queued = pool.Stats()
+	if pending != 0 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 0)
+	}
+	if nolocals {
+		if queued != 0 {
+			t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+		}
+	} else {
+		if queued != 1 {
+			t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+		}
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+
+	// remove current transactions and increase nonce to prepare for a reset and cleanup
+	statedb.SetNonce(crypto.PubkeyToAddress(remote.PublicKey), 2)
+	statedb.SetNonce(crypto.PubkeyToAddress(local.PublicKey), 2)
+	<-pool.requestReset(nil, nil)
+
+	// make sure queue, pending are cleared
+	pending, queued = pool.Stats()
+	if pending != 0 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 0)
+	}
+	if queued != 0 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+
+	// Queue gapped transactions
+	if err := pool.addLocal(pricedTransaction(4, 100000, big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	if err := pool.addRemoteSync(pricedTransaction(4, 100000, big.NewInt(1), remote)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	time.Sleep(5 * evictionInterval) // A half lifetime pass
+
+	// Queue executable transactions, the life cycle should be restarted.
+	if err := pool.addLocal(pricedTransaction(2, 100000, big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	if err := pool.addRemoteSync(pricedTransaction(2, 100000, big.NewInt(1), remote)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	time.Sleep(6 * evictionInterval)
+
+	// All gapped transactions shouldn't be kicked out
+	pending, queued = pool.Stats()
+	if pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	if queued != 2 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 3)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+
+	// The whole life time pass after last promotion, kick out stale transactions
+	time.Sleep(2 * config.Lifetime)
+	pending, queued = pool.Stats()
+	if pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	if nolocals {
+		if queued != 0 {
+			t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+		}
+	} else {
+		if queued != 1 {
+			t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+		}
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that even if the transaction count belonging to a single account goes
+// above some threshold, as long as the transactions are executable, they are
+// accepted.
+func TestPendingLimiting(t *testing.T) {
+	t.Parallel()
+
+	// Create a test account and fund it
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, big.NewInt(1000000000000))
+
+	// Keep track of transaction events to ensure all executables get announced
+	events := make(chan NewTxsEvent, testTxPoolConfig.AccountQueue+5)
+	sub := pool.txFeed.Subscribe(events)
+	defer sub.Unsubscribe()
+
+	// Keep queuing up transactions and make sure all above a limit are dropped
+	for i := uint64(0); i < testTxPoolConfig.AccountQueue+5; i++ {
+		if err := pool.addRemoteSync(transaction(i, 100000, key)); err != nil {
+			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
+		}
+		if pool.pending[account].Len() != int(i)+1 {
+			t.Errorf("tx %d: pending pool size mismatch: have %d, want %d", i, pool.pending[account].Len(), i+1)
+		}
+		if len(pool.queue) != 0 {
+			t.Errorf("tx %d: queue size mismatch: have %d, want %d", i, pool.queue[account].Len(), 0)
+		}
+	}
+	if pool.all.Count() != int(testTxPoolConfig.AccountQueue+5) {
+		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), testTxPoolConfig.AccountQueue+5)
+	}
+	if err := validateEvents(events, int(testTxPoolConfig.AccountQueue+5)); err != nil {
+		t.Fatalf("event firing failed: %v", err)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that if the transaction count belonging to multiple accounts go above
+// some hard threshold, the higher transactions are dropped to prevent DOS
+// attacks.
+func TestPendingGlobalLimiting(t *testing.T) {
+	t.Parallel()
+
+	// Create the pool to test the limit enforcement with
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.GlobalSlots = config.AccountSlots * 10
+
+	pool := New(config, blockchain)
+	pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock())
+	defer pool.Close()
+
+	// Create a number of test accounts and fund them
+	keys := make([]*ecdsa.PrivateKey, 5)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
+	}
+	// Generate and queue a batch of transactions
+	nonces := make(map[common.Address]uint64)
+
+	txs := types.Transactions{}
+	for _, key := range keys {
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		for j := 0; j < int(config.GlobalSlots)/len(keys)*2; j++ {
+			txs = append(txs, transaction(nonces[addr], 100000, key))
+			nonces[addr]++
+		}
+	}
+	// Import the batch and verify that limits have been enforced
+	pool.addRemotesSync(txs)
+
+	pending := 0
+	for _, list := range pool.pending {
+		pending += list.Len()
+	}
+	if pending > int(config.GlobalSlots) {
+		t.Fatalf("total pending transactions overflow allowance: %d > %d", pending, config.GlobalSlots)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that a context bound add rejects the transactions it couldn't insert in
+// time with the context error, and releases the pool lock it gave up on.
+func TestAddCtx(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+	txs := types.Transactions{transaction(0, 100000, key), transaction(1, 100000, key)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, err := range pool.AddCtx(ctx, txs, false) {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("tx %d: cancelled add error mismatch: have %v, want %v", i, err, context.Canceled)
+		}
+	}
+	// Hold the pool lock and ensure the add times out waiting for it
+	pool.mu.Lock()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	for i, err := range pool.AddCtx(ctx, txs, false) {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("tx %d: blocked add error mismatch: have %v, want %v", i, err, context.DeadlineExceeded)
+		}
+	}
+	pool.mu.Unlock()
+
+	if pending, queued := pool.Stats(); pending != 0 || queued != 0 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 0 pending 0 queued", pending, queued)
+	}
+	for i, err := range pool.AddCtx(context.Background(), txs, false) {
+		if err != nil {
+			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
+		}
+	}
+	if pending, _ := pool.Stats(); pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that re-adding recently mined transactions is rejected as already known
+// without being validated again, unless the transactions were reorged out.
+func TestMinedCache(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, big.NewInt(1000000000))
+
+	tx := transaction(0, 100000, key)
+	if err := pool.addRemoteSync(tx); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	testSetNonce(pool, account, 1)
+	<-pool.requestReset(nil, nil)
+
+	if err := pool.addRemote(tx.Copy()); !errors.Is(err, ErrAlreadyKnown) {
+		t.Fatalf("mined transaction re-add error mismatch: have %v, want %v", err, ErrAlreadyKnown)
+	}
+	// Reinjecting the transaction, as after a reorg, forgets it was mined
+	pool.mu.Lock()
+	pool.reinject(types.Transactions{tx})
+	pool.mu.Unlock()
+
+	if err := pool.addRemote(tx.Copy()); !errors.Is(err, ErrNonceTooLow) {
+		t.Fatalf("reorged transaction re-add error mismatch: have %v, want %v", err, ErrNonceTooLow)
+	}
+}
+
+// Tests that accounts within their guaranteed executable slots are never touched
+// when the global pending limit is exceeded, only the overflow of larger ones.
+func TestPendingSlotsGuarantee(t *testing.T) {
+	t.Parallel()
+
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.GlobalSlots = 100
+	config.GlobalQueue = 6000
+
+	pool := New(config, blockchain)
+	if err := pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock()); err != nil {
+		t.Fatalf("failed to init pool: %v", err)
+	}
+	defer pool.Close()
+
+	small, _ := crypto.GenerateKey()
+	large, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(small.PublicKey), big.NewInt(1000000000000))
+	testAddBalance(pool, crypto.PubkeyToAddress(large.PublicKey), big.NewInt(1000000000000))
+
+	txs := types.Transactions{}
+	for i := uint64(0); i < 10; i++ {
+		txs = append(txs, transaction(i, 100000, small))
+	}
+	for i := uint64(0); i < 5000; i++ {
+		txs = append(txs, transaction(i, 100000, large))
+	}
+	pool.addRemotesSync(txs)
+
+	if have := pool.pending[crypto.PubkeyToAddress(small.PublicKey)].Len(); have != 10 {
+		t.Errorf("guaranteed account pending mismatch: have %d, want %d", have, 10)
+	}
+	if have := pool.pending[crypto.PubkeyToAddress(large.PublicKey)].Len(); have != int(config.GlobalSlots)-10 {
+		t.Errorf("overflowing account pending mismatch: have %d, want %d", have, int(config.GlobalSlots)-10)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Test the limit on transaction size is enforced correctly.
+// This test verifies every transaction having allowed size
+// is added to the pool, and longer transactions are rejected.
+func TestAllowedTxSize(t *testing.T) {
+	t.Parallel()
+
+	// Create a test account and fund it
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, big.NewInt(1000000000))
+
+	// Compute maximal data size for transactions (lower bound).
+
+	baseSize := transaction(0, 0, key).Size()
+	dataSize := (txMaxSize - baseSize) / 2 // due to the serilization problem, we have to compromise here.
+
+	// Try adding a transaction with maximal allowed size
+	tx := pricedDataTransaction(0, (*pool.currentHead.Load()).GasLimit(), big.NewInt(1), key, dataSize)
+	if err := pool.addRemoteSync(tx); err != nil {
+		t.Fatalf("failed to add transaction of size %d, close to maximal: %v", int(tx.Size()), err)
+	}
+	// Try adding a transaction with random allowed size
+	if err := pool.addRemoteSync(pricedDataTransaction(1, (*pool.currentHead.Load()).GasLimit(), big.NewInt(1), key, uint64(rand.Intn(int(dataSize))))); err != nil {
+		t.Fatalf("failed to add transaction of random allowed size: %v", err)
+	}
+	// Try adding a transaction of minimal not allowed size
+	if err := pool.addRemoteSync(pricedDataTransaction(2, (*pool.currentHead.Load()).GasLimit(), big.NewInt(1), key, txMaxSize)); err == nil {
+		t.Fatalf("expected rejection on slightly oversize transaction")
+	}
+	// Try adding a transaction of random not allowed size. The data alone has to
+	// exceed the limit, dataSize is halved and payloads above it may still fit.
+	if err := pool.addRemoteSync(pricedDataTransaction(2, (*pool.currentHead.Load()).GasLimit(), big.NewInt(1), key, txMaxSize+1+uint64(rand.Intn(int(10*txMaxSize))))); err == nil {
+		t.Fatalf("expected rejection on oversize transaction")
+	}
+	// Run some sanity checks on the pool internals
+	pending, queued := pool.Stats()
+	if pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	if queued != 0 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that the configured transaction size limit is enforced to the byte, and
+// that limits below a single slot fall back to the default.
+func TestConfiguredTxSize(t *testing.T) {
+	t.Parallel()
+
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 10000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.MaxTxSize = 1024
+	if sanitized := config.sanitize(); sanitized.MaxTxSize != txMaxSize {
+		t.Fatalf("sanitized size limit mismatch: have %d, want %d", sanitized.MaxTxSize, txMaxSize)
+	}
+	config.MaxTxSize = 2 * txSlotSize
+
+	pool := New(config, blockchain)
+	if err := pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock()); err != nil {
+		t.Fatalf("failed to init pool: %v", err)
+	}
+	defer pool.Close()
+
+	key, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	// Find the largest payload still within the limit
+	gas := (*pool.currentHead.Load()).GasLimit()
+	data := config.MaxTxSize - transaction(0, gas, key).Size()
+	fit := pricedDataTransaction(0, gas, big.NewInt(1), key, data)
+	for fit.Size() > config.MaxTxSize {
+		data--
+		fit = pricedDataTransaction(0, gas, big.NewInt(1), key, data)
+	}
+	// Signatures vary in encoded length, so retry until one byte more overflows
+	over := pricedDataTransaction(0, gas, big.NewInt(1), key, data+1)
+	for over.Size() <= config.MaxTxSize {
+		over = pricedDataTransaction(0, gas, big.NewInt(1), key, data+1)
+	}
+	if err := pool.addRemoteSync(over); !errors.Is(err, ErrOversizedData) {
+		t.Fatalf("expected %v, got %v", ErrOversizedData, err)
+	}
+	if err := pool.addRemoteSync(fit); err != nil {
+		t.Fatalf("failed to add transaction at the size limit: %v", err)
+	}
+}
+
+// Tests that if transactions start being capped, transactions are also removed from 'all'
+func TestCapClearsFromAll(t *testing.T) {
+	t.Parallel()
+
+	// Create the pool to test the limit enforcement with
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.AccountSlots = 2
+	config.AccountQueue = 2
+	config.GlobalSlots = 8
+
+	pool := New(config, blockchain)
+	pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock())
+	defer pool.Close()
+
+	// Create a number of test accounts and fund them
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, addr, big.NewInt(1000000))
+
+	txs := types.Transactions{}
+	for j := 0; j < int(config.GlobalSlots)*2; j++ {
+		txs = append(txs, transaction(uint64(j), 100000, key))
+	}
+	// Import the batch and verify that limits have been enforced
+	pool.addRemotes(txs)
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that if the transaction count belonging to multiple accounts go above
+// some hard threshold, if they are under the minimum guaranteed slot count then
+// the transactions are still kept.
+func TestPendingMinimumAllowance(t *testing.T) {
+	t.Parallel()
+
+	// Create the pool to test the limit enforcement with
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.GlobalSlots = 1
+
+	pool := New(config, blockchain)
+	pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock())
+	defer pool.Close()
+
+	// Create a number of test accounts and fund them
+	keys := make([]*ecdsa.PrivateKey, 5)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(10000000))
+	}
+	// Generate and queue a batch of transactions
+	nonces := make(map[common.Address]uint64)
+
+	txs := types.Transactions{}
+	for _, key := range keys {
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		for j := 0; j < int(config.AccountSlots)*2; j++ {
+			txs = append(txs, transaction(nonces[addr], 100000, key))
+			nonces[addr]++
+		}
+	}
+	// Import the batch and verify that limits have been enforced
+	pool.addRemotesSync(txs)
+
+	for addr, list := range pool.pending {
+		if list.Len() != int(config.AccountSlots) {
+			t.Errorf("addr %x: total pending transactions mismatch: have %d, want %d", addr, list.Len(), config.AccountSlots)
+		}
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that gapped transactions are routed to the queue while contiguous ones
+// join the pending list, and that the first nonce gap tracks both.
+func TestNonceGapRouting(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, big.NewInt(1000000000))
+	testSetNonce(pool, account, 1)
+	<-pool.requestReset(nil, nil)
+
+	gap := func() uint64 {
+		pool.mu.RLock()
+		defer pool.mu.RUnlock()
+		return pool.firstNonceGap(account)
+	}
+	if nonce := gap(); nonce != 1 {
+		t.Fatalf("first nonce gap mismatch: have %d, want %d", nonce, 1)
+	}
+	if err := pool.addRemoteSync(transaction(3, 100000, key)); err != nil {
+		t.Fatalf("failed to add gapped transaction: %v", err)
+	}
+	if pending, queued := pool.Stats(); pending != 0 || queued != 1 {
+		t.Fatalf("gapped transaction misrouted: have %d pending %d queued, want 0 pending 1 queued", pending, queued)
+	}
+	if err := pool.addRemoteSync(transaction(1, 100000, key)); err != nil {
+		t.Fatalf("failed to add contiguous transaction: %v", err)
+	}
+	if pending, queued := pool.Stats(); pending != 1 || queued != 1 {
+		t.Fatalf("contiguous transaction misrouted: have %d pending %d queued, want 1 pending 1 queued", pending, queued)
+	}
+	if nonce := gap(); nonce != 2 {
+		t.Fatalf("first nonce gap mismatch: have %d, want %d", nonce, 2)
+	}
+	// Filling the gap promotes the queued transaction as well
+	if err := pool.addRemoteSync(transaction(2, 100000, key)); err != nil {
+		t.Fatalf("failed to add gap filling transaction: %v", err)
+	}
+	if pending, queued := pool.Stats(); pending != 3 || queued != 0 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 3 pending 0 queued", pending, queued)
+	}
+	if nonce := gap(); nonce != 4 {
+		t.Fatalf("first nonce gap mismatch: have %d, want %d", nonce, 4)
+	}
+	// Tear a gap into the pending list: replacements behind it are rejected, as
+	// they'd join the pending list, while gapped queue entries are still fine
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
 
-	// remove current transactions and increase nonce to prepare for a reset and cleanup
-	statedb.SetNonce(crypto.PubkeyToAddress(remote.PublicKey), 2)
-	statedb.SetNonce(crypto.PubkeyToAddress(local.PublicKey), 2)
-	<-pool.requestReset(nil, nil)
-
-	// make sure queue, pending are cleared
-	pending, queued = pool.Stats()
-	if pending != 0 {
-		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 0)
+	pool.pending[account].txs.Remove(2)
+	if err := pool.validateTx(pricedTransaction(3, 100000, big.NewInt(2), key), false); !errors.Is(err, ErrNonceTooHigh) {
+		t.Errorf("gapped pending replacement error mismatch: have %v, want %v", err, ErrNonceTooHigh)
 	}
-	if queued != 0 {
-		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	if err := pool.validateTx(pricedTransaction(1, 100000, big.NewInt(2), key), false); err != nil {
+		t.Errorf("contiguous pending replacement rejected: %v", err)
 	}
-	if err := validatePoolInternals(pool); err != nil {
-		t.Fatalf("pool internal state corrupted: %v", err)
+	if err := pool.validateTx(transaction(5, 100000, key), false); err != nil {
+		t.Errorf("gapped queued transaction rejected: %v", err)
 	}
+}
 
-	// Queue gapped transactions
-	if err := pool.addLocal(pricedTransaction(4, 100000, big.NewInt(1), local)); err != nil {
-		t.Fatalf("failed to add remote transaction: %v", err)
+// Tests that a cancellation template replaces the stuck transaction once signed,
+// and that cancellations which can't replace anything are refused upfront.
+func TestCancel(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, from, big.NewInt(1000000000))
+
+	stuck := pricedTransaction(0, 100000, big.NewInt(1), key)
+	if err := pool.addLocal(stuck); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
 	}
-	if err := pool.addRemoteSync(pricedTransaction(4, 100000, big.NewInt(1), remote)); err != nil {
-		t.Fatalf("failed to add remote transaction: %v", err)
+	if _, err := pool.Cancel(from, 1, gadget.NewGasPrice(big.NewInt(2))); !errors.Is(err, ErrNothingToCancel) {
+		t.Fatalf("expected %v, got %v", ErrNothingToCancel, err)
 	}
-	time.Sleep(5 * evictionInterval) // A half lifetime pass
-
-	// Queue executable transactions, the life cycle should be restarted.
-	if err := pool.addLocal(pricedTransaction(2, 100000, big.NewInt(1), local)); err != nil {
-		t.Fatalf("failed to add remote transaction: %v", err)
+	if _, err := pool.Cancel(from, 0, gadget.NewGasPrice(big.NewInt(1))); !errors.Is(err, ErrReplaceUnderpriced) {
+		t.Fatalf("expected %v, got %v", ErrReplaceUnderpriced, err)
 	}
-	if err := pool.addRemoteSync(pricedTransaction(2, 100000, big.NewInt(1), remote)); err != nil {
-		t.Fatalf("failed to add remote transaction: %v", err)
+	cancel, err := pool.Cancel(from, 0, gadget.NewGasPrice(big.NewInt(2)))
+	if err != nil {
+		t.Fatalf("failed to build cancellation: %v", err)
 	}
-	time.Sleep(6 * evictionInterval)
+	if !cancel.IsCancel() {
+		t.Fatalf("template not recognized as a cancellation")
+	}
+	var validation gadget.Validation
+	validation.Sign(signingHash(cancel), key)
+	cancel.Validation = &validation
+	rehash(cancel)
 
-	// All gapped transactions shouldn't be kicked out
-	pending, queued = pool.Stats()
-	if pending != 2 {
-		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	if err := pool.addLocal(cancel); err != nil {
+		t.Fatalf("failed to add cancellation: %v", err)
 	}
-	if queued != 2 {
-		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 3)
+	if pool.Has(stuck.Hash()) {
+		t.Fatalf("cancelled transaction still in the pool")
+	}
+	if pending := pool.pending[from]; pending == nil || pending.txs.Get(0).Hash() != cancel.Hash() {
+		t.Fatalf("cancellation not pending")
 	}
 	if err := validatePoolInternals(pool); err != nil {
 		t.Fatalf("pool internal state corrupted: %v", err)
 	}
+}
 
-	// The whole life time pass after last promotion, kick out stale transactions
-	time.Sleep(2 * config.Lifetime)
-	pending, queued = pool.Stats()
-	if pending != 2 {
-		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+// Tests that Sync waits for asynchronously added transactions to be promoted and
+// refuses to wait on a closed pool.
+func TestSync(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	var txs types.Transactions
+	for i := uint64(0); i < 16; i++ {
+		txs = append(txs, transaction(i, 100000, key))
 	}
-	if nolocals {
-		if queued != 0 {
-			t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
-		}
-	} else {
-		if queued != 1 {
-			t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	for _, err := range pool.addRemotes(txs) {
+		if err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
 		}
 	}
-	if err := validatePoolInternals(pool); err != nil {
-		t.Fatalf("pool internal state corrupted: %v", err)
+	if err := pool.Sync(); err != nil {
+		t.Fatalf("failed to sync pool: %v", err)
+	}
+	if pending, queued := pool.Stats(); pending != len(txs) || queued != 0 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want %d pending 0 queued", pending, queued, len(txs))
+	}
+	pool.Close()
+	if err := pool.Sync(); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("expected %v, got %v", ErrPoolClosed, err)
 	}
 }
 
-// Tests that even if the transaction count belonging to a single account goes
-// above some threshold, as long as the transactions are executable, they are
-// accepted.
-func TestPendingLimiting(t *testing.T) {
+// Tests that replacing a pending or a queued transaction announces the superseded
+// hash and drops the old transaction from the pool.
+func TestReplacementEvents(t *testing.T) {
 	t.Parallel()
 
-	// Create a test account and fund it
 	pool, key := setupPool()
 	defer pool.Close()
 
-	account := crypto.PubkeyToAddress(key.PublicKey)
-	testAddBalance(pool, account, big.NewInt(1000000000000))
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
 
-	// Keep track of transaction events to ensure all executables get announced
-	events := make(chan NewTxsEvent, testTxPoolConfig.AccountQueue+5)
-	sub := pool.txFeed.Subscribe(events)
+	events := make(chan ReplacedEvent, 8)
+	sub := pool.SubscribeReplacements(events)
 	defer sub.Unsubscribe()
 
-	// Keep queuing up transactions and make sure all above a limit are dropped
-	for i := uint64(0); i < testTxPoolConfig.AccountQueue+5; i++ {
-		if err := pool.addRemoteSync(transaction(i, 100000, key)); err != nil {
-			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
+	for _, nonce := range []uint64{0, 2} { // pending and queued
+		old := pricedTransaction(nonce, 100000, big.NewInt(1), key)
+		bump := pricedTransaction(nonce, 100000, big.NewInt(2), key)
+		if err := pool.addRemoteSync(old); err != nil {
+			t.Fatalf("nonce %d: failed to add original transaction: %v", nonce, err)
 		}
-		if pool.pending[account].Len() != int(i)+1 {
-			t.Errorf("tx %d: pending pool size mismatch: have %d, want %d", i, pool.pending[account].Len(), i+1)
+		if err := pool.addRemoteSync(bump); err != nil {
+			t.Fatalf("nonce %d: failed to add replacement transaction: %v", nonce, err)
 		}
-		if len(pool.queue) != 0 {
-			t.Errorf("tx %d: queue size mismatch: have %d, want %d", i, pool.queue[account].Len(), 0)
+		select {
+		case ev := <-events:
+			if ev.Old != old.Hash() || ev.New != bump.Hash() {
+				t.Errorf("nonce %d: replacement mismatch: have %x -> %x, want %x -> %x", nonce, ev.Old, ev.New, old.Hash(), bump.Hash())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("nonce %d: replacement event not fired", nonce)
+		}
+		if pool.Has(old.Hash()) || !pool.Has(bump.Hash()) {
+			t.Errorf("nonce %d: pool contents mismatch after replacement", nonce)
 		}
 	}
-	if pool.all.Count() != int(testTxPoolConfig.AccountQueue+5) {
-		t.Errorf("total transaction mismatch: have %d, want %d", pool.all.Count(), testTxPoolConfig.AccountQueue+5)
-	}
-	if err := validateEvents(events, int(testTxPoolConfig.AccountQueue+5)); err != nil {
-		t.Fatalf("event firing failed: %v", err)
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected replacement event: %x -> %x", ev.Old, ev.New)
+	default:
 	}
 	if err := validatePoolInternals(pool); err != nil {
 		t.Fatalf("pool internal state corrupted: %v", err)
 	}
 }
 
-// Tests that if the transaction count belonging to multiple accounts go above
-// some hard threshold, the higher transactions are dropped to prevent DOS
-// attacks.
-func TestPendingGlobalLimiting(t *testing.T) {
+// Tests that the errors of a batch add identify the offending transactions while
+// still matching the underlying sentinel errors.
+func TestAddErrors(t *testing.T) {
 	t.Parallel()
 
-	// Create the pool to test the limit enforcement with
+	pool, key := setupPool()
+	defer pool.Close()
+
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	known := transaction(0, 100000, key)
+	underpriced := pricedTransaction(1, 100000, big.NewInt(0), key)
+	if err := pool.addRemoteSync(known); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	errs := pool.addRemotesSync([]*types.Transaction{known, transaction(1, 100000, key), underpriced})
+	if errs[1] != nil {
+		t.Fatalf("failed to add transaction: %v", errs[1])
+	}
+	for i, want := range []struct {
+		tx  *types.Transaction
+		err error
+	}{{known, ErrAlreadyKnown}, {}, {underpriced, ErrUnderpriced}} {
+		if want.tx == nil {
+			continue
+		}
+		if !errors.Is(errs[i], want.err) {
+			t.Errorf("error %d mismatch: have %v, want %v", i, errs[i], want.err)
+		}
+		var addErr *AddError
+		if !errors.As(errs[i], &addErr) {
+			t.Fatalf("error %d not annotated: %v", i, errs[i])
+		}
+		if addErr.Hash != want.tx.Hash() || addErr.From != want.tx.From {
+			t.Errorf("error %d origin mismatch: have %x from %x, want %x from %x", i, addErr.Hash, addErr.From, want.tx.Hash(), want.tx.From)
+		}
+	}
+}
+
+// Tests that the local transactions of an account are reported in full, pending
+// and queued alike, while remote ones are left out.
+func TestLocalTransactions(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	remote, _ := crypto.GenerateKey()
+	local := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, local, big.NewInt(1000000000))
+	testAddBalance(pool, crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+
+	for _, nonce := range []uint64{5, 0, 1, 3} {
+		if err := pool.addLocal(transaction(nonce, 100000, key)); err != nil {
+			t.Fatalf("failed to add local transaction %d: %v", nonce, err)
+		}
+	}
+	pool.addRemotesSync([]*types.Transaction{transaction(0, 100000, remote)})
+
+	txs := pool.LocalTransactions()
+	if len(txs) != 1 {
+		t.Fatalf("local account count mismatch: have %d, want %d", len(txs), 1)
+	}
+	want := []uint64{0, 1, 3, 5}
+	if len(txs[local]) != len(want) {
+		t.Fatalf("local transaction count mismatch: have %d, want %d", len(txs[local]), len(want))
+	}
+	for i, tx := range txs[local] {
+		if tx.Nonce != want[i] {
+			t.Errorf("local transaction %d nonce mismatch: have %d, want %d", i, tx.Nonce, want[i])
+		}
+	}
+}
+
+// Tests that accounts which became local by submitting transactions stay local
+// once their last transaction is mined, and are only forgotten when explicitly
+// emptied, while configured locals are always retained.
+func TestLocalsRetainedOnMining(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	configured, _ := crypto.GenerateKey()
+	dynamic := crypto.PubkeyToAddress(key.PublicKey)
+	static := crypto.PubkeyToAddress(configured.PublicKey)
+
+	pool.mu.Lock()
+	pool.config.Locals = []common.Address{static}
+	pool.mu.Unlock()
+
+	for _, addr := range []common.Address{dynamic, static} {
+		testAddBalance(pool, addr, big.NewInt(1000000000))
+	}
+	if err := pool.addLocal(transaction(0, 100000, key)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	if err := pool.addLocal(transaction(0, 100000, configured)); err != nil {
+		t.Fatalf("failed to add configured local transaction: %v", err)
+	}
+	if locals := pool.Locals(); len(locals) != 2 {
+		t.Fatalf("local account count mismatch: have %d, want %d", len(locals), 2)
+	}
+	// Mine both transactions and ensure both accounts stay local
+	testSetNonce(pool, dynamic, 1)
+	testSetNonce(pool, static, 1)
+	<-pool.requestReset(nil, nil)
+
+	if pending, queued := pool.Stats(); pending != 0 || queued != 0 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 0 pending 0 queued", pending, queued)
+	}
+	if locals := pool.Locals(); len(locals) != 2 {
+		t.Fatalf("local account count mismatch after mining: have %d, want %d", len(locals), 2)
+	}
+	// Explicitly remove the only transaction of both accounts
+	txs := []*types.Transaction{transaction(1, 100000, key), transaction(1, 100000, configured)}
+	for i, err := range pool.addLocals(txs) {
+		if err != nil {
+			t.Fatalf("failed to add local transaction %d: %v", i, err)
+		}
+	}
+	for _, tx := range txs {
+		if removed := pool.RemoveTx(tx.Hash(), true); removed != 1 {
+			t.Fatalf("removed transaction count mismatch: have %d, want %d", removed, 1)
+		}
+	}
+	locals := pool.Locals()
+	if len(locals) != 1 || locals[0] != static {
+		t.Fatalf("local accounts mismatch: have %v, want [%v]", locals, static)
+	}
+}
+
+// Tests that local accounts left without transactions are forgotten once they
+// stay idle for a whole lifetime, and not before.
+func TestLocalsLifetime(t *testing.T) {
+	// Reduce the eviction interval to a testable amount
+	defer func(old time.Duration) { evictionInterval = old }(evictionInterval)
+	evictionInterval = time.Millisecond * 100
+
 	statedb := state.NewEasyStateDB()
 	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
 
 	config := testTxPoolConfig
-	config.GlobalSlots = config.AccountSlots * 10
+	config.Lifetime = time.Second
 
 	pool := New(config, blockchain)
 	pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock())
 	defer pool.Close()
 
-	// Create a number of test accounts and fund them
-	keys := make([]*ecdsa.PrivateKey, 5)
-	for i := 0; i < len(keys); i++ {
-		keys[i], _ = crypto.GenerateKey()
-		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
-	}
-	// Generate and queue a batch of transactions
-	nonces := make(map[common.Address]uint64)
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, addr, big.NewInt(1000000000))
 
-	txs := types.Transactions{}
-	for _, key := range keys {
-		addr := crypto.PubkeyToAddress(key.PublicKey)
-		for j := 0; j < int(config.GlobalSlots)/len(keys)*2; j++ {
-			txs = append(txs, transaction(nonces[addr], 100000, key))
-			nonces[addr]++
-		}
+	if err := pool.addLocal(transaction(0, 100000, key)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
 	}
-	// Import the batch and verify that limits have been enforced
-	pool.addRemotesSync(txs)
+	testSetNonce(pool, addr, 1)
+	<-pool.requestReset(nil, nil)
 
-	pending := 0
-	for _, list := range pool.pending {
-		pending += list.Len()
+	// A mined account must survive shorter than a lifetime of idling
+	time.Sleep(5 * evictionInterval)
+	if locals := pool.Locals(); len(locals) != 1 {
+		t.Fatalf("local account forgotten before its lifetime: have %d locals, want %d", len(locals), 1)
 	}
-	if pending > int(config.GlobalSlots) {
-		t.Fatalf("total pending transactions overflow allowance: %d > %d", pending, config.GlobalSlots)
+	time.Sleep(2 * config.Lifetime)
+	if locals := pool.Locals(); len(locals) != 0 {
+		t.Fatalf("idle local account retained: have %d locals, want %d", len(locals), 0)
 	}
-	if err := validatePoolInternals(pool); err != nil {
-		t.Fatalf("pool internal state corrupted: %v", err)
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	if len(pool.beats) != 0 {
+		t.Errorf("heartbeats leaked: have %d, want none", len(pool.beats))
 	}
 }
 
-// Test the limit on transaction size is enforced correctly.
-// This test verifies every transaction having allowed size
-// is added to the pool, and longer transactions are rejected.
-func TestAllowedTxSize(t *testing.T) {
+// Tests that the virtual nonces of local accounts are seeded from the state on
+// startup, without overriding nonces advanced by the journal.
+func TestWarmLocals(t *testing.T) {
 	t.Parallel()
 
-	// Create a test account and fund it
-	pool, key := setupPool()
-	defer pool.Close()
+	var (
+		statedb    = state.NewEasyStateDB()
+		blockchain = NewEasyBlockChain(nil, 10000000, statedb, new(event.Feed))
+		idle       = common.Address{0x01}
+		fresh      = common.Address{0x02}
+		remote     = common.Address{0x03}
+	)
+	statedb.SetNonce(idle, 5)
+	statedb.SetNonce(remote, 7)
 
-	account := crypto.PubkeyToAddress(key.PublicKey)
-	testAddBalance(pool, account, big.NewInt(1000000000))
+	config := testTxPoolConfig
+	config.Locals = []common.Address{idle, fresh}
 
-	// Compute maximal data size for transactions (lower bound).
+	pool := New(config, blockchain)
+	if err := pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock()); err != nil {
+		t.Fatalf("failed to init pool: %v", err)
+	}
+	defer pool.Close()
 
-	baseSize := transaction(0, 0, key).Size()
-	dataSize := (txMaxSize - baseSize) / 2 // due to the serilization problem, we have to compromise here.
+	pool.pendingNonces.lock.Lock()
+	defer pool.pendingNonces.lock.Unlock()
 
-	// Try adding a transaction with maximal allowed size
-	tx := pricedDataTransaction(0, (*pool.currentHead.Load()).GasLimit(), big.NewInt(1), key, dataSize)
-	if err := pool.addRemoteSync(tx); err != nil {
-		t.Fatalf("failed to add transaction of size %d, close to maximal: %v", int(tx.Size()), err)
+	for addr, want := range map[common.Address]uint64{idle: 5, fresh: 0} {
+		if nonce, ok := pool.pendingNonces.nonces[addr]; !ok || nonce != want {
+			t.Errorf("local %x nonce mismatch: have %d (seeded %v), want %d", addr, nonce, ok, want)
+		}
 	}
-	// Try adding a transaction with random allowed size
-	if err := pool.addRemoteSync(pricedDataTransaction(1, (*pool.currentHead.Load()).GasLimit(), big.NewInt(1), key, uint64(rand.Intn(int(dataSize))))); err != nil {
-		t.Fatalf("failed to add transaction of random allowed size: %v", err)
+	if _, ok := pool.pendingNonces.nonces[remote]; ok {
+		t.Errorf("remote account nonce seeded")
 	}
-	// Try adding a transaction of minimal not allowed size
-	if err := pool.addRemoteSync(pricedDataTransaction(2, (*pool.currentHead.Load()).GasLimit(), big.NewInt(1), key, txMaxSize)); err == nil {
-		t.Fatalf("expected rejection on slightly oversize transaction")
+}
+
+// Tests that the pool projects the base fee of the next block from its head and
+// prices its heaps with it.
+func TestBaseFeeTracking(t *testing.T) {
+	t.Parallel()
+
+	pool, _ := setupPool()
+	defer pool.Close()
+
+	if fee := pool.CurrentBaseFee(); fee != nil {
+		t.Fatalf("base fee without a priced head: have %v, want nil", fee)
 	}
-	// Try adding a transaction of random not allowed size
-	if err := pool.addRemoteSync(pricedDataTransaction(2, (*pool.currentHead.Load()).GasLimit(), big.NewInt(1), key, dataSize+1+uint64(rand.Intn(int(10*txMaxSize))))); err == nil {
-		t.Fatalf("expected rejection on oversize transaction")
+	// A full parent block raises the base fee by 1/8
+	head := types.NewEasyHeader(common.Hash{0x01}, common.Hash{}, big.NewInt(1), 10000000, 10000000, big.NewInt(1000))
+	<-pool.requestReset(nil, head)
+
+	if fee := pool.CurrentBaseFee(); fee == nil || fee.Cmp(big.NewInt(1125)) != 0 {
+		t.Fatalf("projected base fee mismatch: have %v, want %v", fee, 1125)
 	}
-	// Run some sanity checks on the pool internals
-	pending, queued := pool.Stats()
-	if pending != 2 {
-		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	if fee := pool.priced.urgent.baseFee; fee == nil || fee.Cmp(big.NewInt(1125)) != 0 {
+		t.Fatalf("priced list base fee mismatch: have %v, want %v", fee, 1125)
 	}
-	if queued != 0 {
-		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+}
+
+// Tests that heads without a base fee are treated as legacy pricing, also when
+// following a head priced with one.
+func TestBaseFeeLegacyHead(t *testing.T) {
+	t.Parallel()
+
+	pool, _ := setupPool()
+	defer pool.Close()
+
+	priced := types.NewEasyHeader(common.Hash{0x01}, common.Hash{}, big.NewInt(1), 10000000, 10000000, big.NewInt(1000))
+	<-pool.requestReset(nil, priced)
+
+	legacy := types.NewEasyHeader(common.Hash{0x02}, priced.Hash(), big.NewInt(2), 10000000, 10000000, nil)
+	if legacy.BaseFee() != nil || legacy.GasUsed() != 10000000 {
+		t.Fatalf("legacy header mismatch: have base fee %v gas used %d", legacy.BaseFee(), legacy.GasUsed())
+	}
+	<-pool.requestReset(priced, legacy)
+
+	if fee := pool.CurrentBaseFee(); fee != nil {
+		t.Fatalf("base fee after a legacy head: have %v, want nil", fee)
 	}
-	if err := validatePoolInternals(pool); err != nil {
-		t.Fatalf("pool internal state corrupted: %v", err)
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	if fee := pool.priced.urgent.baseFee; fee != nil {
+		t.Fatalf("priced list base fee after a legacy head: have %v, want nil", fee)
 	}
 }
 
-// Tests that if transactions start being capped, transactions are also removed from 'all'
-func TestCapClearsFromAll(t *testing.T) {
+// Tests that retrieving the pending set does not drain the pool, and that tip
+// enforcement only caps the lists of remote accounts.
+func TestPendingEnforceTips(t *testing.T) {
 	t.Parallel()
 
-	// Create the pool to test the limit enforcement with
-	statedb := state.NewEasyStateDB()
-	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+	pool, _ := setupPool()
+	defer pool.Close()
 
-	config := testTxPoolConfig
-	config.AccountSlots = 2
-	config.AccountQueue = 2
-	config.GlobalSlots = 8
+	keys := make([]*ecdsa.PrivateKey, 2)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
+	}
+	remote := crypto.PubkeyToAddress(keys[0].PublicKey)
+	local := crypto.PubkeyToAddress(keys[1].PublicKey)
 
-	pool := New(config, blockchain)
-	pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock())
-	defer pool.Close()
+	pool.addRemotesSync([]*types.Transaction{
+		pricedTransaction(0, 100000, big.NewInt(3), keys[0]),
+		pricedTransaction(1, 100000, big.NewInt(1), keys[0]),
+		pricedTransaction(2, 100000, big.NewInt(3), keys[0]),
+	})
+	pool.addLocal(pricedTransaction(0, 100000, big.NewInt(1), keys[1]))
 
-	// Create a number of test accounts and fund them
-	key, _ := crypto.GenerateKey()
-	addr := crypto.PubkeyToAddress(key.PublicKey)
-	testAddBalance(pool, addr, big.NewInt(1000000))
+	// Raise the tip without evicting anything, so the pending set contains
+	// transactions below the threshold
+	pool.gasTip.Store(big.NewInt(2))
 
-	txs := types.Transactions{}
-	for j := 0; j < int(config.GlobalSlots)*2; j++ {
-		txs = append(txs, transaction(uint64(j), 100000, key))
+	pending := pool.Pending(false)
+	if len(pending[remote]) != 3 || len(pending[local]) != 1 {
+		t.Fatalf("unenforced pending mismatch: have %d/%d, want %d/%d", len(pending[remote]), len(pending[local]), 3, 1)
+	}
+	for i, tx := range pending[remote] {
+		if tx.Nonce != uint64(i) {
+			t.Fatalf("pending transaction %d nonce mismatch: have %d, want %d", i, tx.Nonce, i)
+		}
+	}
+	pending = pool.Pending(true)
+	if len(pending[remote]) != 1 || len(pending[local]) != 1 {
+		t.Fatalf("enforced pending mismatch: have %d/%d, want %d/%d", len(pending[remote]), len(pending[local]), 1, 1)
+	}
+	if n, _ := pool.Stats(); n != 4 {
+		t.Fatalf("pending transactions mismatched after retrieval: have %d, want %d", n, 4)
 	}
-	// Import the batch and verify that limits have been enforced
-	pool.addRemotes(txs)
 	if err := validatePoolInternals(pool); err != nil {
 		t.Fatalf("pool internal state corrupted: %v", err)
 	}
 }
 
-// Tests that if the transaction count belonging to multiple accounts go above
-// some hard threshold, if they are under the minimum guaranteed slot count then
-// the transactions are still kept.
-func TestPendingMinimumAllowance(t *testing.T) {
+// Tests that tip enforcement compares the tips effectively paid under the pending
+// base fee, not the tip caps of the transactions.
+func TestPendingEnforceEffectiveTips(t *testing.T) {
 	t.Parallel()
 
-	// Create the pool to test the limit enforcement with
-	statedb := state.NewEasyStateDB()
-	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
-
-	config := testTxPoolConfig
-	config.GlobalSlots = 1
-
-	pool := New(config, blockchain)
-	pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock())
+	pool, _ := setupPool()
 	defer pool.Close()
 
-	// Create a number of test accounts and fund them
-	keys := make([]*ecdsa.PrivateKey, 5)
+	keys := make([]*ecdsa.PrivateKey, 2)
 	for i := 0; i < len(keys); i++ {
 		keys[i], _ = crypto.GenerateKey()
 		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(10000000))
 	}
-	// Generate and queue a batch of transactions
-	nonces := make(map[common.Address]uint64)
+	capped := crypto.PubkeyToAddress(keys[0].PublicKey)
+	starved := crypto.PubkeyToAddress(keys[1].PublicKey)
 
-	txs := types.Transactions{}
-	for _, key := range keys {
-		addr := crypto.PubkeyToAddress(key.PublicKey)
-		for j := 0; j < int(config.AccountSlots)*2; j++ {
-			txs = append(txs, transaction(nonces[addr], 100000, key))
-			nonces[addr]++
-		}
+	// The second transaction of the first account has a large tip cap, but its fee
+	// cap leaves only a tip of 1 above the base fee
+	pool.addRemotesSync([]*types.Transaction{
+		dynamicFeeTransaction(0, 100000, big.NewInt(10), big.NewInt(3), keys[0]),
+		dynamicFeeTransaction(1, 100000, big.NewInt(6), big.NewInt(5), keys[0]),
+		dynamicFeeTransaction(2, 100000, big.NewInt(10), big.NewInt(3), keys[0]),
+		dynamicFeeTransaction(0, 100000, big.NewInt(4), big.NewInt(4), keys[1]),
+	})
+	pool.mu.Lock()
+	pool.priced.SetBaseFee(big.NewInt(5))
+	pool.mu.Unlock()
+	pool.gasTip.Store(big.NewInt(2))
+
+	pending := pool.Pending(true)
+	if len(pending[capped]) != 1 || pending[capped][0].Nonce != 0 {
+		t.Fatalf("capped account mismatch: have %d transactions, want %d", len(pending[capped]), 1)
 	}
-	// Import the batch and verify that limits have been enforced
-	pool.addRemotesSync(txs)
+	if _, ok := pending[starved]; ok {
+		t.Fatalf("account below the base fee returned")
+	}
+	if n, _ := pool.Stats(); n != 4 {
+		t.Fatalf("pending transactions mismatched after retrieval: have %d, want %d", n, 4)
+	}
+}
 
-	for addr, list := range pool.pending {
-		if list.Len() != int(config.AccountSlots) {
-			t.Errorf("addr %x: total pending transactions mismatch: have %d, want %d", addr, list.Len(), config.AccountSlots)
+// Tests that the transactions handed out by the pool are copies, which callers may
+// reorder in place without corrupting the lists they were taken from.
+func TestPendingMutationIsolated(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, addr, big.NewInt(1000000))
+	for i := uint64(0); i < 4; i++ {
+		if err := pool.addRemoteSync(transaction(i, 100000, key)); err != nil {
+			t.Fatalf("failed to add transaction %d: %v", i, err)
 		}
 	}
+	reverse := func(txs []*types.Transaction) {
+		sort.Slice(txs, func(i, j int) bool { return txs[i].Nonce > txs[j].Nonce })
+	}
+	reverse(pool.Pending(false)[addr])
+	content, _ := pool.Content()
+	reverse(content[addr])
+	from, _ := pool.ContentFrom(addr)
+	reverse(from)
+
+	// Mine the first two transactions, trimming them off the front of the list
+	testSetNonce(pool, addr, 2)
+	<-pool.requestReset(nil, nil)
+
+	pending, _ := pool.ContentFrom(addr)
+	if len(pending) != 2 || pending[0].Nonce != 2 || pending[1].Nonce != 3 {
+		t.Fatalf("pending transactions mismatch after caller reordering: have %d transactions", len(pending))
+	}
 	if err := validatePoolInternals(pool); err != nil {
 		t.Fatalf("pool internal state corrupted: %v", err)
 	}
@@ -1398,6 +3301,133 @@ func TestRepricing(t *testing.T) {
 	}
 }
 
+// Tests that setting the transaction pool gas tip to a higher value correctly
+// discards everything with a tip cap below it and moves any gapped transactions
+// back from the pending pool to the queue, for dynamic fee transactions too.
+//
+// Note, local transactions are never allowed to be dropped.
+func TestRepricingDynamicFee(t *testing.T) {
+	t.Parallel()
+
+	// Create the pool to test the pricing enforcement with
+	pool, _ := setupPool()
+	defer pool.Close()
+
+	// Keep track of transaction events to ensure all executables get announced
+	events := make(chan NewTxsEvent, 32)
+	sub := pool.txFeed.Subscribe(events)
+	defer sub.Unsubscribe()
+
+	// Create a number of test accounts and fund them
+	keys := make([]*ecdsa.PrivateKey, 4)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
+	}
+	// Generate and queue a batch of transactions, both pending and queued
+	txs := types.Transactions{}
+
+	txs = append(txs, pricedTransaction(0, 100000, big.NewInt(2), keys[0]))
+	txs = append(txs, pricedTransaction(1, 100000, big.NewInt(1), keys[0]))
+	txs = append(txs, pricedTransaction(2, 100000, big.NewInt(2), keys[0]))
+
+	txs = append(txs, dynamicFeeTransaction(0, 100000, big.NewInt(3), big.NewInt(1), keys[1]))
+	txs = append(txs, dynamicFeeTransaction(1, 100000, big.NewInt(3), big.NewInt(2), keys[1]))
+	txs = append(txs, dynamicFeeTransaction(2, 100000, big.NewInt(3), big.NewInt(2), keys[1]))
+
+	txs = append(txs, dynamicFeeTransaction(1, 100000, big.NewInt(2), big.NewInt(2), keys[2]))
+	txs = append(txs, dynamicFeeTransaction(2, 100000, big.NewInt(1), big.NewInt(1), keys[2]))
+	txs = append(txs, dynamicFeeTransaction(3, 100000, big.NewInt(2), big.NewInt(2), keys[2]))
+
+	ltx := dynamicFeeTransaction(0, 100000, big.NewInt(2), big.NewInt(1), keys[3])
+
+	// Import the batch and that both pending and queued transactions match up
+	pool.addRemotesSync(txs)
+	pool.addLocal(ltx)
+
+	pending, queued := pool.Stats()
+	if pending != 7 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 7)
+	}
+	if queued != 3 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 3)
+	}
+	if err := validateEvents(events, 7); err != nil {
+		t.Fatalf("original event firing failed: %v", err)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	// Reprice the pool and check that underpriced transactions get dropped
+	pool.SetGasTip(big.NewInt(2))
+
+	pending, queued = pool.Stats()
+	if pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	if queued != 5 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 5)
+	}
+	if err := validateEvents(events, 0); err != nil {
+		t.Fatalf("reprice event firing failed: %v", err)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	// Check that we can't add the old transactions back
+	tx := pricedTransaction(1, 100000, big.NewInt(1), keys[0])
+	if err := pool.addRemote(tx); !errors.Is(err, ErrUnderpriced) {
+		t.Fatalf("adding underpriced pending transaction error mismatch: have %v, want %v", err, ErrUnderpriced)
+	}
+	tx = dynamicFeeTransaction(0, 100000, big.NewInt(2), big.NewInt(1), keys[1])
+	if err := pool.addRemote(tx); !errors.Is(err, ErrUnderpriced) {
+		t.Fatalf("adding underpriced pending transaction error mismatch: have %v, want %v", err, ErrUnderpriced)
+	}
+	tx = dynamicFeeTransaction(2, 100000, big.NewInt(1), big.NewInt(1), keys[2])
+	if err := pool.addRemote(tx); !errors.Is(err, ErrUnderpriced) {
+		t.Fatalf("adding underpriced queued transaction error mismatch: have %v, want %v", err, ErrUnderpriced)
+	}
+	if err := validateEvents(events, 0); err != nil {
+		t.Fatalf("post-reprice event firing failed: %v", err)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	// However we can add local underpriced transactions
+	tx = dynamicFeeTransaction(1, 100000, big.NewInt(1), big.NewInt(1), keys[3])
+	if err := pool.addLocal(tx); err != nil {
+		t.Fatalf("failed to add underpriced local transaction: %v", err)
+	}
+	if pending, _ = pool.Stats(); pending != 3 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 3)
+	}
+	if err := validateEvents(events, 1); err != nil {
+		t.Fatalf("post-reprice local event firing failed: %v", err)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	// And we can fill gaps with properly priced transactions
+	tx = pricedTransaction(1, 100000, big.NewInt(2), keys[0])
+	if err := pool.addRemote(tx); err != nil {
+		t.Fatalf("failed to add pending transaction: %v", err)
+	}
+	tx = dynamicFeeTransaction(0, 100000, big.NewInt(3), big.NewInt(2), keys[1])
+	if err := pool.addRemote(tx); err != nil {
+		t.Fatalf("failed to add pending transaction: %v", err)
+	}
+	tx = dynamicFeeTransaction(2, 100000, big.NewInt(2), big.NewInt(2), keys[2])
+	if err := pool.addRemote(tx); err != nil {
+		t.Fatalf("failed to add queued transaction: %v", err)
+	}
+	if err := validateEvents(events, 5); err != nil {
+		t.Fatalf("post-reprice event firing failed: %v", err)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 // Tests that setting the transaction pool gas price to a higher value does not
 // remove local transactions.
 func TestRepricingKeepsLocals(t *testing.T) {
@@ -1458,6 +3488,58 @@ func TestRepricingKeepsLocals(t *testing.T) {
 	validate()
 }
 
+// Tests that once the slot budget of the pool is exhausted, remote transactions
+// are rejected with ErrTxPoolOverflow if no room can be made for them, or if too
+// many transactions were already replaced since the last reorg.
+func TestPoolOverflow(t *testing.T) {
+	t.Parallel()
+
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 1000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.GlobalSlots = 3
+	config.GlobalQueue = 1
+
+	pool := New(config, blockchain)
+	pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock())
+	defer pool.Close()
+
+	local, _ := crypto.GenerateKey()
+	remote, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
+	testAddBalance(pool, crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+
+	// Fill the pool with local transactions, which can't be discarded
+	for i := uint64(0); i < 4; i++ {
+		if err := pool.addLocal(pricedTransaction(i, 100000, big.NewInt(1), local)); err != nil {
+			t.Fatalf("failed to add local transaction %d: %v", i, err)
+		}
+	}
+	if err := pool.addRemoteSync(pricedTransaction(0, 100000, big.NewInt(10), remote)); !errors.Is(err, ErrTxPoolOverflow) {
+		t.Fatalf("overflowing transaction error mismatch: have %v, want %v", err, ErrTxPoolOverflow)
+	}
+	if slots := pool.all.Slots(); slots != 4 {
+		t.Fatalf("slot count mismatch: have %d, want %d", slots, 4)
+	}
+	// Drop a local to make room for a cheap remote, then exhaust the replacement
+	// allowance: even a better priced remote must wait for the next reorg
+	pool.RemoveTx(pool.pending[crypto.PubkeyToAddress(local.PublicKey)].txs.Get(3).Hash(), true)
+	if err := pool.addRemoteSync(pricedTransaction(0, 100000, big.NewInt(1), remote)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	pool.mu.Lock()
+	pool.changesSinceReorg = int(config.GlobalSlots/4) + 1
+	pool.mu.Unlock()
+
+	if _, err := pool.add(pricedTransaction(1, 100000, big.NewInt(10), remote), false); !errors.Is(err, ErrTxPoolOverflow) {
+		t.Fatalf("throttled transaction error mismatch: have %v, want %v", err, ErrTxPoolOverflow)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 // Tests that when the pool reaches its global transaction limit, underpriced
 // transactions are gradually shifted out for more expensive ones and any gapped
 // pending transactions are moved into the queue.
@@ -1489,6 +3571,19 @@ func TestUnderpricing(t *testing.T) {
 		keys[i], _ = crypto.GenerateKey()
 		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
 	}
+	// Price ties are evicted in hash order. The expectations below were laid out
+	// for the ties between K0 and K1 going against K1, so redraw K1 until its
+	// transactions hash lower.
+	evictsFirst := func(a, b *types.Transaction) bool {
+		ha, hb := a.Hash(), b.Hash()
+		return bytes.Compare(ha[:], hb[:]) < 0
+	}
+	for !evictsFirst(pricedTransaction(1, 100000, big.NewInt(1), keys[1]), pricedTransaction(0, 100000, big.NewInt(1), keys[0])) ||
+		!evictsFirst(pricedTransaction(1, 100000, big.NewInt(2), keys[1]), pricedTransaction(1, 100000, big.NewInt(2), keys[0])) {
+		keys[1], _ = crypto.GenerateKey()
+	}
+	testAddBalance(pool, crypto.PubkeyToAddress(keys[1].PublicKey), big.NewInt(1000000))
+
 	// Generate and queue a batch of transactions, both pending and queued
 	txs := types.Transactions{}
 
@@ -1535,7 +3630,7 @@ func TestUnderpricing(t *testing.T) {
 		t.Fatalf("failed to add well priced transaction: %v", err)
 	}
 	// Ensure that replacing a pending transaction with a future transaction fails
-	if err := pool.addRemote(pricedTransaction(5, 100000, big.NewInt(6), keys[1])); err != ErrFutureReplacePending {
+	if err := pool.addRemote(pricedTransaction(5, 100000, big.NewInt(6), keys[1])); !errors.Is(err, ErrFutureReplacePending) {
 		t.Fatalf("adding future replace transaction error mismatch: have %v, want %v", err, ErrFutureReplacePending)
 	}
 	pending, queued = pool.Stats()
@@ -1701,8 +3796,55 @@ func TestDeduplication(t *testing.T) {
 	if pending != len(txs) {
 		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, len(txs))
 	}
-	if queued != 0 {
-		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	if queued != 0 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that Add reports the errors of a mixed batch in the same order as the
+// transactions were supplied, regardless of which validation stage rejected them.
+func TestAddErrorOrdering(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, from, big.NewInt(1000000000))
+	testSetNonce(pool, from, 1)
+	<-pool.requestReset(nil, nil)
+
+	known := transaction(1, 100000, key)
+	if err := pool.addRemoteSync(known); err != nil {
+		t.Fatalf("failed to add seed transaction: %v", err)
+	}
+	txs := types.Transactions{
+		transaction(2, 100000, key), // valid
+		known,                       // already known, rejected before locking
+		transaction(3, 100, key),    // intrinsic gas too low, rejected before locking
+		transaction(0, 100000, key), // nonce too low, rejected under the lock
+		transaction(4, 100000, key), // valid
+	}
+	want := []error{nil, ErrAlreadyKnown, ErrIntrinsicGas, ErrNonceTooLow, nil}
+
+	errs := pool.Add(txs, false, true)
+	if len(errs) != len(txs) {
+		t.Fatalf("result count mismatch: have %d, want %d", len(errs), len(txs))
+	}
+	for i, err := range errs {
+		if !errors.Is(err, want[i]) {
+			t.Errorf("error %d mismatch: have %v, want %v", i, err, want[i])
+		}
+	}
+	pending, queued := pool.Stats()
+	if pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
 	}
 	if err := validatePoolInternals(pool); err != nil {
 		t.Fatalf("pool internal state corrupted: %v", err)
@@ -1738,7 +3880,7 @@ func TestReplacement(t *testing.T) {
 	if err := pool.addRemoteSync(pricedTransaction(0, 100000, big.NewInt(1), key)); err != nil {
 		t.Fatalf("failed to add original cheap pending transaction: %v", err)
 	}
-	if err := pool.addRemote(pricedTransaction(0, 100001, big.NewInt(1), key)); err != ErrReplaceUnderpriced {
+	if err := pool.addRemote(pricedTransaction(0, 100001, big.NewInt(1), key)); !errors.Is(err, ErrReplaceUnderpriced) {
 		t.Fatalf("original cheap pending transaction replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
 	}
 	if err := pool.addRemote(pricedTransaction(0, 100000, big.NewInt(2), key)); err != nil {
@@ -1751,7 +3893,7 @@ func TestReplacement(t *testing.T) {
 	if err := pool.addRemoteSync(pricedTransaction(0, 100000, big.NewInt(price), key)); err != nil {
 		t.Fatalf("failed to add original proper pending transaction: %v", err)
 	}
-	if err := pool.addRemote(pricedTransaction(0, 100001, big.NewInt(threshold-1), key)); err != ErrReplaceUnderpriced {
+	if err := pool.addRemote(pricedTransaction(0, 100001, big.NewInt(threshold-1), key)); !errors.Is(err, ErrReplaceUnderpriced) {
 		t.Fatalf("original proper pending transaction replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
 	}
 	if err := pool.addRemote(pricedTransaction(0, 100000, big.NewInt(threshold), key)); err != nil {
@@ -1765,7 +3907,7 @@ func TestReplacement(t *testing.T) {
 	if err := pool.addRemote(pricedTransaction(2, 100000, big.NewInt(1), key)); err != nil {
 		t.Fatalf("failed to add original cheap queued transaction: %v", err)
 	}
-	if err := pool.addRemote(pricedTransaction(2, 100001, big.NewInt(1), key)); err != ErrReplaceUnderpriced {
+	if err := pool.addRemote(pricedTransaction(2, 100001, big.NewInt(1), key)); !errors.Is(err, ErrReplaceUnderpriced) {
 		t.Fatalf("original cheap queued transaction replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
 	}
 	if err := pool.addRemote(pricedTransaction(2, 100000, big.NewInt(2), key)); err != nil {
@@ -1775,7 +3917,7 @@ func TestReplacement(t *testing.T) {
 	if err := pool.addRemote(pricedTransaction(2, 100000, big.NewInt(price), key)); err != nil {
 		t.Fatalf("failed to add original proper queued transaction: %v", err)
 	}
-	if err := pool.addRemote(pricedTransaction(2, 100001, big.NewInt(threshold-1), key)); err != ErrReplaceUnderpriced {
+	if err := pool.addRemote(pricedTransaction(2, 100001, big.NewInt(threshold-1), key)); !errors.Is(err, ErrReplaceUnderpriced) {
 		t.Fatalf("original proper queued transaction replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
 	}
 	if err := pool.addRemote(pricedTransaction(2, 100000, big.NewInt(threshold), key)); err != nil {
@@ -1790,12 +3932,91 @@ func TestReplacement(t *testing.T) {
 	}
 }
 
+// Tests that replacing a dynamic fee transaction requires bumping both its fee
+// cap and its tip, raising either one alone is not enough.
+func TestReplacementDynamicFee(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	var (
+		feeCap     = int64(100)
+		tip        = int64(50)
+		bump       = func(price int64) int64 { return price * (100 + int64(testTxPoolConfig.PriceBump)) / 100 }
+		feeCapBump = bump(feeCap)
+		tipBump    = bump(tip)
+	)
+	for _, nonce := range []uint64{0, 2} { // pending and queued
+		if err := pool.addRemoteSync(dynamicFeeTransaction(nonce, 100000, big.NewInt(feeCap), big.NewInt(tip), key)); err != nil {
+			t.Fatalf("nonce %d: failed to add original transaction: %v", nonce, err)
+		}
+		tests := []struct {
+			name        string
+			feeCap, tip int64
+			err         error
+		}{
+			{"cap bumped, tip kept", feeCapBump, tip, ErrReplaceUnderpriced},
+			{"cap bumped, tip short", feeCapBump, tipBump - 1, ErrReplaceUnderpriced},
+			{"tip bumped, cap kept", feeCap, tipBump, ErrReplaceUnderpriced},
+			{"tip bumped, cap short", feeCapBump - 1, tipBump, ErrReplaceUnderpriced},
+			{"both bumped", feeCapBump, tipBump, nil},
+		}
+		for _, tt := range tests {
+			err := pool.addRemoteSync(dynamicFeeTransaction(nonce, 100000, big.NewInt(tt.feeCap), big.NewInt(tt.tip), key))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("nonce %d, %s: replacement error mismatch: have %v, want %v", nonce, tt.name, err, tt.err)
+			}
+		}
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that replaying a journal claiming more content than it holds fails with
+// an error instead of trusting the corrupt length.
+func TestJournalCorrupt(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{JournalFormatJSON, JournalFormatRLP} {
+		file, err := os.CreateTemp("", "")
+		if err != nil {
+			t.Fatalf("failed to create temporary journal: %v", err)
+		}
+		defer os.Remove(file.Name())
+
+		// A valid transaction followed by an item claiming 4GB of content
+		serializer, _ := journalSerializer(format)
+		key, _ := crypto.GenerateKey()
+		if err := serializer.GetEncoder(file).Encode(transaction(0, 100000, key)); err != nil {
+			t.Fatalf("%s: failed to journal transaction: %v", format, err)
+		}
+		file.Write([]byte{0xbb, 0xff, 0xff, 0xff, 0xff})
+		file.Close()
+
+		var loaded int
+		err = newTxJournal(file.Name(), serializer).load(func(txs types.Transactions) []error {
+			loaded += len(txs)
+			return make([]error, len(txs))
+		})
+		if err == nil {
+			t.Errorf("%s: corrupt journal loaded without error", format)
+		}
+		if loaded != 1 {
+			t.Errorf("%s: loaded transaction count mismatch: have %d, want %d", format, loaded, 1)
+		}
+	}
+}
+
 // Tests that local transactions are journaled to disk, but remote transactions
 // get discarded between restarts.
-func TestJournaling(t *testing.T)         { testJournaling(t, false) }
-func TestJournalingNoLocals(t *testing.T) { testJournaling(t, true) }
+func TestJournaling(t *testing.T)         { testJournaling(t, false, JournalFormatJSON) }
+func TestJournalingNoLocals(t *testing.T) { testJournaling(t, true, JournalFormatJSON) }
+func TestJournalingRLP(t *testing.T)      { testJournaling(t, false, JournalFormatRLP) }
 
-func testJournaling(t *testing.T, nolocals bool) {
+func testJournaling(t *testing.T, nolocals bool, format string) {
 	t.Parallel()
 
 	// Create a temporary file for the journal
@@ -1817,6 +4038,7 @@ func testJournaling(t *testing.T, nolocals bool) {
 	config := testTxPoolConfig
 	config.NoLocals = nolocals
 	config.Journal = journal
+	config.JournalFormat = format
 	config.Rejournal = time.Second
 
 	pool := New(config, blockchain)
@@ -1906,6 +4128,104 @@ func testJournaling(t *testing.T, nolocals bool) {
 	pool.Close()
 }
 
+// Tests that only the local pending transactions are published on the
+// reannounce feed, leaving out queued and remote ones.
+func TestReannounceLocals(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	events := make(chan ReannoTxsEvent, 1)
+	sub := pool.SubscribeReannoTxsEvent(events)
+	defer sub.Unsubscribe()
+
+	remote, _ := crypto.GenerateKey()
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+	testAddBalance(pool, crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+
+	local := transaction(0, 100000, key)
+	if err := pool.addLocal(local); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	if err := pool.addLocal(transaction(2, 100000, key)); err != nil {
+		t.Fatalf("failed to add queued local transaction: %v", err)
+	}
+	if err := pool.addRemoteSync(transaction(0, 100000, remote)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	pool.reannounce()
+
+	select {
+	case ev := <-events:
+		if len(ev.Txs) != 1 {
+			t.Fatalf("reannounced transaction count mismatch: have %d, want %d", len(ev.Txs), 1)
+		}
+		if ev.Txs[0].TxHash != local.TxHash {
+			t.Fatalf("reannounced transaction mismatch: have %x, want %x", ev.Txs[0].TxHash, local.TxHash)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("reannounce event not fired")
+	}
+}
+
+// Tests that adding local transactions reports errors in input order and migrates
+// the already pooled remote transactions of the senders to locals.
+func TestAddLocalsMigratesRemotes(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	remote := transaction(0, 100000, key)
+	if err := pool.addRemoteSync(remote); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	negative := transaction(2, 100000, key)
+	negative.Value = big.NewInt(-1)
+
+	errs := pool.AddLocals([]*types.Transaction{transaction(1, 100000, key), remote, negative})
+	if errs[0] != nil {
+		t.Errorf("local transaction error mismatch: have %v, want nil", errs[0])
+	}
+	if !errors.Is(errs[1], ErrAlreadyKnown) {
+		t.Errorf("known transaction error mismatch: have %v, want %v", errs[1], ErrAlreadyKnown)
+	}
+	if !errors.Is(errs[2], ErrNegativeValue) {
+		t.Errorf("invalid transaction error mismatch: have %v, want %v", errs[2], ErrNegativeValue)
+	}
+	if locals, remotes := pool.all.LocalCount(), pool.all.RemoteCount(); locals != 2 || remotes != 0 {
+		t.Errorf("lookup split mismatch: have %d locals %d remotes, want 2 locals 0 remotes", locals, remotes)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that closing the pool multiple times is safe and that transactions added
+// after shutdown are rejected.
+func TestCloseRejectsTransactions(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupPool()
+	testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	if err := pool.Close(); err != nil {
+		t.Fatalf("failed to close pool: %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Fatalf("failed to close pool twice: %v", err)
+	}
+	if err := pool.addRemoteSync(transaction(0, 100000, key)); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("closed pool error mismatch: have %v, want %v", err, ErrPoolClosed)
+	}
+	if pending, queued := pool.Stats(); pending+queued != 0 {
+		t.Fatalf("closed pool accepted transactions: pending %d, queued %d", pending, queued)
+	}
+}
+
 // TestStatusCheck tests that the pool can correctly retrieve the
 // pending status of individual transactions.
 func TestStatusCheck(t *testing.T) {
@@ -1954,11 +4274,251 @@ func TestStatusCheck(t *testing.T) {
 	hashes = append(hashes, common.Hash{})
 	expect := []TxStatus{TxStatusPending, TxStatusPending, TxStatusQueued, TxStatusQueued, TxStatusUnknown}
 
-	for i := 0; i < len(hashes); i++ {
-		if status := pool.Status(hashes[i]); status != expect[i] {
+	for i, status := range pool.Status(hashes) {
+		if status != expect[i] {
 			t.Errorf("transaction %d: status mismatch: have %v, want %v", i, status, expect[i])
 		}
 	}
+	// Mine the pending only transaction and ensure it's reported as included
+	testSetNonce(pool, crypto.PubkeyToAddress(keys[0].PublicKey), 1)
+	<-pool.requestReset(nil, nil)
+
+	expect[0] = TxStatusIncluded
+	for i, status := range pool.Status(hashes) {
+		if status != expect[i] {
+			t.Errorf("transaction %d: status mismatch after mining: have %v, want %v", i, status, expect[i])
+		}
+	}
+}
+
+// Tests that accounts churned through the pool don't leave empty lists or stale
+// heartbeats behind once all their transactions are gone.
+func TestAccountCleanup(t *testing.T) {
+	t.Parallel()
+
+	pool, _ := setupPool()
+	defer pool.Close()
+
+	// Churn accounts through executable, replaced and future transactions
+	keys := make([]*ecdsa.PrivateKey, 64)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000000))
+	}
+	for i, key := range keys {
+		txs := []*types.Transaction{
+			pricedTransaction(0, 100000, big.NewInt(1), key),
+			pricedTransaction(1, 100000, big.NewInt(1), key),
+		}
+		if i%2 == 0 {
+			txs = append(txs, pricedTransaction(5, 100000, big.NewInt(1), key))
+		}
+		for j, err := range pool.addRemotesSync(txs) {
+			if err != nil {
+				t.Fatalf("account %d: failed to add transaction %d: %v", i, j, err)
+			}
+		}
+		if i%4 < 2 {
+			if err := pool.addRemoteSync(pricedTransaction(1, 100000, big.NewInt(2), key)); err != nil {
+				t.Fatalf("account %d: failed to replace transaction: %v", i, err)
+			}
+		}
+	}
+	if pending, queued := pool.Stats(); pending != 128 || queued != 32 {
+		t.Fatalf("pool state mismatch: have %d pending %d queued, want 128 pending 32 queued", pending, queued)
+	}
+	// Drop some replaced accounts by hand, newest transaction first
+	pool.mu.Lock()
+	for i := 1; i < len(keys); i += 8 {
+		from := crypto.PubkeyToAddress(keys[i].PublicKey)
+		for _, tx := range []*types.Transaction{pool.pending[from].txs.Get(1), pool.pending[from].txs.Get(0)} {
+			pool.removeTx(tx.Hash(), true)
+		}
+	}
+	pool.mu.Unlock()
+
+	// Mine everything, half the accounts in an intermediate block
+	for i, key := range keys {
+		if i%2 == 1 {
+			testSetNonce(pool, crypto.PubkeyToAddress(key.PublicKey), 2)
+		}
+	}
+	<-pool.requestReset(nil, nil)
+	for _, key := range keys {
+		testSetNonce(pool, crypto.PubkeyToAddress(key.PublicKey), 6)
+	}
+	<-pool.requestReset(nil, nil)
+
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	if len(pool.pending) != 0 || len(pool.queue) != 0 || len(pool.beats) != 0 {
+		t.Errorf("accounts leaked: have %d pending, %d queued, %d heartbeats, want none", len(pool.pending), len(pool.queue), len(pool.beats))
+	}
+	if count := pool.all.Count(); count != 0 {
+		t.Errorf("transactions leaked: have %d, want 0", count)
+	}
+}
+
+// Tests that packing a block picks the best tipping transactions across accounts
+// while keeping the nonce order of each, stays within the gas limit and leaves
+// the pool untouched.
+func TestPackBlock(t *testing.T) {
+	t.Parallel()
+
+	pool, _ := setupPool()
+	defer pool.Close()
+
+	keys := make([]*ecdsa.PrivateKey, 4)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		testAddBalance(pool, crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000000))
+	}
+	var (
+		a = []*types.Transaction{
+			dynamicFeeTransaction(0, 21000, big.NewInt(100), big.NewInt(10), keys[0]),
+			dynamicFeeTransaction(1, 21000, big.NewInt(100), big.NewInt(10), keys[0]),
+			dynamicFeeTransaction(2, 21000, big.NewInt(100), big.NewInt(10), keys[0]),
+		}
+		// The second transaction tips most, but must wait for the first one
+		b = []*types.Transaction{
+			dynamicFeeTransaction(0, 21000, big.NewInt(100), big.NewInt(5), keys[1]),
+			dynamicFeeTransaction(1, 21000, big.NewInt(100), big.NewInt(50), keys[1]),
+		}
+		// Can't pay the base fee
+		c = []*types.Transaction{
+			dynamicFeeTransaction(0, 21000, big.NewInt(5), big.NewInt(5), keys[2]),
+		}
+		// Tips well, but is big
+		d = []*types.Transaction{
+			dynamicFeeTransaction(0, 100000, big.NewInt(100), big.NewInt(20), keys[3]),
+		}
+	)
+	for _, txs := range [][]*types.Transaction{a, b, c, d} {
+		for i, err := range pool.addRemotesSync(txs) {
+			if err != nil {
+				t.Fatalf("failed to add transaction %d: %v", i, err)
+			}
+		}
+	}
+	baseFee := big.NewInt(10)
+
+	tests := []struct {
+		gasLimit uint64
+		want     []*types.Transaction
+	}{
+		{1000000, []*types.Transaction{d[0], a[0], a[1], a[2], b[0], b[1]}},
+		{205000, []*types.Transaction{d[0], a[0], a[1], a[2], b[0], b[1]}},
+		{204999, []*types.Transaction{d[0], a[0], a[1], a[2], b[0]}},
+		{50000, []*types.Transaction{a[0], a[1]}},
+		{20000, nil},
+	}
+	for i, tt := range tests {
+		packed := pool.PackBlock(tt.gasLimit, baseFee)
+
+		var (
+			gas    uint64
+			nonces = make(map[common.Address]uint64)
+		)
+		for _, tx := range packed {
+			if tx.Nonce != nonces[tx.From] {
+				t.Errorf("test %d: nonce gap for %x: have %d, want %d", i, tx.From, tx.Nonce, nonces[tx.From])
+			}
+			nonces[tx.From] = tx.Nonce + 1
+			gas += tx.GasLimit
+		}
+		if gas > tt.gasLimit {
+			t.Errorf("test %d: packed gas exceeds limit: have %d, limit %d", i, gas, tt.gasLimit)
+		}
+		if len(packed) != len(tt.want) {
+			t.Errorf("test %d: packed count mismatch: have %d, want %d", i, len(packed), len(tt.want))
+			continue
+		}
+		for j := range tt.want {
+			if packed[j] != tt.want[j] {
+				t.Errorf("test %d: packed transaction %d mismatch: have %x/%d, want %x/%d", i, j, packed[j].From, packed[j].Nonce, tt.want[j].From, tt.want[j].Nonce)
+			}
+		}
+	}
+	if pending, queued := pool.Stats(); pending != 7 || queued != 0 {
+		t.Fatalf("pool modified by packing: have %d pending %d queued, want 7 pending 0 queued", pending, queued)
+	}
+	if err := validatePoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that the pending diff reports the net changes to the pending set since a
+// sequence number, and falls back to the full set once the changes are evicted.
+func TestPendingDiff(t *testing.T) {
+	t.Parallel()
+
+	statedb := state.NewEasyStateDB()
+	blockchain := NewEasyBlockChain(nil, 10000000, statedb, new(event.Feed))
+
+	config := testTxPoolConfig
+	config.PendingDiffSlots = 4
+
+	pool := New(config, blockchain)
+	if err := pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock()); err != nil {
+		t.Fatalf("failed to init pool: %v", err)
+	}
+	defer pool.Close()
+
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, from, big.NewInt(1000000000))
+
+	txs := types.Transactions{transaction(0, 100000, key), transaction(1, 100000, key), transaction(2, 100000, key), transaction(3, 100000, key)}
+	for i, err := range pool.addRemotesSync(txs[:3]) {
+		if err != nil {
+			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
+		}
+	}
+	check := func(since uint64, wantAdded, wantRemoved types.Transactions, wantSeq uint64) {
+		t.Helper()
+
+		added, removed, seq := pool.PendingDiff(since)
+		if seq != wantSeq {
+			t.Errorf("since %d: sequence mismatch: have %d, want %d", since, seq, wantSeq)
+		}
+		sort.Sort(types.TxByNonce(added))
+		if len(added) != len(wantAdded) {
+			t.Fatalf("since %d: added count mismatch: have %d, want %d", since, len(added), len(wantAdded))
+		}
+		for i := range added {
+			if added[i].Hash() != wantAdded[i].Hash() {
+				t.Errorf("since %d: added tx %d mismatch: have %x, want %x", since, i, added[i].Hash(), wantAdded[i].Hash())
+			}
+		}
+		if len(removed) != len(wantRemoved) {
+			t.Fatalf("since %d: removed count mismatch: have %d, want %d", since, len(removed), len(wantRemoved))
+		}
+		for i := range removed {
+			if removed[i].Hash() != wantRemoved[i].Hash() {
+				t.Errorf("since %d: removed tx %d mismatch: have %x, want %x", since, i, removed[i].Hash(), wantRemoved[i].Hash())
+			}
+		}
+	}
+	check(0, txs[:3], nil, 3)
+
+	// Mine the first transaction and ensure only its removal is reported
+	testSetNonce(pool, from, 1)
+	<-pool.requestReset(nil, nil)
+
+	check(3, nil, txs[:1], 4)
+	check(4, nil, nil, 4)
+
+	// Changes cancelling each other out should be folded away
+	check(0, txs[1:3], nil, 4)
+
+	// Overflow the retained changes and ensure the full set is returned
+	if err := pool.addRemoteSync(txs[3]); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	check(0, txs[1:4], nil, 5)
+	check(6, txs[1:4], nil, 5)
+	check(1, txs[1:4], txs[:1], 5)
 }
 
 func BenchmarkPendingDemotion100(b *testing.B)   { benchmarkPendingDemotion(b, 100) }
@@ -2009,6 +4569,88 @@ func benchmarkFuturePromotion(b *testing.B, size int) {
 	}
 }
 
+// Benchmarks a burst of single transaction additions from the same account,
+// reporting how many promotion runs they were coalesced into. Promote requests
+// which arrive while a reorg is running are merged into the next one, so the
+// async burst needs far fewer runs than waiting for each addition.
+func BenchmarkPromoteBurstAsync(b *testing.B) { benchmarkPromoteBurst(b, false) }
+func BenchmarkPromoteBurstSync(b *testing.B)  { benchmarkPromoteBurst(b, true) }
+
+func benchmarkPromoteBurst(b *testing.B, sync bool) {
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	txs := make(types.Transactions, b.N)
+	for i := 0; i < b.N; i++ {
+		txs[i] = transaction(uint64(i), 100000, key)
+	}
+	// Mirror addTxs, collecting the reorg runs the additions were scheduled into
+	runs := make(map[chan struct{}]struct{})
+
+	b.ResetTimer()
+	for _, tx := range txs {
+		pool.mu.Lock()
+		_, dirty := pool.addTxsLocked([]*types.Transaction{tx}, false)
+		pool.mu.Unlock()
+
+		done := pool.requestPromoteExecutables(dirty)
+		if sync {
+			<-done
+		}
+		runs[done] = struct{}{}
+	}
+	pool.Sync()
+	b.ReportMetric(float64(len(runs))/float64(b.N), "promotions/op")
+}
+
+// Benchmarks the speed of basic transaction validation, with the sender being
+// either recovered anew each time or served from the transaction's cache.
+func BenchmarkValidateBasicsRecover(b *testing.B) { benchmarkValidateBasics(b, false) }
+func BenchmarkValidateBasicsCached(b *testing.B)  { benchmarkValidateBasics(b, true) }
+
+func benchmarkValidateBasics(b *testing.B, cached bool) {
+	pool, key := setupPool()
+	defer pool.Close()
+
+	tx := transaction(0, 100000, key)
+
+	// Benchmark the speed of repeated validation
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vtx := tx
+		if !cached {
+			vtx = &types.Transaction{TxPreface: tx.TxPreface, TxInner: tx.TxInner, TxExtends: tx.TxExtends}
+		}
+		if err := pool.validateTxBasics(vtx, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Benchmarks the speed of computing the slots taken by a transaction, with the
+// size being either encoded anew each time or served from the transaction's cache.
+func BenchmarkNumSlotsEncode(b *testing.B) { benchmarkNumSlots(b, false) }
+func BenchmarkNumSlotsCached(b *testing.B) { benchmarkNumSlots(b, true) }
+
+func benchmarkNumSlots(b *testing.B, cached bool) {
+	key, _ := crypto.GenerateKey()
+	tx := pricedDataTransaction(0, 100000, big.NewInt(1), key, 1024)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stx := tx
+		if !cached {
+			stx = &types.Transaction{TxPreface: tx.TxPreface, TxInner: tx.TxInner, TxExtends: tx.TxExtends}
+		}
+		if numSlots(stx) != 1 {
+			b.Fatal("slot count mismatch")
+		}
+	}
+}
+
 // Benchmarks the speed of batched transaction insertion.
 func BenchmarkBatchInsert100(b *testing.B)   { benchmarkBatchInsert(b, 100, false) }
 func BenchmarkBatchInsert1000(b *testing.B)  { benchmarkBatchInsert(b, 1000, false) }
@@ -2044,6 +4686,42 @@ func benchmarkBatchInsert(b *testing.B, size int, local bool) {
 	}
 }
 
+// Benchmarks re-adding a batch of freshly decoded copies of recently mined
+// transactions, with the mined hashes either remembered or forgotten.
+func BenchmarkReAddMinedCached(b *testing.B)   { benchmarkReAddMined(b, true) }
+func BenchmarkReAddMinedUncached(b *testing.B) { benchmarkReAddMined(b, false) }
+
+func benchmarkReAddMined(b *testing.B, cached bool) {
+	pool, key := setupPool()
+	defer pool.Close()
+
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	testAddBalance(pool, account, big.NewInt(1000000000000000000))
+
+	txs := make(types.Transactions, 100)
+	for i := range txs {
+		txs[i] = transaction(uint64(i), 100000, key)
+	}
+	pool.addRemotesSync(txs)
+	testSetNonce(pool, account, uint64(len(txs)))
+	<-pool.requestReset(nil, nil)
+
+	batches := make([]types.Transactions, b.N)
+	for i := range batches {
+		batches[i] = make(types.Transactions, len(txs))
+		for j, tx := range txs {
+			batches[i][j] = tx.Copy()
+		}
+	}
+	b.ResetTimer()
+	for _, batch := range batches {
+		if !cached {
+			pool.mined.Purge()
+		}
+		pool.addRemotes(batch)
+	}
+}
+
 func BenchmarkInsertRemoteWithAllLocals(b *testing.B) {
 	// Allocate keys for testing
 	key, _ := crypto.GenerateKey()
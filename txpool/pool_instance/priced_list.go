@@ -1,8 +1,8 @@
 package txpool_instance
 
 import (
+	"bytes"
 	"container/heap"
-	"execution/common"
 	"execution/types"
 	"math/big"
 	"sync"
@@ -22,19 +22,30 @@ type priceHeap struct {
 func (h *priceHeap) Len() int      { return len(h.list) }
 func (h *priceHeap) Swap(i, j int) { h.list[i], h.list[j] = h.list[j], h.list[i] }
 
+// Less orders transactions by price, evicting the cheapest first. Price ties are
+// broken by hash, so the order depends neither on the order the transactions were
+// added in, nor on the senders and nonces of unrelated accounts.
 func (h *priceHeap) Less(i, j int) bool {
 	switch h.cmp(h.list[i], h.list[j]) {
 	case -1:
 		return true
 	case 1:
 		return false
-	default:
-		return (h.list[i]).Nonce > (h.list[j]).Nonce
 	}
+	ha, hb := h.list[i].Hash(), h.list[j].Hash()
+	return bytes.Compare(ha[:], hb[:]) < 0
 }
 
 func (h *priceHeap) cmp(a, b *types.Transaction) int {
-	return a.GasPrice.Price.Cmp(b.GasPrice.Price)
+	if h.baseFee != nil {
+		// Compare effective tips if baseFee is specified
+		if c := a.GasPrice.EffectiveTip(h.baseFee).Cmp(b.GasPrice.EffectiveTip(h.baseFee)); c != 0 {
+			return c
+		}
+	}
+	// Compare fee caps if baseFee is not specified or effective tips are equal,
+	// then tips if the fee caps are equal too
+	return a.GasPrice.Cmp(b.GasPrice)
 }
 
 func (h *priceHeap) Push(x interface{}) {
@@ -69,18 +80,26 @@ type PricedList struct {
 	all              *Lookup    // Pointer to the map of all transactions
 	urgent, floating priceHeap  // Heaps of prices of all the stored **remote** transactions
 	reheapMu         sync.Mutex // Mutex asserts that only one routine is reheaping the list
+
+	staleRatio int  // Percentage of stale price points tolerated before re-heaping
+	lazy       bool // Whether stale price points are only dropped when reached, never re-heaped
 }
 
 const (
 	// urgentRatio : floatingRatio is the capacity ratio of the two queues
 	urgentRatio   = 4
 	floatingRatio = 1
+
+	// defaultStaleRatio is the percentage of stale price points tolerated before
+	// re-heaping, unless configured otherwise.
+	defaultStaleRatio = 25
 )
 
 // newPricedList creates a new price-sorted transaction heap.
 func NewPricedList(all *Lookup) *PricedList {
 	return &PricedList{
-		all: all,
+		all:        all,
+		staleRatio: defaultStaleRatio,
 	}
 }
 
@@ -96,10 +115,14 @@ func (l *PricedList) Put(tx *types.Transaction, local bool) {
 // Removed notifies the prices transaction list that an old transaction dropped
 // from the pool. The list will just keep a counter of stale objects and update
 // the heap if a large enough ratio of transactions go stale.
+//
+// In lazy mode the heap is never rebuilt here: stale price points are popped when
+// they surface at the top of a heap, and all of them are purged by the re-heap on
+// the next base fee update.
 func (l *PricedList) Removed(count int) {
-	// Bump the stale counter, but exit if still too low (< 25%)
+	// Bump the stale counter, but exit if still too low (< staleRatio%)
 	stales := l.stales.Add(int64(count))
-	if int(stales) <= (len(l.urgent.list)+len(l.floating.list))/4 {
+	if l.lazy || int(stales)*100 <= (len(l.urgent.list)+len(l.floating.list))*l.staleRatio {
 		return
 	}
 	// Seems we've reached a critical number of stale transactions, reheap
@@ -122,7 +145,7 @@ func (l *PricedList) underpricedFor(h *priceHeap, tx *types.Transaction) bool {
 	// Discard stale price points if found at the heap start
 	for len(h.list) > 0 {
 		head := h.list[0]
-		if l.all.GetRemote((head).TxHash) == nil { // Removed or migrated
+		if l.all.GetRemote(head.Hash()) == nil { // Removed or migrated
 			l.stales.Add(-1)
 			heap.Pop(h)
 			continue
@@ -149,7 +172,7 @@ func (l *PricedList) Discard(slots int, force bool) (types.Transactions, bool) {
 		if len(l.urgent.list)*floatingRatio > len(l.floating.list)*urgentRatio {
 			// Discard stale transactions if found during cleanup
 			tx := heap.Pop(&l.urgent).(*types.Transaction)
-			if l.all.GetRemote(tx.TxHash) == nil { // Removed or migrated
+			if l.all.GetRemote(tx.Hash()) == nil { // Removed or migrated
 				l.stales.Add(-1)
 				continue
 			}
@@ -162,7 +185,7 @@ func (l *PricedList) Discard(slots int, force bool) (types.Transactions, bool) {
 			}
 			// Discard stale transactions if found during cleanup
 			tx := heap.Pop(&l.floating).(*types.Transaction)
-			if l.all.GetRemote(tx.TxHash) == nil { // Removed or migrated
+			if l.all.GetRemote(tx.Hash()) == nil { // Removed or migrated
 				l.stales.Add(-1)
 				continue
 			}
@@ -187,11 +210,7 @@ func (l *PricedList) Reheap() {
 	defer l.reheapMu.Unlock()
 	start := time.Now()
 	l.stales.Store(0)
-	l.urgent.list = make([]*types.Transaction, 0, l.all.RemoteCount())
-	l.all.Range(func(hash common.Hash, tx *types.Transaction, local bool) bool {
-		l.urgent.list = append(l.urgent.list, tx)
-		return true
-	}, false, true) // Only iterate remotes
+	l.urgent.list = l.all.Flatten(false, true) // Only iterate remotes
 	heap.Init(&l.urgent)
 
 	// balance out the two heaps by moving the worse half of transactions into the
@@ -210,7 +229,12 @@ func (l *PricedList) Reheap() {
 
 // SetBaseFee updates the base fee and triggers a re-heap. Note that Removed is not
 // necessary to call right before SetBaseFee when processing a new block.
+//
+// Only the urgent heap sorts by effective tip under the base fee. The floating
+// heap targets later blocks with an unknown base fee, so it keeps sorting by fee
+// cap and its base fee stays nil.
 func (l *PricedList) SetBaseFee(baseFee *big.Int) {
 	l.urgent.baseFee = baseFee
+	l.floating.baseFee = nil
 	l.Reheap()
 }
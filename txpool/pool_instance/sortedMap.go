@@ -2,12 +2,14 @@ package txpool_instance
 
 import (
 	"execution/types"
+	"math"
 	"math/big"
 )
 
 type SortedMap struct {
 	items map[uint64]*types.Transaction // Hash map storing the transaction data
 	tree  *AVLTree                      // AVL tree of nonces of all the stored transactions (non-strict mode)
+	cache types.Transactions            // Cache of the transactions already sorted
 }
 
 func NewSortedMap() *SortedMap {
@@ -26,10 +28,61 @@ func (m *SortedMap) GetCost(nonce uint64) *big.Int {
 	return cost
 }
 
+// SubTotalCost returns the cumulative cost of all transactions with a nonce lower
+// than the given threshold.
+func (m *SortedMap) SubTotalCost(threshold uint64) *big.Int {
+	if threshold == 0 {
+		return new(big.Int)
+	}
+	_, cost := m.tree.Search(threshold - 1)
+	return cost
+}
+
+// TotalCost returns the cumulative cost of all transactions in the map.
+func (m *SortedMap) TotalCost() *big.Int {
+	return m.tree.Sum()
+}
+
 func (m *SortedMap) Put(tx *types.Transaction) {
 	nonce := tx.Nonce
 	m.items[nonce] = tx
 	m.tree.Add(nonce, tx.Cost())
+	m.cache = nil
+}
+
+// Merge inserts all transactions of other into the map, leaving other untouched.
+// Transactions colliding on a nonce are resolved by onConflict, which is handed
+// the contained and the incoming transaction and returns the one to keep. A nil
+// callback keeps the higher priced one, preferring the contained one on a tie.
+// The transactions losing a conflict are returned in nonce order.
+func (m *SortedMap) Merge(other *SortedMap, onConflict func(a, b *types.Transaction) *types.Transaction) types.Transactions {
+	if onConflict == nil {
+		onConflict = keepHigherPriced
+	}
+	var dropped types.Transactions
+	for _, tx := range other.Flatten() {
+		old := m.items[tx.Nonce]
+		if old == nil {
+			m.Put(tx)
+			continue
+		}
+		if keep := onConflict(old, tx); keep == old {
+			dropped = append(dropped, tx)
+			continue
+		}
+		m.Put(tx)
+		dropped = append(dropped, old)
+	}
+	return dropped
+}
+
+// keepHigherPriced is the default conflict resolution of Merge, keeping the
+// transaction paying more and the first one on a tie.
+func keepHigherPriced(a, b *types.Transaction) *types.Transaction {
+	if b.GasPrice.Cmp(a.GasPrice) > 0 {
+		return b
+	}
+	return a
 }
 
 func (m *SortedMap) Forward(threshold uint64) types.Transactions {
@@ -44,6 +97,10 @@ func (m *SortedMap) Forward(threshold uint64) types.Transactions {
 		m.tree.Remove(nonce)
 		delete(m.items, nonce)
 	}
+	// If we had a cached order, shift the front
+	if m.cache != nil {
+		m.cache = m.cache[len(remove):]
+	}
 	return remove
 }
 
@@ -56,6 +113,9 @@ func (m *SortedMap) Filter(filter func(*types.Transaction) bool) types.Transacti
 			m.tree.Remove(nonce)
 		}
 	}
+	if len(remove) > 0 {
+		m.cache = nil
+	}
 	return remove
 }
 
@@ -76,6 +136,30 @@ func (m *SortedMap) Cap(threshold int) types.Transactions {
 		m.tree.Remove(nonce)
 		size--
 	}
+	// If we had a cache, shift the back
+	if m.cache != nil {
+		m.cache = m.cache[:len(m.cache)-len(remove)]
+	}
+	return remove
+}
+
+// RemoveAbove removes all transactions with a nonce higher than the threshold,
+// returning them in nonce order. Only the removed range of the tree is visited,
+// and nothing at all if the largest nonce is within the threshold.
+func (m *SortedMap) RemoveAbove(threshold uint64) types.Transactions {
+	if largest, err := m.tree.Largest(); err != nil || largest <= threshold {
+		return nil
+	}
+	var remove types.Transactions
+	m.tree.Range(threshold+1, math.MaxUint64, func(nonce uint64, _ *big.Int) bool {
+		remove = append(remove, m.items[nonce])
+		return true
+	})
+	for _, tx := range remove {
+		delete(m.items, tx.Nonce)
+		m.tree.Remove(tx.Nonce)
+	}
+	m.cache = nil
 	return remove
 }
 
@@ -87,39 +171,47 @@ func (m *SortedMap) Remove(nonce uint64) bool {
 	}
 	delete(m.items, nonce)
 	m.tree.Remove(nonce)
+	m.cache = nil
 	return true
 }
 
 // Given the provided start nonce, Ready returns
 // transactions that are continous, the varible start is the virtual nonce.
 func (m *SortedMap) Ready(start uint64, threshold *big.Int) types.Transactions {
-	size := len(m.items)
-	if size == 0 {
+	return m.ReadyWithGasCap(start, threshold, math.MaxUint64)
+}
+
+// ReadyWithGasCap works like Ready, but additionally stops before the summed gas
+// limit of the returned transactions would exceed gasCap. Transactions beyond
+// either cap are left in the map.
+func (m *SortedMap) ReadyWithGasCap(start uint64, costThreshold *big.Int, gasCap uint64) types.Transactions {
+	if len(m.items) == 0 {
 		return nil
 	}
 	smallest, err := m.tree.Smallest()
 	if smallest > start || err != nil {
 		return nil
 	}
-
-	var ready types.Transactions
-	tx := m.items[smallest]
-	total := new(big.Int).Set(tx.Cost())
-
-	for next := smallest; size > 0 && smallest == next && total.Cmp(threshold) <= 0; next++ {
-		ready = append(ready, m.items[next])
+	var (
+		ready types.Transactions
+		total = new(big.Int)
+		gas   uint64
+	)
+	for next := smallest; err == nil && smallest == next; next++ {
+		tx := m.items[smallest]
+		if total.Add(total, tx.Cost()).Cmp(costThreshold) > 0 || tx.GasLimit > gasCap-gas {
+			break
+		}
+		gas += tx.GasLimit
+		ready = append(ready, tx)
 		m.tree.Remove(smallest)
 		delete(m.items, smallest)
-		size--
 
 		smallest, err = m.tree.Smallest()
-		if err != nil {
-			break
-		}
-		tx = m.items[smallest]
-		total = total.Add(total, tx.Cost())
 	}
-
+	if len(ready) > 0 {
+		m.cache = nil
+	}
 	return ready
 }
 
@@ -127,13 +219,45 @@ func (m *SortedMap) Len() int {
 	return len(m.items)
 }
 
+// Flatten creates a nonce-sorted slice of transactions. The result is cached and
+// shared until the next modification of the map, so it must not be modified in
+// place. Its capacity is clipped, appending to it never touches the cache.
 func (m *SortedMap) Flatten() types.Transactions {
+	if m.cache == nil {
+		m.cache = m.sorted()
+	}
+	return m.cache[:len(m.cache):len(m.cache)]
+}
+
+// Snapshot returns a nonce-sorted copy of the transactions, which the caller may
+// modify freely. Unlike Flatten it never builds the cache, it only reads the map
+// and is safe to call concurrently with other readers.
+func (m *SortedMap) Snapshot() types.Transactions {
+	if m.cache != nil {
+		return append(make(types.Transactions, 0, len(m.cache)), m.cache...)
+	}
+	return m.sorted()
+}
+
+// sorted collects the transactions in nonce order into a new slice.
+func (m *SortedMap) sorted() types.Transactions {
 	nodes := m.tree.Flatten()
-	cache := make(types.Transactions, 0, len(m.items))
+	txs := make(types.Transactions, 0, len(nodes))
 	for _, node := range nodes {
-		cache = append(cache, m.items[node.key])
+		txs = append(txs, m.items[node.key])
 	}
-	return cache
+	return txs
+}
+
+// Range returns the transactions with nonces within [lo, hi] in ascending order,
+// without flattening the entire map.
+func (m *SortedMap) Range(lo, hi uint64) types.Transactions {
+	var txs types.Transactions
+	m.tree.Range(lo, hi, func(nonce uint64, _ *big.Int) bool {
+		txs = append(txs, m.items[nonce])
+		return true
+	})
+	return txs
 }
 
 func (m *SortedMap) LastElement() *types.Transaction {
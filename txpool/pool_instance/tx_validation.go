@@ -1,11 +1,15 @@
 package txpool_instance
 
 import (
+	"errors"
 	"execution/common"
+	"execution/params"
 	"execution/state"
 	"execution/types"
+	"execution/types/gadget"
 	"fmt"
 	"math/big"
+	"strings"
 )
 
 // ValidationOptions define certain differences between transaction validation
@@ -13,6 +17,13 @@ import (
 type ValidationOptions struct {
 	MaxSize uint64   // Maximum size of a transaction that the caller can meaningfully handle
 	MinTip  *big.Int // Minimum gas tip needed to allow a transaction into the caller pool
+	ChainID *big.Int // Chain ID replay protected signatures must be made for, nil allows any
+
+	Config *params.ChainConfig // Chain whose gas schedule to charge, nil for the defaults
+
+	AllowHighS bool // Whether to accept malleable high-S signatures of legacy tooling
+
+	MaxGasPrice *big.Int // Maximum gas fee cap allowed into the caller pool, nil for no limit
 }
 
 // ValidateTransaction is a helper method to check whether a transaction is valid
@@ -29,46 +40,190 @@ func ValidateTransaction(tx *types.Transaction, head *types.Header, opts *Valida
 	default:
 		return fmt.Errorf("%w: tx type not supported by this pool", ErrTxTypeNotSupported)
 	}
-
-	if tx.Type() == types.NormalTx {
-		// Before performing any expensive validations, sanity check that the tx is
-		// smaller than the maximum limit the pool can meaningfully handle
-		if tx.Size() > opts.MaxSize {
-			return fmt.Errorf("%w: transaction size %v, limit %v", ErrOversizedData, tx.Size(), opts.MaxSize)
+	// Every transaction type pays for its inclusion. Transactions created using
+	// the RPC may lack a price, reject them before anything dereferences it.
+	if tx.GasPrice == nil || tx.GasPrice.GasFeeCap() == nil || tx.GasPrice.GasTipCap() == nil {
+		return ErrMissingGasPrice
+	}
+	// Transactions can't be negative. This may never happen using RLP decoded
+	// transactions but may occur for transactions created using the RPC, and
+	// would leave the transaction without an encoding.
+	if tx.Type() == types.NormalTx && tx.Value == nil {
+		return ErrMissingValue
+	}
+	if tx.Value != nil && tx.Value.Sign() < 0 {
+		return ErrNegativeValue
+	}
+	for _, price := range []*big.Int{tx.GasPrice.Price, tx.GasPrice.FeeCap, tx.GasPrice.Tip} {
+		if price != nil && price.Sign() < 0 {
+			return ErrNegativeGasPrice
 		}
-
-		// Transactions can't be negative. This may never happen using RLP decoded
-		// transactions but may occur for transactions created using the RPC.
-		if tx.Value.Sign() < 0 {
-			return ErrNegativeValue
+	}
+	// Each coin may only be spent once within a transaction, otherwise its amount
+	// would be counted multiple times
+	type outpoint struct {
+		hash  common.Hash
+		index uint32
+	}
+	spent := make(map[outpoint]struct{}, len(tx.InputCoins))
+	for i, coin := range tx.InputCoins {
+		op := outpoint{coin.TxHash, coin.Index}
+		if _, ok := spent[op]; ok {
+			return fmt.Errorf("%w: input %d spends %v:%d", ErrDuplicateInput, i, coin.TxHash, coin.Index)
 		}
-		// Ensure the transaction doesn't exceed the current block limit gas
-		if (*head).GasLimit() < tx.GasLimit {
-			return ErrGasLimit
+		spent[op] = struct{}{}
+	}
+	// Coins must carry value, a zero or negative amount could be used to skew the
+	// value accounting of the transaction
+	for i, coin := range tx.InputCoins {
+		if coin.Amount == nil || coin.Amount.Sign() <= 0 {
+			return fmt.Errorf("%w: input %d", ErrNonPositiveCoin, i)
 		}
-		// Sanity check for extremely large numbers (supported by RLP or RPC)
-		if tx.GasPrice.Price.BitLen() > 256 {
-			return ErrPriceVeryHigh
+	}
+	for i, coin := range tx.OutputCoins {
+		if coin.Amount == nil || coin.Amount.Sign() <= 0 {
+			return fmt.Errorf("%w: output %d", ErrNonPositiveCoin, i)
 		}
+	}
+	// Any other field the encoding can't represent leaves the transaction without
+	// a hash or size, reject it before either is relied upon
+	if _, err := tx.ComputeHash(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	// Before performing any expensive validations, sanity check that the tx is
+	// smaller than the maximum limit the pool can meaningfully handle
+	if tx.Size() > opts.MaxSize {
+		return fmt.Errorf("%w: transaction size %v, limit %v", ErrOversizedData, tx.Size(), opts.MaxSize)
+	}
+	// Ensure the transaction doesn't exceed the current block limit gas
+	if (*head).GasLimit() < tx.GasLimit {
+		return ErrGasLimit
+	}
+	// Sanity check for extremely large numbers (supported by RLP or RPC)
+	if tx.GasPrice.GasFeeCap().BitLen() > 256 {
+		return ErrPriceVeryHigh
+	}
+	if opts.MaxGasPrice != nil && tx.GasPrice.GasFeeCap().Cmp(opts.MaxGasPrice) > 0 {
+		return fmt.Errorf("%w: fee cap %v, limit %v", ErrPriceVeryHigh, tx.GasPrice.GasFeeCap(), opts.MaxGasPrice)
+	}
+	if tx.GasPrice.GasTipCap().BitLen() > 256 {
+		return ErrTipVeryHigh
+	}
+	// Ensure gasFeeCap is greater than or equal to gasTipCap
+	if tx.GasPrice.GasFeeCap().Cmp(tx.GasPrice.GasTipCap()) < 0 {
+		return ErrTipAboveFeeCap
+	}
 
-		// Make sure the transaction is signed properly
-		if _, err := tx.Validation.GetFrom(tx.TxHash); err != nil {
-			return ErrInvalidSender
+	switch tx.Type() {
+	case types.NormalTx:
+		if err := validateSignature(tx, opts); err != nil {
+			return err
 		}
 		// Ensure the transaction has more gas than the bare minimum needed to cover
 		// the transaction metadata
-		intrGas, err := tx.IntrinsicGas()
+		intrGas, err := tx.IntrinsicGas(opts.Config)
 		if err != nil {
 			return err
 		}
 		if tx.GasLimit < intrGas {
 			return fmt.Errorf("%w: needed %v, allowed %v", ErrIntrinsicGas, intrGas, tx.GasLimit)
 		}
-		if tx.GasPrice.Price.Cmp(opts.MinTip) < 0 {
-			return fmt.Errorf("%w: tip needed %v, tip permitted %v", ErrUnderpriced, opts.MinTip, tx.GasPrice)
+		if tx.GasPrice.GasTipCap().Cmp(opts.MinTip) < 0 {
+			return fmt.Errorf("%w: tip needed %v, tip permitted %v", ErrUnderpriced, opts.MinTip, tx.GasPrice.GasTipCap())
+		}
+
+	case types.WithdrawTx:
+		// Ensure the withdrawal actually pays out something
+		if len(tx.OutputCoins) == 0 {
+			return ErrEmptyOutputs
+		}
+		// Outputs must be listed canonically, so a withdrawal has a single encoding
+		if !tx.OutputsCanonical() {
+			return ErrUnorderedOutputs
+		}
+		// A withdrawal spends no coins, so it mustn't carry stray witnesses either
+		if err := validateWitnesses(tx); err != nil {
+			return err
+		}
+		if err := validateSignature(tx, opts); err != nil {
+			return err
+		}
+		if tx.GasPrice.GasTipCap().Cmp(opts.MinTip) < 0 {
+			return fmt.Errorf("%w: tip needed %v, tip permitted %v", ErrUnderpriced, opts.MinTip, tx.GasPrice.GasTipCap())
+		}
+
+	case types.RechargeTx:
+		// Recharges carry no sender signature, so make sure the advertised hash
+		// covers the transaction contents here
+		if tx.Hash() != tx.TxHash {
+			return ErrInvalidHash
+		}
+		// Every input coin must reference a witness signed by the coin owner over
+		// the spent coin, and every witness must be used by some input coin
+		if err := validateWitnesses(tx); err != nil {
+			return err
+		}
+		// The minted balance is backed by the input coins, so they must carry value
+		// and match the amount declared by the recharge, if any
+		sum := tx.InputSum()
+		if sum.Sign() <= 0 {
+			return fmt.Errorf("%w: no value in input coins", ErrRechargeImbalance)
+		}
+		if tx.Value != nil && tx.Value.Sign() != 0 && tx.Value.Cmp(sum) != 0 {
+			return fmt.Errorf("%w: value %v, input coins %v", ErrRechargeImbalance, tx.Value, sum)
+		}
+		if tx.GasPrice.GasTipCap().Cmp(opts.MinTip) < 0 {
+			return fmt.Errorf("%w: tip needed %v, tip permitted %v", ErrUnderpriced, opts.MinTip, tx.GasPrice.GasTipCap())
 		}
 	}
+	return nil
+}
+
+// validateWitnesses checks the input coins of a transaction against its witnesses,
+// telling witnesses that don't line up with the coins apart from ones failing to
+// authorize the spend of their coin, e.g. being signed by someone else than the
+// owner or over a different outpoint or amount.
+func validateWitnesses(tx *types.Transaction) error {
+	err := tx.VerifyCoinWitnesses()
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, types.ErrWitnessOutOfRange), errors.Is(err, types.ErrUnusedWitness):
+		return fmt.Errorf("%w: %v", ErrWitnessMismatch, err)
+	default:
+		return fmt.Errorf("%w: %v", ErrInvalidWitness, err)
+	}
+}
 
+// validateSignature ensures the advertised hash covers the transaction contents
+// and that the transaction was signed for the expected chain by the account it
+// claims to originate from.
+func validateSignature(tx *types.Transaction, opts *ValidationOptions) error {
+	// Make sure the advertised hash covers the transaction contents, otherwise
+	// it could shadow a different transaction in the pool
+	if tx.Hash() != tx.TxHash {
+		return ErrInvalidHash
+	}
+	// Fields left out of the signing hash could be altered by anyone relaying the
+	// transaction, changing its hash without touching the signature
+	if stray := tx.StrayFields(); len(stray) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnsignedFields, strings.Join(stray, ", "))
+	}
+	// Make sure the transaction is signed properly, for this chain and by the
+	// account it claims to originate from
+	if sig := tx.Validation; sig == nil || sig.V == nil || sig.R == nil || sig.S == nil {
+		return ErrInvalidSender
+	}
+	if id := tx.Validation.ChainID(); id != nil && opts.ChainID != nil && id.Cmp(opts.ChainID) != 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidSender, gadget.ErrInvalidChainId)
+	}
+	from, err := tx.RecoverSender(!opts.AllowHighS)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSender, err)
+	}
+	if from != tx.From {
+		return fmt.Errorf("%w: signed by %v, claims %v", ErrInvalidSender, from, tx.From)
+	}
 	return nil
 }
 
@@ -84,7 +239,8 @@ type ValidationOptionsWithState struct {
 	FirstNonceGap func(addr common.Address) uint64
 
 	// ExistingExpenditure is a mandatory callback to retrieve the cummulative
-	// cost of the already pooled transactions to check for overdrafts.
+	// cost of the already pooled transactions with a nonce lower than the given
+	// one to check for overdrafts.
 	ExistingExpenditure func(addr common.Address, nonce uint64) *big.Int
 
 	// ExistingCost is a mandatory callback to retrieve an already pooled
@@ -98,7 +254,9 @@ type ValidationOptionsWithState struct {
 // This check is public to allow different transaction pools to check the stateful
 // rules without duplicating code and running the risk of missed updates.
 func ValidateTransactionWithState(tx *types.Transaction, opts *ValidationOptionsWithState) error {
-	if tx.Type() == types.NormalTx {
+	// Normal transactions and withdrawals are both signed by and spend from the
+	// account they originate from, recharges mint funds instead
+	if typ := tx.Type(); typ == types.NormalTx || typ == types.WithdrawTx {
 		// Ensure the transaction adheres to nonce ordering
 		from := tx.From
 
@@ -125,17 +283,13 @@ func ValidateTransactionWithState(tx *types.Transaction, opts *ValidationOptions
 		// expansions without overdrafts
 		// this spent only considers all txs ahead of this tx
 		spent := opts.ExistingExpenditure(from, tx.Nonce)
-		if prev := opts.ExistingCost(from, tx.Nonce); prev != nil {
-			bump := new(big.Int).Sub(cost, prev)
-			need := new(big.Int).Add(spent, bump)
-			if balance.Cmp(need) < 0 {
+		need := new(big.Int).Add(spent, cost)
+		if balance.Cmp(need) < 0 {
+			if prev := opts.ExistingCost(from, tx.Nonce); prev != nil {
+				bump := new(big.Int).Sub(cost, prev)
 				return fmt.Errorf("%w: balance %v, queued cost %v, tx bumped %v, overshot %v", ErrInsufficientFunds, balance, spent, bump, new(big.Int).Sub(need, balance))
 			}
-		} else {
-			need := new(big.Int).Add(spent, cost)
-			if balance.Cmp(need) < 0 {
-				return fmt.Errorf("%w: balance %v, queued cost %v, tx cost %v, overshot %v", ErrInsufficientFunds, balance, spent, cost, new(big.Int).Sub(need, balance))
-			}
+			return fmt.Errorf("%w: balance %v, queued cost %v, tx cost %v, overshot %v", ErrInsufficientFunds, balance, spent, cost, new(big.Int).Sub(need, balance))
 		}
 	}
 	return nil
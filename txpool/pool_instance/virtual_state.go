@@ -32,7 +32,7 @@ type Noncer struct {
 	lock     sync.Mutex
 }
 
-// newNoncer creates a new virtual state database to track the pool nonces.
+// NewNoncer creates a new virtual state database to track the pool nonces.
 func NewNoncer(statedb state.StateDB) *Noncer {
 	return &Noncer{
 		fallback: statedb.Copy(),
@@ -40,7 +40,7 @@ func NewNoncer(statedb state.StateDB) *Noncer {
 	}
 }
 
-// get returns the current nonce of an account, falling back to a real state
+// Get returns the current nonce of an account, falling back to a real state
 // database if the account is unknown.
 func (txn *Noncer) Get(addr common.Address) uint64 {
 	// We use mutex for get operation is the underlying
@@ -56,7 +56,7 @@ func (txn *Noncer) Get(addr common.Address) uint64 {
 	return txn.nonces[addr]
 }
 
-// set inserts a new virtual nonce into the virtual state database to be returned
+// Set inserts a new virtual nonce into the virtual state database to be returned
 // whenever the pool requests it instead of reaching into the real state database.
 func (txn *Noncer) Set(addr common.Address, nonce uint64) {
 	txn.lock.Lock()
@@ -65,7 +65,18 @@ func (txn *Noncer) Set(addr common.Address, nonce uint64) {
 	txn.nonces[addr] = nonce
 }
 
-// setIfLower updates a new virtual nonce into the virtual state database if the
+// Seed inserts a virtual nonce for an account unless one is already tracked,
+// sparing a later fallback read from the real state database.
+func (txn *Noncer) Seed(addr common.Address, nonce uint64) {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+
+	if _, ok := txn.nonces[addr]; !ok {
+		txn.nonces[addr] = nonce
+	}
+}
+
+// SetIfLower updates a new virtual nonce into the virtual state database if the
 // new one is lower.
 func (txn *Noncer) SetIfLower(addr common.Address, nonce uint64) {
 	txn.lock.Lock()
@@ -82,7 +93,7 @@ func (txn *Noncer) SetIfLower(addr common.Address, nonce uint64) {
 	txn.nonces[addr] = nonce
 }
 
-// setAll sets the nonces for all accounts to the given map.
+// SetAll sets the nonces for all accounts to the given map.
 func (txn *Noncer) SetAll(all map[common.Address]uint64) {
 	txn.lock.Lock()
 	defer txn.lock.Unlock()
@@ -10,6 +10,8 @@ type Header interface {
 	ParentHash() common.Hash
 	Number() *big.Int
 	GasLimit() uint64
+	GasUsed() uint64
+	BaseFee() *big.Int // nil for blocks without an EIP-1559 base fee
 }
 
 type Body interface {
@@ -17,6 +19,7 @@ type Body interface {
 }
 
 type Block interface {
+	Header() Header
 	Hash() common.Hash
 	ParentHash() common.Hash
 	NumberU64() uint64
@@ -29,14 +32,20 @@ type EasyHeader struct {
 	parentHash common.Hash
 	number     *big.Int
 	gasLimit   uint64
+	gasUsed    uint64
+	baseFee    *big.Int
 }
 
-func NewEasyHeader(hash common.Hash, parentHash common.Hash, number *big.Int, gasLimit uint64) *EasyHeader {
+// NewEasyHeader creates a block header. The base fee is nil for blocks priced
+// without EIP-1559, which callers treat as legacy pricing.
+func NewEasyHeader(hash common.Hash, parentHash common.Hash, number *big.Int, gasLimit uint64, gasUsed uint64, baseFee *big.Int) *EasyHeader {
 	return &EasyHeader{
 		hash:       hash,
 		parentHash: parentHash,
 		number:     number,
 		gasLimit:   gasLimit,
+		gasUsed:    gasUsed,
+		baseFee:    baseFee,
 	}
 }
 
@@ -56,6 +65,14 @@ func (header *EasyHeader) GasLimit() uint64 {
 	return header.gasLimit
 }
 
+func (header *EasyHeader) GasUsed() uint64 {
+	return header.gasUsed
+}
+
+func (header *EasyHeader) BaseFee() *big.Int {
+	return header.baseFee
+}
+
 type EasyBody struct {
 	transactions Transactions
 }
@@ -82,6 +99,10 @@ func NewEasyBlock(header Header, body Body) *EasyBlock {
 	}
 }
 
+func (block *EasyBlock) Header() Header {
+	return block.header
+}
+
 func (block *EasyBlock) Hash() common.Hash {
 	return block.header.Hash()
 }
@@ -4,8 +4,13 @@ import (
 	"execution/common"
 	"execution/params"
 	"execution/state"
+
+	"github.com/ethereum/go-ethereum/event"
 )
 
+// ChainHeadEvent is posted by the chain when a new block becomes its head.
+type ChainHeadEvent struct{ Block Block }
+
 // BlockChain defines the minimal set of methods needed to back a tx pool with
 // a chain. Exists to allow mocking the live chain out of tests.
 type BlockChain interface {
@@ -20,4 +25,19 @@ type BlockChain interface {
 
 	// StateAt returns a state database for a given root hash (generally the head).
 	StateAt(blockHash common.Hash) (state.StateDB, error)
+
+	// SubscribeChainHeadEvent subscribes to new head notifications, used to
+	// reset the pool as the chain progresses.
+	SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription
+}
+
+// Executor is implemented by chains able to run contract code. The pool uses it
+// to estimate the gas of transactions calling into code, which can't be derived
+// from the intrinsic gas alone.
+type Executor interface {
+	// Execute applies the transaction on top of the given state, as if included
+	// in the block following head with the given gas limit. It reports whether
+	// the execution failed, e.g. running out of gas or reverting, and returns an
+	// error only if the transaction couldn't be applied at all.
+	Execute(statedb state.StateDB, head Header, tx *Transaction, gas uint64) (failed bool, err error)
 }
@@ -5,4 +5,13 @@ import "errors"
 var (
 	ErrGasUintOverflow = errors.New("gas uint overflow")
 	ErrCannotMarshal   = errors.New("cannot marshal")
+	ErrGasFeeCapTooLow = errors.New("fee cap less than base fee")
+
+	ErrMissingSignature = errors.New("transaction not signed")
+
+	ErrTxTypeNotSupported = errors.New("transaction type not supported")
+	ErrTxTypeMismatch     = errors.New("transaction type doesn't match its fields")
+
+	ErrWitnessOutOfRange = errors.New("witness index out of range")
+	ErrUnusedWitness     = errors.New("witness not referenced by any input coin")
 )
@@ -1,11 +1,27 @@
 package gadget
 
-type AccessList struct{}
+import "execution/common"
 
+// AccessList is a list of accounts and storage slots a transaction declares to
+// access, which are charged for upfront in the intrinsic gas.
+type AccessList []AccessTuple
+
+// AccessTuple is the element type of an access list.
+type AccessTuple struct {
+	Address     common.Address `json:"address"`
+	StorageKeys []common.Hash  `json:"storageKeys"`
+}
+
+// Len returns the number of addresses in the access list.
 func (al AccessList) Len() int {
-	return 0
+	return len(al)
 }
 
+// StorageKeys returns the total number of storage keys in the access list.
 func (al AccessList) StorageKeys() int {
-	return 0
+	sum := 0
+	for _, tuple := range al {
+		sum += len(tuple.StorageKeys)
+	}
+	return sum
 }
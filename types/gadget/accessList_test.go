@@ -0,0 +1,53 @@
+package gadget
+
+import (
+	"encoding/json"
+	"execution/common"
+	"reflect"
+	"testing"
+)
+
+func TestAccessListCounts(t *testing.T) {
+	tests := []struct {
+		list  AccessList
+		addrs int
+		keys  int
+	}{
+		{nil, 0, 0},
+		{AccessList{}, 0, 0},
+		{AccessList{{Address: common.Address{0x01}}}, 1, 0},
+		{AccessList{
+			{Address: common.Address{0x01}, StorageKeys: []common.Hash{{0x01}, {0x02}}},
+			{Address: common.Address{0x02}, StorageKeys: []common.Hash{{0x03}}},
+			{Address: common.Address{0x03}},
+		}, 3, 3},
+	}
+	for i, tt := range tests {
+		if have := tt.list.Len(); have != tt.addrs {
+			t.Errorf("test %d: address count mismatch: have %d, want %d", i, have, tt.addrs)
+		}
+		if have := tt.list.StorageKeys(); have != tt.keys {
+			t.Errorf("test %d: storage key count mismatch: have %d, want %d", i, have, tt.keys)
+		}
+	}
+}
+
+func TestAccessListJSON(t *testing.T) {
+	list := AccessList{{Address: common.Address{0x01}, StorageKeys: []common.Hash{{0x02}}}}
+
+	enc, err := json.Marshal(list)
+	if err != nil {
+		t.Fatalf("failed to encode access list: %v", err)
+	}
+	want := `[{"address":"0x0100000000000000000000000000000000000000","storageKeys":["0x0200000000000000000000000000000000000000000000000000000000000000"]}]`
+	if string(enc) != want {
+		t.Fatalf("encoding mismatch: have %s, want %s", enc, want)
+	}
+	var dec AccessList
+	if err := json.Unmarshal(enc, &dec); err != nil {
+		t.Fatalf("failed to decode access list: %v", err)
+	}
+	if !reflect.DeepEqual(dec, list) {
+		t.Fatalf("round trip mismatch: have %v, want %v", dec, list)
+	}
+}
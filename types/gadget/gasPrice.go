@@ -2,10 +2,93 @@ package gadget
 
 import "math/big"
 
+// GasPrice carries the pricing of a transaction. Legacy transactions only set
+// Price, while dynamic fee (EIP-1559) transactions set FeeCap and Tip instead.
 type GasPrice struct {
-	Price *big.Int `json:"price,omitempty"`
+	Price  *big.Int `json:"price,omitempty"`                 // Legacy price per gas, acting as both fee cap and tip
+	FeeCap *big.Int `json:"feeCap,omitempty" rlp:"optional"` // Maximum total fee per gas (maxFeePerGas)
+	Tip    *big.Int `json:"tip,omitempty" rlp:"optional"`    // Maximum priority fee per gas (maxPriorityFeePerGas)
 }
 
 func NewGasPrice(price *big.Int) *GasPrice {
 	return &GasPrice{Price: price}
 }
+
+// NewDynamicGasPrice creates an EIP-1559 style price with a separate fee cap and tip.
+func NewDynamicGasPrice(feeCap, tip *big.Int) *GasPrice {
+	return &GasPrice{FeeCap: feeCap, Tip: tip}
+}
+
+// GasFeeCap returns the maximum price per gas the sender is willing to pay.
+func (gp *GasPrice) GasFeeCap() *big.Int {
+	if gp.FeeCap != nil {
+		return gp.FeeCap
+	}
+	return gp.Price
+}
+
+// GasTipCap returns the maximum tip per gas the sender is willing to pay.
+func (gp *GasPrice) GasTipCap() *big.Int {
+	if gp.Tip != nil {
+		return gp.Tip
+	}
+	return gp.Price
+}
+
+// EffectiveTip returns the tip paid per unit of gas on top of the given base fee,
+// that is min(tip, feeCap - baseFee). A nil base fee means legacy pricing, where
+// the whole tip cap is paid. Note, the result is negative if the fee cap doesn't
+// cover the base fee.
+func (gp *GasPrice) EffectiveTip(baseFee *big.Int) *big.Int {
+	if baseFee == nil {
+		return new(big.Int).Set(gp.GasTipCap())
+	}
+	tip := new(big.Int).Sub(gp.GasFeeCap(), baseFee)
+	if tipCap := gp.GasTipCap(); tip.Cmp(tipCap) > 0 {
+		tip.Set(tipCap)
+	}
+	return tip
+}
+
+// EffectiveGasPrice returns the price per unit of gas actually paid under the
+// given base fee, that is min(feeCap, baseFee + tip). A nil base fee means legacy
+// pricing, where the whole fee cap is paid.
+func (gp *GasPrice) EffectiveGasPrice(baseFee *big.Int) *big.Int {
+	if baseFee == nil {
+		return new(big.Int).Set(gp.GasFeeCap())
+	}
+	price := new(big.Int).Add(baseFee, gp.GasTipCap())
+	if feeCap := gp.GasFeeCap(); price.Cmp(feeCap) > 0 {
+		price.Set(feeCap)
+	}
+	return price
+}
+
+// Cmp compares the fee caps of the two prices, falling back to the tip caps if
+// the fee caps are equal. It returns -1, 0 or +1 like big.Int.Cmp.
+func (gp *GasPrice) Cmp(other *GasPrice) int {
+	if c := gp.GasFeeCap().Cmp(other.GasFeeCap()); c != 0 {
+		return c
+	}
+	return gp.GasTipCap().Cmp(other.GasTipCap())
+}
+
+// Mul returns the fee cap scaled by factor / divisor, rounding down. It is meant
+// for percentage thresholds such as price bumps, e.g. Mul(110, 100).
+func (gp *GasPrice) Mul(factor, divisor int64) *big.Int {
+	threshold := new(big.Int).Mul(gp.GasFeeCap(), big.NewInt(factor))
+	return threshold.Div(threshold, big.NewInt(divisor))
+}
+
+// MulTip is like Mul, but scales the tip cap instead of the fee cap.
+func (gp *GasPrice) MulTip(factor, divisor int64) *big.Int {
+	threshold := new(big.Int).Mul(gp.GasTipCap(), big.NewInt(factor))
+	return threshold.Div(threshold, big.NewInt(divisor))
+}
+
+// IsZero reports whether the price offers nothing, i.e. both its fee cap and tip
+// cap are missing or zero.
+func (gp *GasPrice) IsZero() bool {
+	feeCap, tip := gp.GasFeeCap(), gp.GasTipCap()
+	return (feeCap == nil || feeCap.Sign() == 0) && (tip == nil || tip.Sign() == 0)
+}
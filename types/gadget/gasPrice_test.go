@@ -0,0 +1,75 @@
+package gadget
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestGasPriceCmp(t *testing.T) {
+	tests := []struct {
+		a, b *GasPrice
+		want int
+	}{
+		{NewGasPrice(big.NewInt(1)), NewGasPrice(big.NewInt(2)), -1},
+		{NewGasPrice(big.NewInt(2)), NewGasPrice(big.NewInt(2)), 0},
+		{NewDynamicGasPrice(big.NewInt(3), big.NewInt(1)), NewGasPrice(big.NewInt(2)), 1},
+		// Equal fee caps fall back to the tips
+		{NewDynamicGasPrice(big.NewInt(2), big.NewInt(1)), NewGasPrice(big.NewInt(2)), -1},
+		{NewDynamicGasPrice(big.NewInt(2), big.NewInt(2)), NewDynamicGasPrice(big.NewInt(2), big.NewInt(1)), 1},
+	}
+	for i, tt := range tests {
+		if have := tt.a.Cmp(tt.b); have != tt.want {
+			t.Errorf("test %d: comparison mismatch: have %d, want %d", i, have, tt.want)
+		}
+		if have := tt.b.Cmp(tt.a); have != -tt.want {
+			t.Errorf("test %d: reverse comparison mismatch: have %d, want %d", i, have, -tt.want)
+		}
+	}
+}
+
+func TestGasPriceMul(t *testing.T) {
+	tests := []struct {
+		price           *GasPrice
+		factor, divisor int64
+		want, wantTip   int64
+	}{
+		{NewGasPrice(big.NewInt(100)), 110, 100, 110, 110},
+		{NewGasPrice(big.NewInt(1)), 110, 100, 1, 1},
+		{NewGasPrice(big.NewInt(19)), 110, 100, 20, 20},
+		{NewDynamicGasPrice(big.NewInt(50), big.NewInt(1)), 3, 2, 75, 1},
+		{NewDynamicGasPrice(big.NewInt(50), big.NewInt(20)), 110, 100, 55, 22},
+	}
+	for i, tt := range tests {
+		if have := tt.price.Mul(tt.factor, tt.divisor); have.Int64() != tt.want {
+			t.Errorf("test %d: threshold mismatch: have %v, want %d", i, have, tt.want)
+		}
+		if have := tt.price.MulTip(tt.factor, tt.divisor); have.Int64() != tt.wantTip {
+			t.Errorf("test %d: tip threshold mismatch: have %v, want %d", i, have, tt.wantTip)
+		}
+	}
+	// Make sure the price itself is not modified
+	price := NewGasPrice(big.NewInt(100))
+	price.Mul(2, 1)
+	price.MulTip(2, 1)
+	if price.Price.Int64() != 100 {
+		t.Errorf("price modified: have %v, want %d", price.Price, 100)
+	}
+}
+
+func TestGasPriceIsZero(t *testing.T) {
+	tests := []struct {
+		price *GasPrice
+		want  bool
+	}{
+		{&GasPrice{}, true},
+		{NewGasPrice(big.NewInt(0)), true},
+		{NewGasPrice(big.NewInt(1)), false},
+		{NewDynamicGasPrice(big.NewInt(0), big.NewInt(0)), true},
+		{NewDynamicGasPrice(big.NewInt(1), big.NewInt(0)), false},
+	}
+	for i, tt := range tests {
+		if have := tt.price.IsZero(); have != tt.want {
+			t.Errorf("test %d: zero mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package gadget
 
 import (
+	"bytes"
 	"execution/common"
 	"math/big"
 )
@@ -9,3 +10,19 @@ type OutputCoin struct {
 	Amount *big.Int       `json:"amount"`
 	Owner  common.Address `json:"owner"`
 }
+
+// Cmp orders output coins canonically, by owner first and amount second. Coins
+// without an amount are ordered as if holding zero.
+func (coin *OutputCoin) Cmp(other *OutputCoin) int {
+	if c := bytes.Compare(coin.Owner[:], other.Owner[:]); c != 0 {
+		return c
+	}
+	a, b := coin.Amount, other.Amount
+	if a == nil {
+		a = new(big.Int)
+	}
+	if b == nil {
+		b = new(big.Int)
+	}
+	return a.Cmp(b)
+}
@@ -1,4 +1,22 @@
 package gadget
 
+import "math/big"
+
+// Refund is the amount of gas a transaction accumulated for refunding, e.g. by
+// clearing storage slots during execution.
 type Refund struct {
+	Gas *big.Int `json:"gas,omitempty"`
+}
+
+// Cap returns the gas that can actually be refunded after using gasUsed, which
+// is at most gasUsed / quotient as introduced by EIP-3529.
+func (r *Refund) Cap(gasUsed uint64, quotient uint64) uint64 {
+	if r == nil || r.Gas == nil || r.Gas.Sign() <= 0 {
+		return 0
+	}
+	limit := gasUsed / quotient
+	if !r.Gas.IsUint64() || r.Gas.Uint64() > limit {
+		return limit
+	}
+	return r.Gas.Uint64()
 }
@@ -13,6 +13,7 @@ import (
 var (
 	ErrInvalidSignature = errors.New("invalid signature")
 	ErrInvalidPubKey    = errors.New("invalid public key")
+	ErrInvalidChainId   = errors.New("invalid chain id for signer")
 )
 
 type Validation struct {
@@ -21,27 +22,101 @@ type Validation struct {
 	V *big.Int `json:"v,omitempty"`
 }
 
-func validateSignatureValues(r, s *big.Int, v byte) bool {
+// ValidateSignatureValues verifies whether the signature values are valid with
+// the given chain rules. The v value is assumed to be either 0 or 1. Homestead
+// rules reject malleable signatures in the upper half of the curve order.
+func ValidateSignatureValues(v byte, r, s *big.Int, homestead bool) bool {
 	Big1 := big.NewInt(1)
-	if r.Cmp(Big1) < 0 || s.Cmp(Big1) < 0 || s.Cmp(crypto.Secp256k1halfN) > 0 {
+	if r.Cmp(Big1) < 0 || s.Cmp(Big1) < 0 {
+		return false
+	}
+	// reject upper range of s values (ECDSA malleability)
+	if homestead && s.Cmp(crypto.Secp256k1halfN) > 0 {
 		return false
 	}
 	return r.Cmp(crypto.Secp256k1N) < 0 && s.Cmp(crypto.Secp256k1N) < 0 && (v == 0 || v == 1)
 }
 
+// Protected reports whether the signature is replay protected, i.e. whether it
+// embeds a chain ID in V. Legacy signatures carry V = 27 or 28.
+func (sign *Validation) Protected() bool {
+	if sign.V.BitLen() <= 8 {
+		v := sign.V.Uint64()
+		return v != 27 && v != 28
+	}
+	return true
+}
+
+// ChainID returns the chain ID embedded in a replay protected signature, or nil
+// for legacy signatures which are valid on any chain.
+func (sign *Validation) ChainID() *big.Int {
+	if !sign.Protected() || sign.V.Cmp(big.NewInt(35)) < 0 {
+		return nil
+	}
+	id := new(big.Int).Sub(sign.V, big.NewInt(35))
+	return id.Rsh(id, 1)
+}
+
+// complete reports whether all signature values are set. Protected and ChainID
+// must not be called on incomplete signatures.
+func (sign *Validation) complete() bool {
+	return sign.V != nil && sign.R != nil && sign.S != nil
+}
+
+// GetFrom recovers the sender of a legacy signature over the given hash.
 func (sign *Validation) GetFrom(input common.Hash) (common.Address, error) {
-	if sign.V.BitLen() > 8 {
+	return sign.getFrom(input, true)
+}
+
+func (sign *Validation) getFrom(input common.Hash, homestead bool) (common.Address, error) {
+	if !sign.complete() || sign.V.BitLen() > 8 {
 		return common.Address{}, ErrInvalidSignature
 	}
-	v := byte(sign.V.Uint64() - 27)
+	return recoverPlain(input, sign.R, sign.S, byte(sign.V.Uint64()-27), homestead)
+}
 
-	if !validateSignatureValues(sign.R, sign.S, v) {
+// GetFromWithChainID recovers the sender of the signature over the given hash,
+// ensuring a replay protected signature was made for the given chain. Legacy
+// signatures carry no chain ID and are accepted on any chain, as are protected
+// ones if no chain ID is given. Protected signatures are checked against the
+// chain bound hash of the input, see ChainHash.
+func (sign *Validation) GetFromWithChainID(input common.Hash, chainID *big.Int) (common.Address, error) {
+	return sign.Recover(input, chainID, true)
+}
+
+// Recover is like GetFromWithChainID, but leaves the choice of signature rules
+// to the caller. Without homestead rules, malleable high-S signatures produced
+// by legacy tooling are accepted too.
+func (sign *Validation) Recover(input common.Hash, chainID *big.Int, homestead bool) (common.Address, error) {
+	if !sign.complete() {
+		return common.Address{}, ErrInvalidSignature
+	}
+	if !sign.Protected() {
+		return sign.getFrom(input, homestead)
+	}
+	id := sign.ChainID()
+	if id == nil {
+		return common.Address{}, ErrInvalidSignature
+	}
+	if chainID != nil && id.Cmp(chainID) != 0 {
+		return common.Address{}, ErrInvalidChainId
+	}
+	v := new(big.Int).Sub(sign.V, new(big.Int).Lsh(id, 1))
+	v.Sub(v, big.NewInt(35))
+	if v.BitLen() > 8 {
+		return common.Address{}, ErrInvalidSignature
+	}
+	return recoverPlain(ChainHash(input, id), sign.R, sign.S, byte(v.Uint64()), homestead)
+}
+
+func recoverPlain(input common.Hash, R, S *big.Int, v byte, homestead bool) (common.Address, error) {
+	if !ValidateSignatureValues(v, R, S, homestead) {
 		return common.Address{}, ErrInvalidSignature
 	}
 
 	sig := make([]byte, 65)
-	r := sign.R.Bytes()
-	s := sign.S.Bytes()
+	r := R.Bytes()
+	s := S.Bytes()
 	copy(sig[32-len(r):32], r)
 	copy(sig[64-len(s):64], s)
 	sig[64] = v
@@ -70,6 +145,28 @@ func (sign *Validation) Sign(input common.Hash, prv *ecdsa.PrivateKey) {
 	sign.V = new(big.Int).SetBytes([]byte{sig[64] + 27})
 }
 
+// ChainHash binds the given hash to a chain. Replay protected signatures sign the
+// result instead of the hash itself, so the chain ID embedded in V can't be
+// rewritten without changing the recovered sender.
+func ChainHash(input common.Hash, chainID *big.Int) common.Hash {
+	return crypto.Keccak256Hash(input[:], chainID.Bytes())
+}
+
+// SignWithChainID signs the chain bound hash of the input, see ChainHash, and
+// embeds the chain ID into V as V = {0,1} + 35 + chainID * 2 to protect against
+// cross-chain replays.
+func (sign *Validation) SignWithChainID(input common.Hash, prv *ecdsa.PrivateKey, chainID *big.Int) {
+	hash := ChainHash(input, chainID)
+	sig, err := crypto.Sign(hash[:], prv)
+	if err != nil {
+		panic(err)
+	}
+	sign.R = new(big.Int).SetBytes(sig[:32])
+	sign.S = new(big.Int).SetBytes(sig[32:64])
+	sign.V = new(big.Int).Lsh(chainID, 1)
+	sign.V.Add(sign.V, big.NewInt(35+int64(sig[64])))
+}
+
 func FromECDSAPub(pub *ecdsa.PublicKey) []byte {
 	if pub == nil || pub.X == nil || pub.Y == nil {
 		return nil
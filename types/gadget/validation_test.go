@@ -0,0 +1,61 @@
+package gadget
+
+import (
+	"errors"
+	"execution/common"
+	"math/big"
+	"testing"
+
+	"execution/crypto"
+)
+
+// Tests that recovering the sender of a signature with missing values fails
+// instead of dereferencing them.
+func TestRecoverIncomplete(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	hash := common.Hash{0x01}
+
+	var sign Validation
+	sign.SignWithChainID(hash, key, big.NewInt(1))
+	if from, err := sign.Recover(hash, big.NewInt(1), true); err != nil || from != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("failed to recover complete signature: %v", err)
+	}
+	for i, incomplete := range []Validation{
+		{R: sign.R, S: sign.S},
+		{S: sign.S, V: sign.V},
+		{R: sign.R, V: sign.V},
+		{},
+	} {
+		if _, err := incomplete.Recover(hash, nil, true); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("signature %d: recover error mismatch: have %v, want %v", i, err, ErrInvalidSignature)
+		}
+		if _, err := incomplete.GetFrom(hash); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("signature %d: legacy recover error mismatch: have %v, want %v", i, err, ErrInvalidSignature)
+		}
+	}
+}
+
+// Tests that the chain ID embedded into a replay protected signature can't be
+// rewritten to another chain while still recovering the original signer.
+func TestChainIDRewrite(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	hash := common.Hash{0x01}
+
+	var sign Validation
+	sign.SignWithChainID(hash, key, big.NewInt(1))
+	if from, err := sign.GetFromWithChainID(hash, big.NewInt(1)); err != nil || from != addr {
+		t.Fatalf("failed to recover signer: have %v (%v), want %v", from, err, addr)
+	}
+	if _, err := sign.GetFromWithChainID(hash, big.NewInt(2)); !errors.Is(err, ErrInvalidChainId) {
+		t.Errorf("foreign chain error mismatch: have %v, want %v", err, ErrInvalidChainId)
+	}
+	// Move the signature over to chain 2, keeping the recovery id
+	rewritten := Validation{R: sign.R, S: sign.S, V: new(big.Int).Add(sign.V, big.NewInt(2))}
+	if id := rewritten.ChainID(); id.Cmp(big.NewInt(2)) != 0 {
+		t.Fatalf("rewritten chain id mismatch: have %v, want 2", id)
+	}
+	if from, err := rewritten.GetFromWithChainID(hash, big.NewInt(2)); err == nil && from == addr {
+		t.Errorf("rewritten signature recovered the original signer")
+	}
+}
@@ -1,3 +1,59 @@
 package gadget
 
-type Witness struct{}
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+	"execution/common"
+	"execution/crypto"
+)
+
+var ErrWitnessOwnerMismatch = errors.New("witness key doesn't own the coin")
+
+// Witness proves the right to spend an input coin. It carries the public key of
+// the coin owner and a signature made with the matching key over the spending
+// transaction and the position of the coin among its inputs.
+type Witness struct {
+	PubKey    []byte `json:"pubKey,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// NewWitness signs the spend of the input at the given position of the transaction
+// with the given signing hash, producing a witness for it.
+func NewWitness(sigHash common.Hash, input int, prv *ecdsa.PrivateKey) (Witness, error) {
+	hash := spendHash(sigHash, input)
+	sig, err := crypto.Sign(hash[:], prv)
+	if err != nil {
+		return Witness{}, err
+	}
+	return Witness{PubKey: crypto.FromECDSAPub(&prv.PublicKey), Signature: sig}, nil
+}
+
+// Verify checks that the witness key owns the coin and that the signature over
+// the spend of the coin, as the input at the given position of the transaction
+// with the given signing hash, was made with it. The witness can't be moved to
+// another transaction nor to another input of the same one.
+func (w *Witness) Verify(sigHash common.Hash, input int, coin InputCoin) error {
+	pub, err := crypto.UnmarshalPubkey(w.PubKey)
+	if err != nil {
+		return ErrInvalidPubKey
+	}
+	if owner := crypto.PubkeyToAddress(*pub); !bytes.Equal(coin.Owner, owner.Bytes()) {
+		return ErrWitnessOwnerMismatch
+	}
+	hash := spendHash(sigHash, input)
+	if len(w.Signature) != crypto.SignatureLength || !crypto.VerifySignature(w.PubKey, hash[:], w.Signature[:crypto.RecoveryIDOffset]) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// spendHash returns the hash a witness signs to spend an input of a transaction,
+// which covers the signing hash of the transaction and the position of the input.
+// The signing hash already commits to the outpoint and amount of every input.
+func spendHash(sigHash common.Hash, input int) common.Hash {
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], uint32(input))
+	return crypto.Keccak256Hash(sigHash[:], index[:])
+}
@@ -0,0 +1,52 @@
+package gadget
+
+import (
+	"errors"
+	"execution/common"
+	"math/big"
+	"testing"
+
+	"execution/crypto"
+)
+
+func TestWitnessVerify(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	owner := crypto.PubkeyToAddress(key.PublicKey)
+
+	coin := InputCoin{TxHash: common.Hash{0x01}, Index: 1, Amount: big.NewInt(1000), Owner: owner.Bytes()}
+	sigHash := common.Hash{0x02}
+
+	witness, err := NewWitness(sigHash, 0, key)
+	if err != nil {
+		t.Fatalf("failed to sign coin: %v", err)
+	}
+	if err := witness.Verify(sigHash, 0, coin); err != nil {
+		t.Fatalf("valid witness rejected: %v", err)
+	}
+	// The witness must neither be lifted into another transaction nor be reused
+	// for another input of the same one
+	foreign, _ := NewWitness(sigHash, 0, other)
+	forged := foreign
+	forged.PubKey = witness.PubKey
+
+	tests := []struct {
+		name    string
+		witness Witness
+		sigHash common.Hash
+		input   int
+		err     error
+	}{
+		{"empty", Witness{}, sigHash, 0, ErrInvalidPubKey},
+		{"other transaction", witness, common.Hash{0x03}, 0, ErrInvalidSignature},
+		{"other input", witness, sigHash, 1, ErrInvalidSignature},
+		{"other owner", foreign, sigHash, 0, ErrWitnessOwnerMismatch},
+		{"forged signature", forged, sigHash, 0, ErrInvalidSignature},
+		{"truncated signature", Witness{PubKey: witness.PubKey, Signature: witness.Signature[:64]}, sigHash, 0, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		if err := tt.witness.Verify(tt.sigHash, tt.input, coin); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
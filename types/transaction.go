@@ -1,14 +1,20 @@
 package types
 
 import (
+	"bytes"
 	"crypto/ecdsa"
-	"encoding/json"
 	"execution/common"
 	"execution/crypto"
 	"execution/params"
 	"execution/types/gadget"
+	"fmt"
+	"io"
 	"math"
 	"math/big"
+	"sort"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 type TxType uint8
@@ -24,6 +30,23 @@ type Transaction struct {
 	TxPreface
 	TxInner
 	TxExtends
+
+	// typ is the type declared by the envelope, overriding inference from the
+	// fields if typed is set
+	typ   TxType
+	typed bool
+
+	// caches
+	hash atomic.Value
+	size atomic.Value
+	from atomic.Value
+}
+
+// sigCache is a sender recovered from the signature of a transaction, together
+// with the signature rules it was recovered under.
+type sigCache struct {
+	from      common.Address
+	homestead bool
 }
 
 type TxPreface struct {
@@ -31,9 +54,9 @@ type TxPreface struct {
 	From       common.Address     `json:"from,omitempty"`
 	Nonce      uint64             `json:"nonce,omitempty"`
 	GasLimit   uint64             `json:"gasLimit,omitempty"`
-	GasPrice   *gadget.GasPrice   `json:"gasPrice,omitempty"`
+	GasPrice   *gadget.GasPrice   `json:"gasPrice,omitempty" rlp:"nil"`
 	Value      *big.Int           `json:"value,omitempty"`
-	Validation *gadget.Validation `json:"validation,omitempty"`
+	Validation *gadget.Validation `json:"validation,omitempty" rlp:"nil"`
 
 	InputCoins  []gadget.InputCoin  `json:"inputCoins,omitempty"`
 	Witnesses   []gadget.Witness    `json:"witenesses,omitempty"`
@@ -43,70 +66,539 @@ type TxPreface struct {
 type TxInner struct {
 	To         common.Address     `json:"to,omitempty"`
 	Data       []byte             `json:"data,omitempty"`
-	AccessList *gadget.AccessList `json:"accessList,omitempty"`
+	AccessList *gadget.AccessList `json:"accessList,omitempty" rlp:"nil"`
 }
 
 type TxExtends struct {
-	Refund           *gadget.Refund     `json:"refund,omitempty"`
+	Refund           *gadget.Refund     `json:"refund,omitempty" rlp:"nil"`
 	Extend           []byte             `json:"extend,omitempty"`
-	StrictAccessList *gadget.AccessList `json:"strictAccessList,omitempty"`
+	StrictAccessList *gadget.AccessList `json:"strictAccessList,omitempty" rlp:"nil"`
 }
 
+// Type returns the kind of the transaction. The type declared by the encoding
+// takes precedence, transactions without one (e.g. decoded from legacy JSON) have
+// their type inferred from the fields they carry.
 func (tx *Transaction) Type() TxType {
+	if tx.typed {
+		return tx.typ
+	}
+	return tx.inferType()
+}
+
+// inferType infers the kind of the transaction from the fields it carries. Empty
+// coin lists are treated as absent, so decoding doesn't alter the inferred type.
+func (tx *Transaction) inferType() TxType {
 	if (tx.From != common.Address{}) {
-		if tx.InputCoins == nil {
+		if len(tx.InputCoins) == 0 {
 			return NormalTx
 		} else {
 			return UnkownTx
 		}
 	} else {
-		if tx.InputCoins == nil && tx.OutputCoins != nil {
+		if len(tx.InputCoins) == 0 && len(tx.OutputCoins) != 0 {
 			return WithdrawTx
 		}
-		if tx.InputCoins != nil && tx.OutputCoins == nil {
+		if len(tx.InputCoins) != 0 && len(tx.OutputCoins) == 0 {
 			return RechargeTx
 		}
 	}
 	return UnkownTx
 }
 
+// IsCancel reports whether the transaction is a cancellation, that is a zero
+// value self-send without payload, used to replace a stuck transaction.
+func (tx *Transaction) IsCancel() bool {
+	return tx.Type() == NormalTx && tx.To == tx.From && len(tx.Data) == 0 &&
+		(tx.Value == nil || tx.Value.Sign() == 0)
+}
+
+// setType declares the type of the transaction, which is then carried by the
+// envelope instead of being inferred from the fields.
+func (tx *Transaction) setType(typ TxType) {
+	tx.typ, tx.typed = typ, true
+}
+
+// Serialize returns the canonical encoding of the transaction: the type byte
+// followed by the RLP encoded payload, similar to EIP-2718 envelopes. This is
+// the encoding hashed into TxHash, so it must be identical across nodes.
+// Transactions of unknown type are encoded as a bare RLP list.
 func (tx *Transaction) Serialize() ([]byte, error) {
-	return json.Marshal(tx)
+	payload, err := rlp.EncodeToBytes([]interface{}{&tx.TxPreface, &tx.TxInner, &tx.TxExtends})
+	if err != nil {
+		return nil, err
+	}
+	typ := tx.Type()
+	if typ >= UnkownTx {
+		return payload, nil
+	}
+	return append([]byte{byte(typ)}, payload...), nil
 }
 
-func (tx *Transaction) Cost() *big.Int {
-	if tx.Type() == NormalTx {
-		gasCost := new(big.Int).Mul(tx.GasPrice.Price, new(big.Int).SetUint64(tx.GasLimit))
-		return gasCost.Add(gasCost, tx.Value)
+// Deserialize decodes the canonical encoding produced by Serialize, rejecting
+// declared types which contradict the fields of the transaction.
+func (tx *Transaction) Deserialize(b []byte) error {
+	if len(b) == 0 {
+		return ErrTxTypeMismatch
+	}
+	if b[0] >= 0xc0 {
+		// Bare list without a type byte
+		return rlp.DecodeBytes(b, tx)
+	}
+	var dec Transaction
+	if err := rlp.DecodeBytes(b[1:], &dec); err != nil {
+		return err
+	}
+	if err := dec.declareType(TxType(b[0])); err != nil {
+		return err
+	}
+	tx.TxPreface, tx.TxInner, tx.TxExtends = dec.TxPreface, dec.TxInner, dec.TxExtends
+	tx.setType(dec.typ)
+	return nil
+}
+
+// declareType sets the type carried by an encoding. The declared type may only
+// settle what the fields leave ambiguous, it can't contradict them.
+func (tx *Transaction) declareType(typ TxType) error {
+	if typ >= UnkownTx {
+		return fmt.Errorf("%w: %d", ErrTxTypeNotSupported, typ)
+	}
+	inferred := tx.inferType()
+
+	// Withdrawals carry a sender just like normal transactions, only their output
+	// coins tell them apart, so the inference can't settle between the two
+	if inferred == NormalTx && typ == WithdrawTx && len(tx.OutputCoins) != 0 {
+		inferred = WithdrawTx
+	}
+	if inferred != UnkownTx && inferred != typ {
+		return fmt.Errorf("%w: declared %d, fields imply %d", ErrTxTypeMismatch, typ, inferred)
+	}
+	tx.setType(typ)
+	return nil
+}
+
+// EncodeRLP implements rlp.Encoder. Typed transactions are embedded as a byte
+// string holding their canonical encoding, untyped ones as a list of the preface,
+// inner and extends parts in this fixed order.
+func (tx *Transaction) EncodeRLP(w io.Writer) error {
+	if tx.Type() >= UnkownTx {
+		return rlp.Encode(w, []interface{}{&tx.TxPreface, &tx.TxInner, &tx.TxExtends})
+	}
+	enc, err := tx.Serialize()
+	if err != nil {
+		return err
+	}
+	return rlp.Encode(w, enc)
+}
+
+// DecodeRLP implements rlp.Decoder, decoding the encoding produced by EncodeRLP.
+func (tx *Transaction) DecodeRLP(s *rlp.Stream) error {
+	kind, _, err := s.Kind()
+	if err != nil {
+		return err
+	}
+	if kind != rlp.List {
+		enc, err := s.Bytes()
+		if err != nil {
+			return err
+		}
+		return tx.Deserialize(enc)
+	}
+	if _, err := s.List(); err != nil {
+		return err
+	}
+	var (
+		preface TxPreface
+		inner   TxInner
+		extends TxExtends
+	)
+	if err := s.Decode(&preface); err != nil {
+		return err
+	}
+	if err := s.Decode(&inner); err != nil {
+		return err
+	}
+	if err := s.Decode(&extends); err != nil {
+		return err
+	}
+	if err := s.ListEnd(); err != nil {
+		return err
+	}
+	tx.TxPreface, tx.TxInner, tx.TxExtends = preface, inner, extends
+	return nil
+}
+
+// Sender returns the address that signed the transaction, recovering it from the
+// signature on first use and caching it afterwards. The From field is never
+// trusted: transactions missing their signature are rejected with
+// ErrMissingSignature, except for recharges which have no signer and report the
+// zero address. The cache is not invalidated if the signature is modified.
+func (tx *Transaction) Sender() (common.Address, error) {
+	return tx.RecoverSender(true)
+}
+
+// RecoverSender is like Sender, but leaves the choice of signature rules to the
+// caller: without homestead rules, malleable high-S signatures are accepted. The
+// cache remembers the rules a sender was recovered under, as one recovered under
+// the strict rules holds under the relaxed ones too, but not the other way round.
+func (tx *Transaction) RecoverSender(homestead bool) (common.Address, error) {
+	if cached := tx.from.Load(); cached != nil {
+		if sender := cached.(sigCache); sender.homestead || !homestead {
+			return sender.from, nil
+		}
+	}
+	if tx.Validation == nil {
+		if tx.Type() == RechargeTx {
+			return common.Address{}, nil
+		}
+		return common.Address{}, ErrMissingSignature
+	}
+	hash, err := tx.SigningHash()
+	if err != nil {
+		return common.Address{}, err
+	}
+	from, err := tx.Validation.Recover(hash, nil, homestead)
+	if err != nil {
+		return common.Address{}, err
+	}
+	tx.from.Store(sigCache{from: from, homestead: homestead})
+	return from, nil
+}
+
+// Hash returns the transaction hash derived from the canonical encoding, caching
+// it after the first call. Unlike the TxHash field, it can't be set by the sender,
+// so it is safe to use as a lookup key. The cache is not invalidated if the
+// transaction is modified.
+//
+// Transactions which can't be encoded, e.g. carrying negative amounts, have no
+// hash and report the zero hash. The pool rejects them before keying anything
+// on it, use ComputeHash to learn the reason.
+func (tx *Transaction) Hash() common.Hash {
+	if hash := tx.hash.Load(); hash != nil {
+		return hash.(common.Hash)
+	}
+	hash, err := tx.ComputeHash()
+	if err != nil {
+		return common.Hash{}
+	}
+	tx.hash.Store(hash)
+	return hash
+}
+
+// ComputeHash derives the transaction hash from the canonical encoding, leaving
+// out the TxHash field which is filled in based on the result. The hash covers
+// the signature, making TxHash the identifier of the signed transaction. An error
+// is returned if the transaction can't be encoded.
+func (tx *Transaction) ComputeHash() (common.Hash, error) {
+	cpy := Transaction{TxPreface: tx.TxPreface, TxInner: tx.TxInner, TxExtends: tx.TxExtends, typ: tx.typ, typed: tx.typed}
+	cpy.TxHash = common.Hash{}
+
+	enc, err := cpy.Serialize()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.GenerateHash(enc), nil
+}
+
+// SigningHash derives the hash signed by the sender. It is the type byte followed
+// by the RLP encoding of exactly the fields the sender authorizes for the type of
+// the transaction, so a signature can neither be moved to another type nor cover
+// fields it wasn't made for. Fields outside of the signed set must be empty, see
+// StrayFields. Signatures can be produced and verified externally without knowing
+// the final TxHash. An error is returned if the signed fields can't be encoded.
+//
+// Transactions without a sender signature, like recharges, hash their entire
+// encoding except for TxHash, Validation and the witnesses. Each coin witness
+// signs this hash together with the position of its input, see gadget.Witness.
+func (tx *Transaction) SigningHash() (common.Hash, error) {
+	var fields []interface{}
+	switch typ := tx.Type(); typ {
+	case NormalTx:
+		fields = []interface{}{tx.From, tx.Nonce, tx.GasLimit, tx.GasPrice, tx.Value, tx.To, tx.Data, tx.AccessList, tx.Refund, tx.Extend, tx.StrictAccessList}
+	case WithdrawTx:
+		fields = []interface{}{tx.From, tx.Nonce, tx.GasLimit, tx.GasPrice, tx.Value, tx.OutputCoins}
+	default:
+		cpy := Transaction{TxPreface: tx.TxPreface, TxInner: tx.TxInner, TxExtends: tx.TxExtends, typ: tx.typ, typed: tx.typed}
+		cpy.TxHash = common.Hash{}
+		cpy.Validation = nil
+		cpy.Witnesses = nil
+
+		enc, err := cpy.Serialize()
+		if err != nil {
+			return common.Hash{}, err
+		}
+		return common.GenerateHash(enc), nil
+	}
+	enc, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.GenerateHash(append([]byte{byte(tx.Type())}, enc...)), nil
+}
+
+// StrayFields returns the names of the fields which are set, but not covered by
+// the signing hash of the transaction type. Anyone relaying such a transaction
+// could alter them without invalidating the signature, so they must be empty.
+func (tx *Transaction) StrayFields() []string {
+	var stray []string
+	check := func(name string, set bool) {
+		if set {
+			stray = append(stray, name)
+		}
+	}
+	switch tx.Type() {
+	case NormalTx:
+		check("inputCoins", len(tx.InputCoins) > 0)
+		check("witnesses", len(tx.Witnesses) > 0)
+		check("outputCoins", len(tx.OutputCoins) > 0)
+
+	case WithdrawTx:
+		check("inputCoins", len(tx.InputCoins) > 0)
+		check("witnesses", len(tx.Witnesses) > 0)
+		check("to", tx.To != common.Address{})
+		check("data", len(tx.Data) > 0)
+		check("accessList", tx.AccessList != nil && tx.AccessList.Len() > 0)
+		check("refund", tx.Refund != nil)
+		check("extend", len(tx.Extend) > 0)
+		check("strictAccessList", tx.StrictAccessList != nil && tx.StrictAccessList.Len() > 0)
+	}
+	return stray
+}
+
+// Copy returns a deep copy of the transaction which shares no mutable state with
+// the original, so it can be modified safely. The caches aren't carried over,
+// but the copy hashes identically until modified.
+func (tx *Transaction) Copy() *Transaction {
+	cpy := &Transaction{TxPreface: tx.TxPreface, TxInner: tx.TxInner, TxExtends: tx.TxExtends, typ: tx.typ, typed: tx.typed}
+
+	cpy.Value = copyBig(tx.Value)
+	if tx.GasPrice != nil {
+		cpy.GasPrice = &gadget.GasPrice{Price: copyBig(tx.GasPrice.Price), FeeCap: copyBig(tx.GasPrice.FeeCap), Tip: copyBig(tx.GasPrice.Tip)}
+	}
+	if tx.Validation != nil {
+		cpy.Validation = &gadget.Validation{R: copyBig(tx.Validation.R), S: copyBig(tx.Validation.S), V: copyBig(tx.Validation.V)}
+	}
+	if tx.InputCoins != nil {
+		cpy.InputCoins = make([]gadget.InputCoin, len(tx.InputCoins))
+		for i, coin := range tx.InputCoins {
+			coin.Amount, coin.Owner = copyBig(coin.Amount), copyBytes(coin.Owner)
+			cpy.InputCoins[i] = coin
+		}
+	}
+	if tx.Witnesses != nil {
+		cpy.Witnesses = make([]gadget.Witness, len(tx.Witnesses))
+		for i, witness := range tx.Witnesses {
+			cpy.Witnesses[i] = gadget.Witness{PubKey: copyBytes(witness.PubKey), Signature: copyBytes(witness.Signature)}
+		}
+	}
+	if tx.OutputCoins != nil {
+		cpy.OutputCoins = make([]gadget.OutputCoin, len(tx.OutputCoins))
+		for i, coin := range tx.OutputCoins {
+			coin.Amount = copyBig(coin.Amount)
+			cpy.OutputCoins[i] = coin
+		}
+	}
+	cpy.Data = copyBytes(tx.Data)
+	cpy.AccessList = copyAccessList(tx.AccessList)
+
+	if tx.Refund != nil {
+		cpy.Refund = &gadget.Refund{Gas: copyBig(tx.Refund.Gas)}
+	}
+	cpy.Extend = copyBytes(tx.Extend)
+	cpy.StrictAccessList = copyAccessList(tx.StrictAccessList)
+
+	return cpy
+}
+
+// Equal reports whether the two transactions are of the same type and carry the
+// same fields, including the stored hash and the signature. Unlike comparing the
+// hashes, missing amounts are told apart from zero ones.
+func (tx *Transaction) Equal(other *Transaction) bool {
+	if tx == nil || other == nil {
+		return tx == other
+	}
+	if tx.Type() != other.Type() || tx.TxHash != other.TxHash || tx.From != other.From ||
+		tx.Nonce != other.Nonce || tx.GasLimit != other.GasLimit || !bigEqual(tx.Value, other.Value) {
+		return false
 	}
-	if tx.Type() == WithdrawTx {
+	if (tx.GasPrice == nil) != (other.GasPrice == nil) || (tx.GasPrice != nil &&
+		(!bigEqual(tx.GasPrice.Price, other.GasPrice.Price) || !bigEqual(tx.GasPrice.FeeCap, other.GasPrice.FeeCap) || !bigEqual(tx.GasPrice.Tip, other.GasPrice.Tip))) {
+		return false
+	}
+	if (tx.Validation == nil) != (other.Validation == nil) || (tx.Validation != nil &&
+		(!bigEqual(tx.Validation.R, other.Validation.R) || !bigEqual(tx.Validation.S, other.Validation.S) || !bigEqual(tx.Validation.V, other.Validation.V))) {
+		return false
+	}
+	if len(tx.InputCoins) != len(other.InputCoins) || len(tx.Witnesses) != len(other.Witnesses) || len(tx.OutputCoins) != len(other.OutputCoins) {
+		return false
+	}
+	for i, coin := range tx.InputCoins {
+		them := other.InputCoins[i]
+		if coin.TxHash != them.TxHash || coin.Index != them.Index || !bigEqual(coin.Amount, them.Amount) ||
+			coin.WitnessIndex != them.WitnessIndex || !bytes.Equal(coin.Owner, them.Owner) {
+			return false
+		}
+	}
+	for i, witness := range tx.Witnesses {
+		if !bytes.Equal(witness.PubKey, other.Witnesses[i].PubKey) || !bytes.Equal(witness.Signature, other.Witnesses[i].Signature) {
+			return false
+		}
+	}
+	for i, coin := range tx.OutputCoins {
+		if coin.Owner != other.OutputCoins[i].Owner || !bigEqual(coin.Amount, other.OutputCoins[i].Amount) {
+			return false
+		}
+	}
+	if tx.To != other.To || !bytes.Equal(tx.Data, other.Data) || !accessListEqual(tx.AccessList, other.AccessList) {
+		return false
+	}
+	if (tx.Refund == nil) != (other.Refund == nil) || (tx.Refund != nil && !bigEqual(tx.Refund.Gas, other.Refund.Gas)) {
+		return false
+	}
+	return bytes.Equal(tx.Extend, other.Extend) && accessListEqual(tx.StrictAccessList, other.StrictAccessList)
+}
+
+// EffectiveGasTip returns the effective miner tip for the given base fee, that
+// is min(tipCap, feeCap - baseFee), or the tip cap if no base fee is given. If
+// the fee cap can't cover the base fee, the negative tip is returned together
+// with ErrGasFeeCapTooLow.
+func (tx *Transaction) EffectiveGasTip(baseFee *big.Int) (*big.Int, error) {
+	tip := tx.GasPrice.EffectiveTip(baseFee)
+	if baseFee != nil && tx.GasPrice.GasFeeCap().Cmp(baseFee) < 0 {
+		return tip, ErrGasFeeCapTooLow
+	}
+	return tip, nil
+}
+
+// Cost returns the worst case amount the transaction may spend, charging the
+// whole gas limit at the fee cap. The result is never nil: missing amounts count
+// as zero and transactions of unknown type cost nothing, as the pool rejects
+// them before any cost accounting takes place.
+func (tx *Transaction) Cost() *big.Int {
+	gasCost, transfer := tx.CostBreakdown()
+	return gasCost.Add(gasCost, transfer)
+}
+
+// CostBreakdown splits Cost into the worst case fee, the gas limit charged at the
+// fee cap, and the amount transferred: the value of normal transactions and the
+// output coins of withdrawals. Recharges transfer nothing from the account. Both
+// results are never nil and are zero for transactions of unknown type.
+func (tx *Transaction) CostBreakdown() (gasCost, transfer *big.Int) {
+	gasCost, transfer = new(big.Int), new(big.Int)
+	switch tx.Type() {
+	case NormalTx:
+		if tx.Value != nil {
+			transfer.Set(tx.Value)
+		}
+	case WithdrawTx:
 		// withdraw Tx gets unique gas limit
-		gasCost := new(big.Int).Mul(tx.GasPrice.Price, new(big.Int).SetUint64(tx.GasLimit))
 		for _, outputCoin := range tx.OutputCoins {
-			gasCost = gasCost.Add(gasCost, outputCoin.Amount)
+			if outputCoin.Amount != nil {
+				transfer.Add(transfer, outputCoin.Amount)
+			}
 		}
-		return gasCost
-	}
-	if tx.Type() == RechargeTx {
+	case RechargeTx:
 		// Recharge Tx gets unique gas limit
-		return new(big.Int).Mul(tx.GasPrice.Price, new(big.Int).SetUint64(tx.GasLimit))
+	default:
+		return gasCost, transfer
+	}
+	if tx.GasPrice != nil && tx.GasPrice.GasFeeCap() != nil {
+		gasCost.Mul(tx.GasPrice.GasFeeCap(), new(big.Int).SetUint64(tx.GasLimit))
+	}
+	return gasCost, transfer
+}
+
+// CanonicalizeOutputs sorts the output coins into the canonical order enforced by
+// the pool, by owner and then amount. The same owner may be paid multiple times.
+// Constructors call it before signing, as reordering alters the hash.
+func (tx *Transaction) CanonicalizeOutputs() {
+	sort.SliceStable(tx.OutputCoins, func(i, j int) bool {
+		return tx.OutputCoins[i].Cmp(&tx.OutputCoins[j]) < 0
+	})
+}
+
+// OutputsCanonical reports whether the output coins are in canonical order.
+func (tx *Transaction) OutputsCanonical() bool {
+	for i := 1; i < len(tx.OutputCoins); i++ {
+		if tx.OutputCoins[i-1].Cmp(&tx.OutputCoins[i]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// InputSum returns the total amount of the input coins spent by the transaction.
+// Coins without an amount count as zero.
+func (tx *Transaction) InputSum() *big.Int {
+	sum := new(big.Int)
+	for _, coin := range tx.InputCoins {
+		if coin.Amount != nil {
+			sum.Add(sum, coin.Amount)
+		}
+	}
+	return sum
+}
+
+// ApplyRefund returns the gas refunded to the sender after the transaction used
+// gasUsed, capped at gasUsed / params.RefundQuotientEIP3529.
+func (tx *Transaction) ApplyRefund(gasUsed uint64) uint64 {
+	return tx.Refund.Cap(gasUsed, params.RefundQuotientEIP3529)
+}
+
+// VerifyCoinWitnesses ensures every input coin references a witness within range
+// which proves the right to spend it within this very transaction, and that every
+// witness is referenced by at least one input coin.
+func (tx *Transaction) VerifyCoinWitnesses() error {
+	sigHash, err := tx.SigningHash()
+	if err != nil {
+		return err
+	}
+	used := make([]bool, len(tx.Witnesses))
+	for i, coin := range tx.InputCoins {
+		if int(coin.WitnessIndex) >= len(tx.Witnesses) {
+			return fmt.Errorf("%w: input %d references witness %d, have %d", ErrWitnessOutOfRange, i, coin.WitnessIndex, len(tx.Witnesses))
+		}
+		if err := tx.Witnesses[coin.WitnessIndex].Verify(sigHash, i, coin); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+		used[coin.WitnessIndex] = true
+	}
+	for i, ok := range used {
+		if !ok {
+			return fmt.Errorf("%w: witness %d", ErrUnusedWitness, i)
+		}
 	}
 	return nil
 }
 
+// Size returns the length of the canonical encoding of the transaction, caching
+// it after the first call. The cache is not invalidated if the transaction is
+// modified. Transactions which can't be encoded report the maximum size, so no
+// size limit admits them.
 func (tx *Transaction) Size() uint64 {
-	ret, _ := tx.Serialize()
-	return uint64(len(ret))
+	if size := tx.size.Load(); size != nil {
+		return size.(uint64)
+	}
+	ret, err := tx.Serialize()
+	if err != nil {
+		return math.MaxUint64
+	}
+	size := uint64(len(ret))
+	tx.size.Store(size)
+	return size
 }
 
-func (tx *Transaction) IntrinsicGas() (uint64, error) {
+// IntrinsicGas returns the gas a transaction is charged before execution under
+// the gas schedule of the given chain, or the default schedule if config is nil.
+func (tx *Transaction) IntrinsicGas(config *params.ChainConfig) (uint64, error) {
 	if tx.Type() == NormalTx {
+		schedule := config.GasSchedule()
+
 		// Set the starting gas for the raw transaction
 		var gas uint64
 		if (tx.To == common.Address{}) {
-			gas = params.TxGasContractCreation
+			gas = schedule.TxGasContractCreation
 		} else {
-			gas = params.TxGas
+			gas = schedule.TxGas
 		}
 		dataLen := uint64(len(tx.Data))
 		// Bump the required gas by the amount of transactional data
@@ -119,7 +611,7 @@ func (tx *Transaction) IntrinsicGas() (uint64, error) {
 				}
 			}
 			// Make sure we don't exceed uint64 for all data combinations
-			nonZeroGas := params.TxDataNonZeroGas
+			nonZeroGas := schedule.TxDataNonZeroGas
 
 			if (math.MaxUint64-gas)/nonZeroGas < nz {
 				return 0, ErrGasUintOverflow
@@ -127,22 +619,22 @@ func (tx *Transaction) IntrinsicGas() (uint64, error) {
 			gas += nz * nonZeroGas
 
 			z := dataLen - nz
-			if (math.MaxUint64-gas)/params.TxDataZeroGas < z {
+			if (math.MaxUint64-gas)/schedule.TxDataZeroGas < z {
 				return 0, ErrGasUintOverflow
 			}
-			gas += z * params.TxDataZeroGas
+			gas += z * schedule.TxDataZeroGas
 
 			if (tx.To == common.Address{}) {
 				lenWords := toWordSize(dataLen)
-				if (math.MaxUint64-gas)/params.InitCodeWordGas < lenWords {
+				if (math.MaxUint64-gas)/schedule.InitCodeWordGas < lenWords {
 					return 0, ErrGasUintOverflow
 				}
-				gas += lenWords * params.InitCodeWordGas
+				gas += lenWords * schedule.InitCodeWordGas
 			}
 		}
 		if tx.AccessList != nil {
-			gas += uint64(tx.AccessList.Len()) * params.TxAccessListAddressGas
-			gas += uint64(tx.AccessList.StorageKeys()) * params.TxAccessListStorageKeyGas
+			gas += uint64(tx.AccessList.Len()) * schedule.TxAccessListAddressGas
+			gas += uint64(tx.AccessList.StorageKeys()) * schedule.TxAccessListStorageKeyGas
 		}
 		return gas, nil
 	}
@@ -173,15 +665,9 @@ func NewNormalTransaction(nonce uint64, to common.Address, value *big.Int, gasLi
 			Data: data,
 		},
 	}
+	tx.setType(NormalTx)
 
-	txBytes, _ := tx.Serialize()
-	hash := common.GenerateHash(txBytes)
-	var validate gadget.Validation
-	validate.Sign(hash, prv)
-
-	tx.TxHash = hash
-	tx.Validation = &validate
-
+	tx.sign(prv)
 	return tx
 }
 
@@ -191,25 +677,23 @@ func NewWithdrawTransaction(nonce uint64, gasPrice *gadget.GasPrice, outputCoins
 			From:        crypto.PubkeyToAddress(prv.PublicKey),
 			Nonce:       nonce,
 			GasPrice:    gasPrice,
-			OutputCoins: outputCoins,
+			OutputCoins: append([]gadget.OutputCoin(nil), outputCoins...),
 		},
 	}
+	tx.setType(WithdrawTx)
+	tx.CanonicalizeOutputs()
 
-	txBytes, _ := tx.Serialize()
-	hash := common.GenerateHash(txBytes)
-	var validate gadget.Validation
-	validate.Sign(hash, prv)
-
-	tx.TxHash = hash
-	tx.Validation = &validate
-
+	tx.sign(prv)
 	return tx
 }
 
-func NewRechargeTransaction(txHash common.Hash, inputCoins []gadget.InputCoin, witenesses []gadget.Witness, gasPrice *gadget.GasPrice, to common.Address) *Transaction {
-	return &Transaction{
+// NewRechargeTransaction creates a recharge minting the value of the given input
+// coins to the account to. Recharges carry no sender signature, the witnesses
+// authorize the spends instead. The hash is derived from the contents, unless the
+// transaction can't be encoded, like in sign.
+func NewRechargeTransaction(inputCoins []gadget.InputCoin, witenesses []gadget.Witness, gasPrice *gadget.GasPrice, to common.Address) *Transaction {
+	tx := &Transaction{
 		TxPreface: TxPreface{
-			TxHash:     txHash,
 			InputCoins: inputCoins,
 			Witnesses:  witenesses,
 			GasPrice:   gasPrice,
@@ -218,6 +702,29 @@ func NewRechargeTransaction(txHash common.Hash, inputCoins []gadget.InputCoin, w
 			To: to,
 		},
 	}
+	tx.setType(RechargeTx)
+
+	if hash, err := tx.ComputeHash(); err == nil {
+		tx.TxHash = hash
+	}
+	return tx
+}
+
+// sign signs the transaction with prv and fills in its hash. Transactions which
+// can't be encoded, e.g. carrying negative amounts, are left unsigned and without
+// a hash, and are rejected by the pool.
+func (tx *Transaction) sign(prv *ecdsa.PrivateKey) {
+	sigHash, err := tx.SigningHash()
+	if err != nil {
+		return
+	}
+	var validate gadget.Validation
+	validate.Sign(sigHash, prv)
+	tx.Validation = &validate
+
+	if hash, err := tx.ComputeHash(); err == nil {
+		tx.TxHash = hash
+	}
 }
 
 type Transactions []*Transaction
@@ -249,3 +756,61 @@ func TxDifference(a, b Transactions) Transactions {
 
 	return keep
 }
+
+func copyBig(x *big.Int) *big.Int {
+	if x == nil {
+		return nil
+	}
+	return new(big.Int).Set(x)
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func copyAccessList(al *gadget.AccessList) *gadget.AccessList {
+	if al == nil {
+		return nil
+	}
+	cpy := make(gadget.AccessList, len(*al))
+	for i, tuple := range *al {
+		cpy[i] = gadget.AccessTuple{Address: tuple.Address}
+		if tuple.StorageKeys != nil {
+			cpy[i].StorageKeys = append([]common.Hash{}, tuple.StorageKeys...)
+		}
+	}
+	return &cpy
+}
+
+// bigEqual reports whether two optional integers are equal, nil only being equal
+// to nil.
+func bigEqual(a, b *big.Int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Cmp(b) == 0
+}
+
+func accessListEqual(a, b *gadget.AccessList) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(*a) != len(*b) {
+		return false
+	}
+	for i, tuple := range *a {
+		them := (*b)[i]
+		if tuple.Address != them.Address || len(tuple.StorageKeys) != len(them.StorageKeys) {
+			return false
+		}
+		for j, key := range tuple.StorageKeys {
+			if key != them.StorageKeys[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"execution/common"
+	"execution/types/gadget"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var errCoinIndexOverflow = errors.New("coin index overflows uint32")
+
+// txJSON is the JSON representation of transactions. Big integers are encoded as
+// quoted hex strings so they survive a round trip through clients which parse
+// numbers as doubles, and byte slices as 0x prefixed hex.
+type txJSON struct {
+	Type       *hexutil.Uint64 `json:"type,omitempty"`
+	TxHash     common.Hash     `json:"txHash"`
+	From       common.Address  `json:"from"`
+	Nonce      hexutil.Uint64  `json:"nonce"`
+	GasLimit   hexutil.Uint64  `json:"gasLimit"`
+	GasPrice   *gasPriceJSON   `json:"gasPrice,omitempty"`
+	Value      *hexutil.Big    `json:"value,omitempty"`
+	Validation *validationJSON `json:"validation,omitempty"`
+
+	InputCoins  []inputCoinJSON  `json:"inputCoins,omitempty"`
+	Witnesses   []witnessJSON    `json:"witenesses,omitempty"`
+	OutputCoins []outputCoinJSON `json:"outputCoins,omitempty"`
+
+	To         common.Address     `json:"to"`
+	Data       hexutil.Bytes      `json:"data,omitempty"`
+	AccessList *gadget.AccessList `json:"accessList,omitempty"`
+
+	Refund           *hexutil.Big       `json:"refund,omitempty"`
+	Extend           hexutil.Bytes      `json:"extend,omitempty"`
+	StrictAccessList *gadget.AccessList `json:"strictAccessList,omitempty"`
+}
+
+type gasPriceJSON struct {
+	Price  *hexutil.Big `json:"price,omitempty"`
+	FeeCap *hexutil.Big `json:"feeCap,omitempty"`
+	Tip    *hexutil.Big `json:"tip,omitempty"`
+}
+
+type validationJSON struct {
+	R *hexutil.Big `json:"r,omitempty"`
+	S *hexutil.Big `json:"s,omitempty"`
+	V *hexutil.Big `json:"v,omitempty"`
+}
+
+type inputCoinJSON struct {
+	TxHash       common.Hash    `json:"txHash"`
+	Index        hexutil.Uint64 `json:"index"`
+	Amount       *hexutil.Big   `json:"amount"`
+	WitnessIndex hexutil.Uint64 `json:"witnessIndex"`
+	Owner        hexutil.Bytes  `json:"owner"`
+}
+
+type witnessJSON struct {
+	PubKey    hexutil.Bytes `json:"pubKey,omitempty"`
+	Signature hexutil.Bytes `json:"signature,omitempty"`
+}
+
+type outputCoinJSON struct {
+	Amount *hexutil.Big   `json:"amount"`
+	Owner  common.Address `json:"owner"`
+}
+
+// MarshalJSON marshals as JSON with hex encoded numbers and byte slices.
+func (tx *Transaction) MarshalJSON() ([]byte, error) {
+	enc := txJSON{
+		TxHash:           tx.TxHash,
+		From:             tx.From,
+		Nonce:            hexutil.Uint64(tx.Nonce),
+		GasLimit:         hexutil.Uint64(tx.GasLimit),
+		Value:            (*hexutil.Big)(tx.Value),
+		To:               tx.To,
+		Data:             tx.Data,
+		AccessList:       tx.AccessList,
+		Extend:           tx.Extend,
+		StrictAccessList: tx.StrictAccessList,
+	}
+	if typ := tx.Type(); typ != UnkownTx {
+		declared := hexutil.Uint64(typ)
+		enc.Type = &declared
+	}
+	if tx.GasPrice != nil {
+		enc.GasPrice = &gasPriceJSON{
+			Price:  (*hexutil.Big)(tx.GasPrice.Price),
+			FeeCap: (*hexutil.Big)(tx.GasPrice.FeeCap),
+			Tip:    (*hexutil.Big)(tx.GasPrice.Tip),
+		}
+	}
+	if tx.Validation != nil {
+		enc.Validation = &validationJSON{
+			R: (*hexutil.Big)(tx.Validation.R),
+			S: (*hexutil.Big)(tx.Validation.S),
+			V: (*hexutil.Big)(tx.Validation.V),
+		}
+	}
+	for _, coin := range tx.InputCoins {
+		enc.InputCoins = append(enc.InputCoins, inputCoinJSON{
+			TxHash:       coin.TxHash,
+			Index:        hexutil.Uint64(coin.Index),
+			Amount:       (*hexutil.Big)(coin.Amount),
+			WitnessIndex: hexutil.Uint64(coin.WitnessIndex),
+			Owner:        coin.Owner,
+		})
+	}
+	for _, witness := range tx.Witnesses {
+		enc.Witnesses = append(enc.Witnesses, witnessJSON{
+			PubKey:    witness.PubKey,
+			Signature: witness.Signature,
+		})
+	}
+	for _, coin := range tx.OutputCoins {
+		enc.OutputCoins = append(enc.OutputCoins, outputCoinJSON{
+			Amount: (*hexutil.Big)(coin.Amount),
+			Owner:  coin.Owner,
+		})
+	}
+	if tx.Refund != nil {
+		enc.Refund = (*hexutil.Big)(tx.Refund.Gas)
+	}
+	return json.Marshal(&enc)
+}
+
+// UnmarshalJSON unmarshals from the JSON produced by MarshalJSON.
+func (tx *Transaction) UnmarshalJSON(input []byte) error {
+	var dec txJSON
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	var (
+		preface = TxPreface{
+			TxHash:   dec.TxHash,
+			From:     dec.From,
+			Nonce:    uint64(dec.Nonce),
+			GasLimit: uint64(dec.GasLimit),
+			Value:    (*big.Int)(dec.Value),
+		}
+		inner = TxInner{
+			To:         dec.To,
+			Data:       dec.Data,
+			AccessList: dec.AccessList,
+		}
+		extends = TxExtends{
+			Extend:           dec.Extend,
+			StrictAccessList: dec.StrictAccessList,
+		}
+	)
+	if dec.GasPrice != nil {
+		preface.GasPrice = &gadget.GasPrice{
+			Price:  (*big.Int)(dec.GasPrice.Price),
+			FeeCap: (*big.Int)(dec.GasPrice.FeeCap),
+			Tip:    (*big.Int)(dec.GasPrice.Tip),
+		}
+	}
+	if dec.Validation != nil {
+		preface.Validation = &gadget.Validation{
+			R: (*big.Int)(dec.Validation.R),
+			S: (*big.Int)(dec.Validation.S),
+			V: (*big.Int)(dec.Validation.V),
+		}
+	}
+	for _, coin := range dec.InputCoins {
+		if coin.Index > math.MaxUint32 || coin.WitnessIndex > math.MaxUint32 {
+			return errCoinIndexOverflow
+		}
+		preface.InputCoins = append(preface.InputCoins, gadget.InputCoin{
+			TxHash:       coin.TxHash,
+			Index:        uint32(coin.Index),
+			Amount:       (*big.Int)(coin.Amount),
+			WitnessIndex: uint32(coin.WitnessIndex),
+			Owner:        coin.Owner,
+		})
+	}
+	for _, witness := range dec.Witnesses {
+		preface.Witnesses = append(preface.Witnesses, gadget.Witness{
+			PubKey:    witness.PubKey,
+			Signature: witness.Signature,
+		})
+	}
+	for _, coin := range dec.OutputCoins {
+		preface.OutputCoins = append(preface.OutputCoins, gadget.OutputCoin{
+			Amount: (*big.Int)(coin.Amount),
+			Owner:  coin.Owner,
+		})
+	}
+	if dec.Refund != nil {
+		extends.Refund = &gadget.Refund{Gas: (*big.Int)(dec.Refund)}
+	}
+	cpy := Transaction{TxPreface: preface, TxInner: inner, TxExtends: extends}
+	// Legacy JSON carries no type, leaving it to be inferred from the fields
+	if dec.Type != nil {
+		if *dec.Type > math.MaxUint8 {
+			return fmt.Errorf("%w: %d", ErrTxTypeNotSupported, *dec.Type)
+		}
+		if err := cpy.declareType(TxType(*dec.Type)); err != nil {
+			return err
+		}
+	}
+	tx.TxPreface, tx.TxInner, tx.TxExtends = preface, inner, extends
+	tx.typ, tx.typed = cpy.typ, cpy.typed
+	return nil
+}
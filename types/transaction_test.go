@@ -0,0 +1,617 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"execution/common"
+	"execution/crypto"
+	"execution/params"
+	"execution/types/gadget"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Tests that the canonical encoding of every transaction type survives a round
+// trip, producing the very same bytes when re-encoded.
+func TestTransactionRLPRoundTrip(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	inputCoins := []gadget.InputCoin{{
+		TxHash: common.GenerateHash([]byte("coin")),
+		Index:  1,
+		Amount: big.NewInt(1000),
+		Owner:  []byte("owner"),
+	}}
+	outputCoins := []gadget.OutputCoin{{
+		Amount: big.NewInt(1000),
+		Owner:  to,
+	}}
+	txs := map[string]*Transaction{
+		"normal":   NewNormalTransaction(1, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), []byte{0x01, 0x00, 0x02}, key),
+		"dynamic":  NewNormalTransaction(2, to, big.NewInt(100), 21000, gadget.NewDynamicGasPrice(big.NewInt(10), big.NewInt(2)), nil, key),
+		"withdraw": NewWithdrawTransaction(3, gadget.NewGasPrice(big.NewInt(1)), outputCoins, key),
+		"recharge": NewRechargeTransaction(inputCoins, []gadget.Witness{{}}, gadget.NewGasPrice(big.NewInt(1)), to),
+	}
+	txs["accesslist"] = NewNormalTransaction(4, to, big.NewInt(100), 30000, gadget.NewGasPrice(big.NewInt(1)), nil, key)
+	txs["accesslist"].AccessList = &gadget.AccessList{{Address: to, StorageKeys: []common.Hash{{0x01}}}}
+
+	for name, tx := range txs {
+		enc, err := tx.Serialize()
+		if err != nil {
+			t.Fatalf("%s: failed to encode transaction: %v", name, err)
+		}
+		if enc[0] != byte(tx.Type()) {
+			t.Errorf("%s: type byte mismatch: have %d, want %d", name, enc[0], tx.Type())
+		}
+		dec := new(Transaction)
+		if err := dec.Deserialize(enc); err != nil {
+			t.Fatalf("%s: failed to decode transaction: %v", name, err)
+		}
+		reenc, err := dec.Serialize()
+		if err != nil {
+			t.Fatalf("%s: failed to re-encode transaction: %v", name, err)
+		}
+		if !bytes.Equal(enc, reenc) {
+			t.Errorf("%s: encoding mismatch after round trip:\nhave %x\nwant %x", name, reenc, enc)
+		}
+		if dec.TxHash != tx.TxHash {
+			t.Errorf("%s: hash mismatch: have %x, want %x", name, dec.TxHash, tx.TxHash)
+		}
+		if dec.Type() != tx.Type() {
+			t.Errorf("%s: type mismatch: have %v, want %v", name, dec.Type(), tx.Type())
+		}
+		if dec.Cost().Cmp(tx.Cost()) != 0 {
+			t.Errorf("%s: cost mismatch: have %v, want %v", name, dec.Cost(), tx.Cost())
+		}
+	}
+}
+
+// Tests that the type byte of the envelope is honored where the fields are
+// ambiguous, rejected where it contradicts them, and that transactions embedded
+// in RLP streams keep their type.
+func TestTransactionEnvelope(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	// A transaction without sender nor coins can't be typed by its fields
+	ambiguous := &Transaction{TxPreface: TxPreface{GasPrice: gadget.NewGasPrice(big.NewInt(1))}, TxInner: TxInner{To: to}}
+	if typ := ambiguous.Type(); typ != UnkownTx {
+		t.Fatalf("ambiguous transaction type mismatch: have %d, want %d", typ, UnkownTx)
+	}
+	payload, err := ambiguous.Serialize()
+	if err != nil {
+		t.Fatalf("failed to encode transaction: %v", err)
+	}
+	var dec Transaction
+	if err := dec.Deserialize(append([]byte{byte(RechargeTx)}, payload...)); err != nil {
+		t.Fatalf("failed to decode typed transaction: %v", err)
+	}
+	if typ := dec.Type(); typ != RechargeTx {
+		t.Fatalf("declared type not honored: have %d, want %d", typ, RechargeTx)
+	}
+	// The declared type survives JSON, while legacy JSON falls back to inference
+	blob, err := json.Marshal(&dec)
+	if err != nil {
+		t.Fatalf("failed to marshal transaction: %v", err)
+	}
+	var fromJSON Transaction
+	if err := json.Unmarshal(blob, &fromJSON); err != nil {
+		t.Fatalf("failed to unmarshal transaction: %v", err)
+	}
+	if typ := fromJSON.Type(); typ != RechargeTx {
+		t.Fatalf("declared type lost in JSON: have %d, want %d", typ, RechargeTx)
+	}
+	legacy := strings.Replace(string(blob), `"type":"0x2",`, "", 1)
+	if legacy == string(blob) {
+		t.Fatalf("type missing from JSON: %s", blob)
+	}
+	fromJSON = Transaction{}
+	if err := json.Unmarshal([]byte(legacy), &fromJSON); err != nil {
+		t.Fatalf("failed to unmarshal legacy transaction: %v", err)
+	}
+	if typ := fromJSON.Type(); typ != UnkownTx {
+		t.Fatalf("legacy JSON type mismatch: have %d, want %d", typ, UnkownTx)
+	}
+	// A bare list decodes with the type inferred from the fields
+	dec = Transaction{}
+	if err := dec.Deserialize(payload); err != nil {
+		t.Fatalf("failed to decode untyped transaction: %v", err)
+	}
+	if typ := dec.Type(); typ != UnkownTx {
+		t.Fatalf("untyped transaction type mismatch: have %d, want %d", typ, UnkownTx)
+	}
+	// Declared types contradicting the fields are rejected
+	normal := NewNormalTransaction(0, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), nil, key)
+	enc, _ := normal.Serialize()
+	enc[0] = byte(WithdrawTx)
+	if err := new(Transaction).Deserialize(enc); !errors.Is(err, ErrTxTypeMismatch) {
+		t.Fatalf("expected %v, got %v", ErrTxTypeMismatch, err)
+	}
+	enc[0] = byte(UnkownTx)
+	if err := new(Transaction).Deserialize(enc); !errors.Is(err, ErrTxTypeNotSupported) {
+		t.Fatalf("expected %v, got %v", ErrTxTypeNotSupported, err)
+	}
+	// Transactions embedded in RLP lists round trip with their types
+	txs := Transactions{normal, &dec}
+	blob, err = rlp.EncodeToBytes(txs)
+	if err != nil {
+		t.Fatalf("failed to encode transaction list: %v", err)
+	}
+	var decs Transactions
+	if err := rlp.DecodeBytes(blob, &decs); err != nil {
+		t.Fatalf("failed to decode transaction list: %v", err)
+	}
+	for i := range txs {
+		if decs[i].Type() != txs[i].Type() || decs[i].Hash() != txs[i].Hash() {
+			t.Errorf("transaction %d mismatch after embedding: type %d hash %x, want type %d hash %x", i, decs[i].Type(), decs[i].Hash(), txs[i].Type(), txs[i].Hash())
+		}
+	}
+}
+
+// Tests that transactions survive a JSON round trip, including big integers which
+// don't fit into a double.
+func TestTransactionJSONRoundTrip(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	value := new(big.Int).Lsh(big.NewInt(1), 200)
+	inputCoins := []gadget.InputCoin{{
+		TxHash: common.GenerateHash([]byte("coin")),
+		Index:  1,
+		Amount: value,
+		Owner:  []byte("owner"),
+	}}
+	txs := map[string]*Transaction{
+		"normal":   NewNormalTransaction(1, to, value, 21000, gadget.NewDynamicGasPrice(value, big.NewInt(2)), []byte{0x01, 0x00, 0x02}, key),
+		"withdraw": NewWithdrawTransaction(2, gadget.NewGasPrice(big.NewInt(1)), []gadget.OutputCoin{{Amount: value, Owner: to}}, key),
+		"recharge": NewRechargeTransaction(inputCoins, []gadget.Witness{{}}, gadget.NewGasPrice(big.NewInt(1)), to),
+	}
+	txs["normal"].AccessList = &gadget.AccessList{{Address: to, StorageKeys: []common.Hash{{0x01}}}}
+	txs["normal"].Refund = &gadget.Refund{Gas: big.NewInt(100)}
+
+	for name, tx := range txs {
+		blob, err := json.Marshal(tx)
+		if err != nil {
+			t.Fatalf("%s: failed to marshal transaction: %v", name, err)
+		}
+		dec := new(Transaction)
+		if err := json.Unmarshal(blob, dec); err != nil {
+			t.Fatalf("%s: failed to unmarshal transaction: %v", name, err)
+		}
+		want, _ := tx.Serialize()
+		have, _ := dec.Serialize()
+		if !bytes.Equal(have, want) {
+			t.Errorf("%s: transaction mismatch after round trip:\nhave %x\nwant %x", name, have, want)
+		}
+		if dec.TxHash != tx.TxHash {
+			t.Errorf("%s: hash mismatch: have %x, want %x", name, dec.TxHash, tx.TxHash)
+		}
+	}
+	// Make sure big integers are hex quoted rather than plain numbers
+	blob, _ := json.Marshal(txs["normal"])
+	if !bytes.Contains(blob, []byte(`"value":"0x1`+strings.Repeat("0", 50)+`"`)) {
+		t.Errorf("value not hex encoded: %s", blob)
+	}
+}
+
+// Tests that the hash of a freshly created transaction only depends on its
+// contents, not on the instance being hashed.
+func TestTransactionHashDeterministic(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	a := NewNormalTransaction(0, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), []byte{0xff}, key)
+	b := NewNormalTransaction(0, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), []byte{0xff}, key)
+	if a.TxHash != b.TxHash {
+		t.Fatalf("hash mismatch for identical transactions: %x != %x", a.TxHash, b.TxHash)
+	}
+	c := NewNormalTransaction(0, to, big.NewInt(101), 21000, gadget.NewGasPrice(big.NewInt(1)), []byte{0xff}, key)
+	if a.TxHash == c.TxHash {
+		t.Fatalf("hash collision for different transactions: %x", a.TxHash)
+	}
+}
+
+// Tests that Hash is derived from the transaction contents and doesn't trust the
+// stored TxHash field, which is supplied by the sender.
+func TestTransactionHashIgnoresStoredField(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	tx := NewNormalTransaction(0, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), nil, key)
+	want := tx.TxHash
+
+	tx.TxHash = common.Hash{0x01}
+	if hash := tx.Hash(); hash != want {
+		t.Fatalf("hash mismatch: have %x, want %x", hash, want)
+	}
+	enc, err := tx.Serialize()
+	if err != nil {
+		t.Fatalf("failed to encode transaction: %v", err)
+	}
+	var dec Transaction
+	if err := dec.Deserialize(enc); err != nil {
+		t.Fatalf("failed to decode transaction: %v", err)
+	}
+	if dec.TxHash == want {
+		t.Fatalf("forged hash not preserved by the encoding")
+	}
+	if hash := dec.Hash(); hash != want {
+		t.Fatalf("decoded hash mismatch: have %x, want %x", hash, want)
+	}
+}
+
+// Tests that transactions without a canonical encoding report the error instead
+// of sharing a placeholder hash, and don't pass for small ones.
+func TestTransactionUnencodable(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	tx := NewNormalTransaction(0, to, big.NewInt(-1), 21000, gadget.NewGasPrice(big.NewInt(1)), nil, key)
+	if _, err := tx.ComputeHash(); err == nil {
+		t.Fatalf("negative value transaction hashed")
+	}
+	if (tx.TxHash != common.Hash{}) {
+		t.Fatalf("negative value transaction got a hash: %x", tx.TxHash)
+	}
+	if size := tx.Size(); size != math.MaxUint64 {
+		t.Fatalf("size mismatch: have %d, want %d", size, uint64(math.MaxUint64))
+	}
+	if _, err := tx.SigningHash(); err == nil {
+		t.Fatalf("negative value transaction got a signing hash")
+	}
+	if tx.Validation != nil {
+		t.Fatalf("negative value transaction signed")
+	}
+}
+
+// signingHash returns the signing hash of a transaction known to be encodable.
+func signingHash(t *testing.T, tx *Transaction) common.Hash {
+	t.Helper()
+
+	hash, err := tx.SigningHash()
+	if err != nil {
+		t.Fatalf("failed to derive signing hash: %v", err)
+	}
+	return hash
+}
+
+// Tests that the signing hash ignores the signature and the stored hash, while the
+// transaction hash commits to the signature it was created with.
+func TestSigningHash(t *testing.T) {
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	a := NewNormalTransaction(0, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), nil, key1)
+	b := NewNormalTransaction(0, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), nil, key2)
+	b.From = a.From
+
+	if signingHash(t, a) != signingHash(t, b) {
+		t.Fatalf("signing hash depends on the signature: %x != %x", signingHash(t, a), signingHash(t, b))
+	}
+	if a.TxHash == b.TxHash {
+		t.Fatalf("transaction hash ignores the signature: %x", a.TxHash)
+	}
+	if a.TxHash == signingHash(t, a) {
+		t.Fatalf("transaction hash equals the signing hash: %x", a.TxHash)
+	}
+	from, err := a.Validation.GetFrom(signingHash(t, a))
+	if err != nil {
+		t.Fatalf("failed to recover signer: %v", err)
+	}
+	if want := crypto.PubkeyToAddress(key1.PublicKey); from != want {
+		t.Fatalf("signer mismatch: have %x, want %x", from, want)
+	}
+}
+
+// Tests that the signing hash commits to the type and the signed fields of the
+// transaction, and that fields outside of it are reported as stray.
+func TestSigningHashFields(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	withdraw := NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), []gadget.OutputCoin{{Amount: big.NewInt(7), Owner: to}}, key)
+	if stray := withdraw.StrayFields(); len(stray) != 0 {
+		t.Fatalf("fresh withdrawal has stray fields: %v", stray)
+	}
+	// The same fields declared as another type must hash differently
+	normal := withdraw.Copy()
+	normal.setType(NormalTx)
+	if signingHash(t, normal) == signingHash(t, withdraw) {
+		t.Errorf("signing hash doesn't commit to the type")
+	}
+	// Signed fields must change the hash, unsigned ones must be reported
+	bumped := withdraw.Copy()
+	bumped.OutputCoins[0].Amount = big.NewInt(8)
+	if signingHash(t, bumped) == signingHash(t, withdraw) {
+		t.Errorf("signing hash doesn't commit to the output coins")
+	}
+	stuffed := withdraw.Copy()
+	stuffed.To, stuffed.Data = to, []byte{0x01}
+	if signingHash(t, stuffed) != signingHash(t, withdraw) {
+		t.Errorf("signing hash commits to fields a withdrawal doesn't sign")
+	}
+	if stray := stuffed.StrayFields(); !reflect.DeepEqual(stray, []string{"to", "data"}) {
+		t.Errorf("stray fields mismatch: have %v, want %v", stray, []string{"to", "data"})
+	}
+	if stray := normal.StrayFields(); !reflect.DeepEqual(stray, []string{"outputCoins"}) {
+		t.Errorf("stray fields mismatch: have %v, want %v", stray, []string{"outputCoins"})
+	}
+}
+
+// Tests that the sender is recovered from the signature and that the claimed From
+// field isn't trusted for transactions missing one.
+func TestSender(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	tx := NewNormalTransaction(0, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), nil, key)
+	if from, err := tx.Sender(); err != nil || from != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("sender mismatch: have %v (%v), want %v", from, err, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	unsigned := NewNormalTransaction(0, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), nil, key)
+	unsigned.Validation = nil
+	if _, err := unsigned.Sender(); !errors.Is(err, ErrMissingSignature) {
+		t.Fatalf("unsigned transaction error mismatch: have %v, want %v", err, ErrMissingSignature)
+	}
+	recharge := NewRechargeTransaction([]gadget.InputCoin{{Amount: big.NewInt(1)}}, nil, gadget.NewGasPrice(big.NewInt(1)), to)
+	if from, err := recharge.Sender(); err != nil || (from != common.Address{}) {
+		t.Fatalf("recharge sender mismatch: have %v (%v), want zero address", from, err)
+	}
+}
+
+// Tests that a copied transaction hashes and compares equal to the original, but
+// modifying it leaves the original untouched.
+func TestTransactionCopy(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	normal := NewNormalTransaction(0, to, big.NewInt(100), 21000, gadget.NewGasPrice(big.NewInt(1)), []byte{0x01, 0x02}, key)
+	normal.AccessList = &gadget.AccessList{{Address: to, StorageKeys: []common.Hash{{0x01}}}}
+
+	recharge := NewRechargeTransaction([]gadget.InputCoin{{Amount: big.NewInt(5), Owner: []byte{0x03}}},
+		[]gadget.Witness{{PubKey: []byte{0x04}, Signature: []byte{0x05}}}, gadget.NewGasPrice(big.NewInt(1)), to)
+
+	withdraw := NewWithdrawTransaction(1, gadget.NewGasPrice(big.NewInt(1)), []gadget.OutputCoin{{Amount: big.NewInt(7), Owner: to}}, key)
+
+	for i, tx := range []*Transaction{normal, recharge, withdraw} {
+		cpy := tx.Copy()
+		if cpy.Hash() != tx.Hash() {
+			t.Errorf("tx %d: copy hash mismatch: have %x, want %x", i, cpy.Hash(), tx.Hash())
+		}
+		if !cpy.Equal(tx) || !tx.Equal(cpy) {
+			t.Errorf("tx %d: copy not equal to original", i)
+		}
+		if cpy.Type() != tx.Type() {
+			t.Errorf("tx %d: copy type mismatch: have %v, want %v", i, cpy.Type(), tx.Type())
+		}
+	}
+	// Mutate the copies in place and ensure the originals are unaffected
+	cpy := normal.Copy()
+	cpy.Value.SetInt64(101)
+	cpy.GasPrice.Price.SetInt64(2)
+	cpy.Validation.S.SetInt64(1)
+	cpy.Data[0] = 0xff
+	(*cpy.AccessList)[0].StorageKeys[0] = common.Hash{0xff}
+	if normal.Value.Int64() != 100 || normal.GasPrice.Price.Int64() != 1 || normal.Validation.S.Int64() == 1 ||
+		normal.Data[0] != 0x01 || (*normal.AccessList)[0].StorageKeys[0] != (common.Hash{0x01}) {
+		t.Fatalf("mutating copy modified the original")
+	}
+	if cpy.Equal(normal) {
+		t.Fatalf("modified copy equal to original")
+	}
+	cpy = recharge.Copy()
+	cpy.InputCoins[0].Amount.SetInt64(6)
+	cpy.InputCoins[0].Owner[0] = 0xff
+	cpy.Witnesses[0].Signature[0] = 0xff
+	if recharge.InputCoins[0].Amount.Int64() != 5 || recharge.InputCoins[0].Owner[0] != 0x03 || recharge.Witnesses[0].Signature[0] != 0x05 {
+		t.Fatalf("mutating copy modified the original coins")
+	}
+	cpy = withdraw.Copy()
+	cpy.OutputCoins[0].Amount.SetInt64(8)
+	if withdraw.OutputCoins[0].Amount.Int64() != 7 {
+		t.Fatalf("mutating copy modified the original outputs")
+	}
+	// Missing amounts are not equal to zero ones
+	a, b := normal.Copy(), normal.Copy()
+	a.Value, b.Value = nil, new(big.Int)
+	if a.Equal(b) {
+		t.Fatalf("missing value equal to zero value")
+	}
+}
+
+func TestEffectiveGasTip(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	legacy := NewNormalTransaction(0, to, big.NewInt(0), 21000, gadget.NewGasPrice(big.NewInt(10)), nil, key)
+	dynamic := NewNormalTransaction(0, to, big.NewInt(0), 21000, gadget.NewDynamicGasPrice(big.NewInt(10), big.NewInt(3)), nil, key)
+
+	tests := []struct {
+		tx      *Transaction
+		baseFee *big.Int
+		tip     int64
+		err     error
+	}{
+		{legacy, nil, 10, nil},
+		{legacy, big.NewInt(4), 6, nil},
+		{legacy, big.NewInt(12), -2, ErrGasFeeCapTooLow},
+		{dynamic, nil, 3, nil},
+		{dynamic, big.NewInt(4), 3, nil},
+		{dynamic, big.NewInt(8), 2, nil},
+		{dynamic, big.NewInt(11), -1, ErrGasFeeCapTooLow},
+	}
+	for i, tt := range tests {
+		tip, err := tt.tx.EffectiveGasTip(tt.baseFee)
+		if err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		if tip.Cmp(big.NewInt(tt.tip)) != 0 {
+			t.Errorf("test %d: tip mismatch: have %v, want %v", i, tip, tt.tip)
+		}
+	}
+}
+
+// Tests that the access list is charged per address and storage key on top of
+// the base transaction gas.
+func TestIntrinsicGasAccessList(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	tx := NewNormalTransaction(0, to, big.NewInt(0), 100000, gadget.NewGasPrice(big.NewInt(1)), nil, key)
+	tx.AccessList = &gadget.AccessList{
+		{Address: common.Address{0x01}, StorageKeys: []common.Hash{{0x01}, {0x02}}},
+		{Address: common.Address{0x02}},
+	}
+	gas, err := tx.IntrinsicGas(nil)
+	if err != nil {
+		t.Fatalf("failed to compute intrinsic gas: %v", err)
+	}
+	if want := params.TxGas + 2*params.TxAccessListAddressGas + 2*params.TxAccessListStorageKeyGas; gas != want {
+		t.Fatalf("intrinsic gas mismatch: have %d, want %d", gas, want)
+	}
+}
+
+// Tests that the intrinsic gas is charged according to the chain's own gas
+// schedule if it configures one.
+func TestIntrinsicGasSchedule(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.BytesToAddress([]byte("to"))
+
+	tx := NewNormalTransaction(0, to, big.NewInt(0), 100000, gadget.NewGasPrice(big.NewInt(1)), []byte{0x00, 0x01}, key)
+	if gas, _ := tx.IntrinsicGas(&params.ChainConfig{}); gas != params.TxGas+params.TxDataZeroGas+params.TxDataNonZeroGas {
+		t.Errorf("default schedule gas mismatch: have %d, want %d", gas, params.TxGas+params.TxDataZeroGas+params.TxDataNonZeroGas)
+	}
+	schedule := params.DefaultGasSchedule()
+	schedule.TxGas, schedule.TxDataZeroGas, schedule.TxDataNonZeroGas = 1000, 10, 100
+
+	if gas, _ := tx.IntrinsicGas(&params.ChainConfig{Gas: &schedule}); gas != 1110 {
+		t.Errorf("custom schedule gas mismatch: have %d, want %d", gas, 1110)
+	}
+}
+
+func TestApplyRefund(t *testing.T) {
+	tests := []struct {
+		refund  *gadget.Refund
+		gasUsed uint64
+		want    uint64
+	}{
+		{nil, 100000, 0},
+		{&gadget.Refund{}, 100000, 0},
+		{&gadget.Refund{Gas: big.NewInt(-1)}, 100000, 0},
+		{&gadget.Refund{Gas: big.NewInt(15000)}, 100000, 15000},
+		{&gadget.Refund{Gas: big.NewInt(25000)}, 100000, 20000},
+		{&gadget.Refund{Gas: new(big.Int).Lsh(big.NewInt(1), 70)}, 100000, 20000},
+	}
+	for i, tt := range tests {
+		tx := &Transaction{TxExtends: TxExtends{Refund: tt.refund}}
+		if have := tx.ApplyRefund(tt.gasUsed); have != tt.want {
+			t.Errorf("test %d: refund mismatch: have %d, want %d", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that the cost of malformed and unknown transactions is never nil, so cost
+// accounting can't panic on them.
+func TestCostNeverNil(t *testing.T) {
+	tests := []struct {
+		tx   *Transaction
+		want int64
+	}{
+		{&Transaction{}, 0},
+		{&Transaction{TxPreface: TxPreface{From: common.Address{0x01}, GasLimit: 100}}, 0},
+		{&Transaction{TxPreface: TxPreface{From: common.Address{0x01}, GasLimit: 100, GasPrice: &gadget.GasPrice{}}}, 0},
+		{&Transaction{TxPreface: TxPreface{From: common.Address{0x01}, GasLimit: 100, GasPrice: gadget.NewGasPrice(big.NewInt(2))}}, 200},
+		{&Transaction{TxPreface: TxPreface{From: common.Address{0x01}, InputCoins: []gadget.InputCoin{{}}}}, 0},
+	}
+	for i, tt := range tests {
+		cost := tt.tx.Cost()
+		if cost == nil {
+			t.Fatalf("test %d: nil cost", i)
+		}
+		if cost.Cmp(big.NewInt(tt.want)) != 0 {
+			t.Errorf("test %d: cost mismatch: have %v, want %v", i, cost, tt.want)
+		}
+	}
+}
+
+func TestCostBreakdown(t *testing.T) {
+	price := gadget.NewGasPrice(big.NewInt(2))
+
+	withdraw := &Transaction{TxPreface: TxPreface{From: common.Address{0x01}, GasLimit: 100, GasPrice: price, OutputCoins: []gadget.OutputCoin{{Amount: big.NewInt(3)}, {Amount: big.NewInt(4)}}}}
+	withdraw.setType(WithdrawTx)
+
+	tests := []struct {
+		tx       *Transaction
+		gas      int64
+		transfer int64
+	}{
+		{&Transaction{}, 0, 0},
+		{&Transaction{TxPreface: TxPreface{From: common.Address{0x01}, GasLimit: 100, GasPrice: price, Value: big.NewInt(7)}}, 200, 7},
+		{&Transaction{TxPreface: TxPreface{GasLimit: 100, GasPrice: price, InputCoins: []gadget.InputCoin{{Amount: big.NewInt(9)}}}}, 200, 0},
+		{withdraw, 200, 7},
+	}
+	for i, tt := range tests {
+		gas, transfer := tt.tx.CostBreakdown()
+		if gas.Cmp(big.NewInt(tt.gas)) != 0 {
+			t.Errorf("test %d: gas cost mismatch: have %v, want %v", i, gas, tt.gas)
+		}
+		if transfer.Cmp(big.NewInt(tt.transfer)) != 0 {
+			t.Errorf("test %d: transfer mismatch: have %v, want %v", i, transfer, tt.transfer)
+		}
+		if cost := tt.tx.Cost(); cost.Cmp(new(big.Int).Add(gas, transfer)) != 0 {
+			t.Errorf("test %d: cost mismatch: have %v, want %v", i, cost, new(big.Int).Add(gas, transfer))
+		}
+	}
+}
+
+func TestCanonicalizeOutputs(t *testing.T) {
+	a, b := common.Address{0x01}, common.Address{0x02}
+	tx := &Transaction{TxPreface: TxPreface{OutputCoins: []gadget.OutputCoin{
+		{Amount: big.NewInt(1), Owner: b}, {Amount: big.NewInt(3), Owner: a}, {Owner: b}, {Amount: big.NewInt(2), Owner: a},
+	}}}
+	if tx.OutputsCanonical() {
+		t.Fatalf("unordered outputs reported canonical")
+	}
+	tx.CanonicalizeOutputs()
+	if !tx.OutputsCanonical() {
+		t.Fatalf("canonicalized outputs reported unordered")
+	}
+	want := []gadget.OutputCoin{{Amount: big.NewInt(2), Owner: a}, {Amount: big.NewInt(3), Owner: a}, {Owner: b}, {Amount: big.NewInt(1), Owner: b}}
+	for i, coin := range tx.OutputCoins {
+		if coin.Cmp(&want[i]) != 0 {
+			t.Errorf("output %d mismatch: have %v, want %v", i, coin, want[i])
+		}
+	}
+	// Constructors order the outputs without touching the caller's slice
+	key, _ := crypto.GenerateKey()
+	outputs := []gadget.OutputCoin{{Amount: big.NewInt(1), Owner: b}, {Amount: big.NewInt(1), Owner: a}}
+	if tx := NewWithdrawTransaction(0, gadget.NewGasPrice(big.NewInt(1)), outputs, key); !tx.OutputsCanonical() {
+		t.Fatalf("constructed withdrawal not canonical")
+	}
+	if outputs[0].Owner != b {
+		t.Fatalf("constructor reordered the caller's outputs")
+	}
+}
+
+func TestInputSum(t *testing.T) {
+	tx := &Transaction{TxPreface: TxPreface{InputCoins: []gadget.InputCoin{
+		{Amount: big.NewInt(1000)},
+		{},
+		{Amount: new(big.Int).Lsh(big.NewInt(1), 200)},
+	}}}
+	want := new(big.Int).Add(big.NewInt(1000), new(big.Int).Lsh(big.NewInt(1), 200))
+	if sum := tx.InputSum(); sum.Cmp(want) != 0 {
+		t.Fatalf("input sum mismatch: have %v, want %v", sum, want)
+	}
+	if sum := new(Transaction).InputSum(); sum.Sign() != 0 {
+		t.Fatalf("empty input sum mismatch: have %v, want 0", sum)
+	}
+}
@@ -2,9 +2,16 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
+
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
+// ErrInputLimit is returned by a decoder reading beyond the input limit it was
+// created with.
+var ErrInputLimit = errors.New("input limit exceeded")
+
 type Decoder interface {
 	Decode(val interface{}) error // read
 }
@@ -15,7 +22,7 @@ type Encoder interface {
 
 type Serializer interface {
 	GetEncoder(writer io.Writer) Encoder                    // write to writer
-	GetDecoder(reader io.Reader, inputLimit uint64) Decoder // read from reader
+	GetDecoder(reader io.Reader, inputLimit uint64) Decoder // read from reader, 0 for no limit
 }
 
 type JsonSerializer struct{}
@@ -25,5 +32,52 @@ func (s *JsonSerializer) GetEncoder(writer io.Writer) Encoder {
 }
 
 func (s *JsonSerializer) GetDecoder(reader io.Reader, inputLimit uint64) Decoder {
+	if inputLimit > 0 {
+		reader = &limitedReader{reader: reader, left: inputLimit}
+	}
 	return json.NewDecoder(reader)
 }
+
+// RlpSerializer encodes values as a stream of RLP items, which is considerably
+// more compact than JSON for binary heavy values like transactions.
+type RlpSerializer struct{}
+
+func (s *RlpSerializer) GetEncoder(writer io.Writer) Encoder {
+	return &rlpEncoder{writer: writer}
+}
+
+func (s *RlpSerializer) GetDecoder(reader io.Reader, inputLimit uint64) Decoder {
+	return rlp.NewStream(reader, inputLimit)
+}
+
+type rlpEncoder struct {
+	writer io.Writer
+}
+
+func (e *rlpEncoder) Encode(val interface{}) error {
+	return rlp.Encode(e.writer, val)
+}
+
+// limitedReader is like io.LimitedReader, but fails with ErrInputLimit instead
+// of faking the end of the input once the limit is exhausted.
+type limitedReader struct {
+	reader io.Reader
+	left   uint64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		// Reaching the limit right at the end of the input is fine
+		var probe [1]byte
+		if n, err := r.reader.Read(probe[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, ErrInputLimit
+	}
+	if uint64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.reader.Read(p)
+	r.left -= uint64(n)
+	return n, err
+}
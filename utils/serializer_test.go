@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// Tests that the JSON decoder refuses to read beyond its input limit, but still
+// accepts input ending right at the limit.
+func TestJsonDecoderInputLimit(t *testing.T) {
+	type item struct {
+		Data string `json:"data"`
+	}
+	var buf bytes.Buffer
+	serializer := new(JsonSerializer)
+	encoder := serializer.GetEncoder(&buf)
+	for _, data := range []string{"first", "second"} {
+		if err := encoder.Encode(item{data}); err != nil {
+			t.Fatalf("failed to encode item: %v", err)
+		}
+	}
+	input := buf.Bytes()
+	first := bytes.IndexByte(input, '\n') + 1
+
+	tests := []struct {
+		limit uint64
+		items int
+		err   error
+	}{
+		{0, 2, io.EOF},                             // no limit
+		{uint64(len(input)), 2, io.EOF},            // limit right at the end
+		{uint64(first), 1, ErrInputLimit},          // limit between items
+		{uint64(first + 5), 1, ErrInputLimit},      // limit in the middle of an item
+		{uint64(len(input) - 1), 2, ErrInputLimit}, // only the trailing newline cut off
+		{uint64(len(input) - 3), 1, ErrInputLimit}, // closing brace cut off
+	}
+	for i, tt := range tests {
+		decoder := serializer.GetDecoder(bytes.NewReader(input), tt.limit)
+
+		var (
+			items int
+			err   error
+		)
+		for {
+			var val item
+			if err = decoder.Decode(&val); err != nil {
+				break
+			}
+			items++
+		}
+		if items != tt.items || !errors.Is(err, tt.err) {
+			t.Errorf("test %d: decode mismatch: have %d items (%v), want %d items (%v)", i, items, err, tt.items, tt.err)
+		}
+	}
+}